@@ -1,8 +1,10 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gi8lino/unveil/internal/app"
 )
@@ -12,15 +14,46 @@ var (
 	Commit  = "none"
 )
 
+// exitCodes maps failure classes to exit codes, see "Exit codes" in README.md.
+var exitCodes = map[app.Class]int{
+	app.ClassError:            1,
+	app.ClassUsage:            2,
+	app.ClassSourceMissing:    3,
+	app.ClassSourceUnreadable: 4,
+	app.ClassParse:            5,
+	app.ClassKeyNotFound:      6,
+	app.ClassInvalidValue:     7,
+	app.ClassTimeout:          8,
+	app.ClassDrift:            1, // --check exits 0 or 1
+	app.ClassHookFailed:       10,
+	app.ClassInsecureDir:      11,
+	app.ClassInterrupted:      130,
+}
+
+// exitCode returns the exit code for err.
+func exitCode(err error) int {
+	if code, ok := exitCodes[app.Classify(err)]; ok {
+		return code
+	}
+	return 1
+}
+
 // main sets up the application context and runs the main loop.
+// SIGINT and SIGTERM cancel the context instead of killing the process, so
+// temp files are removed and hooks are stopped before exiting.
 func main() {
-	if err := app.Run(
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := app.Run(
+		ctx,
 		Version,
 		Commit,
 		os.Args[1:],
 		os.Stdout,
-	); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	)
+	stop()
+	if err != nil {
+		code := exitCode(err)
+		app.ReportError(os.Stderr, err, code)
+		os.Exit(code)
 	}
 }
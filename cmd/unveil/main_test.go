@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gi8lino/unveil/internal/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCodes(t *testing.T) {
+	t.Parallel()
+
+	seen := map[int]app.Class{}
+	for _, class := range app.Classes {
+		code, ok := exitCodes[class]
+		if !assert.True(t, ok, "no exit code for %s", class) {
+			continue
+		}
+		assert.NotZero(t, code, class)
+		if class == app.ClassDrift {
+			continue // shares 1 with ClassError, as --check always did
+		}
+		if other, dup := seen[code]; dup {
+			t.Errorf("exit code %d used by %s and %s", code, other, class)
+		}
+		seen[code] = class
+	}
+	assert.Len(t, exitCodes, len(app.Classes))
+}
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 1, exitCode(errors.New("boom")))
+	assert.Equal(t, 8, exitCode(fmt.Errorf("%w after 1s: %w", app.ErrTimeout, context.DeadlineExceeded)))
+	assert.Equal(t, 1, exitCode(fmt.Errorf("out.env: %w", app.ErrDrift)))
+	assert.Equal(t, 130, exitCode(fmt.Errorf("%w: %w", app.ErrInterrupted, context.Canceled)))
+}
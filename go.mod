@@ -3,14 +3,19 @@ module github.com/gi8lino/unveil
 go 1.25.0
 
 require (
+	filippo.io/age v1.3.1
 	github.com/containeroo/resolver v0.3.2
 	github.com/containeroo/tinyflags v0.0.80
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/pelletier/go-toml/v2 v2.4.3
 	github.com/stretchr/testify v1.12.1
+	gopkg.in/ini.v1 v1.67.3
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/pelletier/go-toml/v2 v2.4.3 // indirect
+	filippo.io/hpke v0.4.0 // indirect
 	go.yaml.in/yaml/v3 v3.0.5 // indirect
-	gopkg.in/ini.v1 v1.67.3 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/crypto v0.45.0 // indirect
+	golang.org/x/sys v0.38.0 // indirect
 )
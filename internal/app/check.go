@@ -0,0 +1,80 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/gi8lino/unveil/internal/output"
+	"github.com/gi8lino/unveil/internal/spec"
+)
+
+// ErrDrift is returned by --check when the output file is out of date.
+var ErrDrift = errors.New("output is out of date")
+
+// showValues returns which keys may be printed with their values: none unless
+// enabled, and never secret ones. Keys unknown to specs follow enabled.
+func showValues(specs []spec.ExtractSpec, enabled bool) func(key string) bool {
+	secret := make(map[string]bool)
+	for _, s := range specs {
+		if s.Secret {
+			secret[s.Var] = true
+		}
+	}
+	return func(key string) bool { return enabled && !secret[key] }
+}
+
+// checkOutput compares the file at path with what vars render to and prints
+// one line per added (+), removed (-) or changed (~) key to w. Values are only
+// printed for keys show allows. It returns ErrDrift if the file differs.
+func checkOutput(path string, vars []spec.Var, opts output.Options, show func(string) bool, w io.Writer) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading %q: %w", path, err)
+	}
+
+	changes, differs, err := output.Diff(existing, vars, opts)
+	if err != nil {
+		return err
+	}
+	if !differs {
+		return nil
+	}
+
+	for _, c := range changes {
+		if _, err := fmt.Fprintf(w, "%s %s\n", c.Kind, c.Key); err != nil {
+			return err
+		}
+		if !show(c.Key) {
+			continue
+		}
+		if err := printIndented(w, "  - ", c.Old); err != nil {
+			return err
+		}
+		if err := printIndented(w, "  + ", c.New); err != nil {
+			return err
+		}
+	}
+	if len(changes) == 0 {
+		if _, err := fmt.Fprintln(w, "~ formatting only (comments, order or whitespace)"); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("%s: %w", path, ErrDrift)
+}
+
+// printIndented prints every line of s with prefix; empty s prints nothing.
+func printIndented(w io.Writer, prefix, s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, line := range strings.Split(s, "\n") {
+		if _, err := fmt.Fprintln(w, prefix+line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_Check(t *testing.T) {
+	t.Parallel()
+
+	// setup writes a source with USER and TOKEN and returns args checking dst.
+	setup := func(t *testing.T, existing string) (args []string, dst string) {
+		t.Helper()
+		dir := t.TempDir()
+		src := filepath.Join(dir, "app.env")
+		dst = filepath.Join(dir, "out.env")
+		require.NoError(t, os.WriteFile(src, []byte("USER=bob\nTOKEN=s3cr3t\n"), 0o666))
+		if existing != "" {
+			require.NoError(t, os.WriteFile(dst, []byte(existing), 0o666))
+		}
+		return []string{
+			"--check",
+			"--output=" + dst,
+			"--file.user.path=" + src,
+			"--file.user.select=USER",
+			"--file.token.path=" + src,
+			"--file.token.select=TOKEN",
+			"--file.token.secret",
+		}, dst
+	}
+
+	t.Run("Up to date", func(t *testing.T) {
+		t.Parallel()
+		args, _ := setup(t, "TOKEN=s3cr3t\nUSER=bob\n")
+
+		var out bytes.Buffer
+		require.NoError(t, Run(context.Background(), "v", "c", args, &out))
+		assert.Empty(t, out.String())
+	})
+
+	t.Run("Drift is redacted by default", func(t *testing.T) {
+		t.Parallel()
+		existing := "OLD=1\nTOKEN=old\nUSER=alice\n"
+		args, dst := setup(t, existing)
+
+		var out bytes.Buffer
+		err := Run(context.Background(), "v", "c", args, &out)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrDrift)
+		assert.EqualError(t, err, dst+": output is out of date")
+		assert.Equal(t, "- OLD\n~ TOKEN\n~ USER\n", out.String())
+
+		got, err := os.ReadFile(dst)
+		require.NoError(t, err)
+		assert.Equal(t, existing, string(got), "check must not write")
+	})
+
+	t.Run("Show values keeps secrets redacted", func(t *testing.T) {
+		t.Parallel()
+		args, _ := setup(t, "TOKEN=old\nUSER=alice\n")
+
+		var out bytes.Buffer
+		err := Run(context.Background(), "v", "c", append(args, "--show-values"), &out)
+		require.ErrorIs(t, err, ErrDrift)
+		assert.Equal(t, "~ TOKEN\n~ USER\n  - USER=alice\n  + USER=bob\n", out.String())
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		t.Parallel()
+		args, dst := setup(t, "")
+
+		var out bytes.Buffer
+		err := Run(context.Background(), "v", "c", args, &out)
+		require.ErrorIs(t, err, ErrDrift)
+		assert.Equal(t, "+ TOKEN\n+ USER\n", out.String())
+		_, err = os.Stat(dst)
+		assert.True(t, os.IsNotExist(err), "check must not create the file")
+	})
+
+	t.Run("Formatting only", func(t *testing.T) {
+		t.Parallel()
+		args, _ := setup(t, "USER=bob\nTOKEN=s3cr3t\n")
+
+		var out bytes.Buffer
+		err := Run(context.Background(), "v", "c", args, &out)
+		require.ErrorIs(t, err, ErrDrift)
+		assert.Equal(t, "~ formatting only (comments, order or whitespace)\n", out.String())
+	})
+}
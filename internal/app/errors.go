@@ -0,0 +1,124 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gi8lino/unveil/internal/collector"
+	"github.com/gi8lino/unveil/internal/extract"
+	"github.com/gi8lino/unveil/internal/output"
+)
+
+// Class is the failure class of an error returned by Run.
+type Class string
+
+const (
+	ClassError            Class = "error" // any failure not listed below
+	ClassUsage            Class = "usage" // invalid command line
+	ClassSourceMissing    Class = Class(extract.ReasonMissing)
+	ClassSourceUnreadable Class = Class(extract.ReasonRead)
+	ClassParse            Class = Class(extract.ReasonParse)
+	ClassKeyNotFound      Class = Class(extract.ReasonNotFound)
+	ClassInvalidValue     Class = Class(extract.ReasonInvalid)
+	ClassTimeout          Class = "timeout"      // --timeout expired
+	ClassInterrupted      Class = "interrupted"  // canceled, e.g. by SIGINT
+	ClassDrift            Class = "out-of-date"  // --check found differences
+	ClassHookFailed       Class = "hook-failed"  // output written, on-change hook failed
+	ClassInsecureDir      Class = "insecure-dir" // secrets refused in a world-readable directory
+)
+
+// Classes lists all failure classes.
+var Classes = []Class{
+	ClassError, ClassUsage, ClassSourceMissing, ClassSourceUnreadable, ClassParse, ClassKeyNotFound,
+	ClassInvalidValue, ClassTimeout, ClassInterrupted, ClassDrift, ClassHookFailed, ClassInsecureDir,
+}
+
+// Classify returns the failure class of err.
+func Classify(err error) Class {
+	var usage *collector.UsageError
+	var extractErr *extract.Error
+	switch {
+	case errors.Is(err, ErrTimeout):
+		return ClassTimeout
+	case errors.Is(err, ErrInterrupted):
+		return ClassInterrupted
+	case errors.As(err, &usage):
+		return ClassUsage
+	case errors.As(err, &extractErr):
+		return Class(extractErr.Reason)
+	case errors.Is(err, ErrDrift):
+		return ClassDrift
+	case errors.Is(err, output.ErrHookFailed):
+		return ClassHookFailed
+	case errors.Is(err, output.ErrInsecureDir):
+		return ClassInsecureDir
+	default:
+		return ClassError
+	}
+}
+
+// Error formats of --error-format.
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// formattedError carries the --error-format of the Run that returned err.
+type formattedError struct {
+	err    error
+	format string
+}
+
+func (e *formattedError) Error() string { return e.err.Error() }
+func (e *formattedError) Unwrap() error { return e.err }
+
+// withFormat attaches format to err; nil stays nil.
+func withFormat(err error, format string) error {
+	if err == nil {
+		return nil
+	}
+	return &formattedError{err: err, format: format}
+}
+
+// requestedFormat returns the --error-format in args that could not be parsed.
+func requestedFormat(args []string) string {
+	for i, arg := range args {
+		if arg == "--error-format="+errorFormatJSON || (arg == "--error-format" && i+1 < len(args) && args[i+1] == errorFormatJSON) {
+			return errorFormatJSON
+		}
+	}
+	return errorFormatText
+}
+
+// errorObject is the JSON form of an error.
+type errorObject struct {
+	Error    string `json:"error"`
+	Class    Class  `json:"class"`
+	ExitCode int    `json:"exit_code"`
+	Kind     string `json:"kind,omitempty"`   // source kind of the failing variable
+	Var      string `json:"var,omitempty"`    // failing variable
+	Path     string `json:"path,omitempty"`   // source of the failing variable
+	Select   string `json:"select,omitempty"` // selector of the failing variable
+}
+
+// ReportError prints err, returned by Run, to w: as a line of text, or as a
+// JSON object if Run was called with --error-format json. code is the exit
+// status the process ends with.
+func ReportError(w io.Writer, err error, code int) {
+	var f *formattedError
+	if !errors.As(err, &f) || f.format != errorFormatJSON {
+		_, _ = fmt.Fprintln(w, err)
+		return
+	}
+
+	obj := errorObject{Error: err.Error(), Class: Classify(err), ExitCode: code}
+	var extractErr *extract.Error
+	if errors.As(err, &extractErr) {
+		s := extractErr.Spec
+		obj.Kind, obj.Var, obj.Path, obj.Select = string(s.Kind), s.Var, s.Path, s.Key
+	}
+	data, _ := json.Marshal(obj) // strings and ints always encode
+	_, _ = fmt.Fprintln(w, string(data))
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	require.NoError(t, os.WriteFile(good, []byte(`{"port": "http"}`), 0o600))
+	bad := filepath.Join(dir, "bad.json")
+	require.NoError(t, os.WriteFile(bad, []byte(`{`), 0o600))
+	out := filepath.Join(dir, "out.env")
+	require.NoError(t, os.WriteFile(out, []byte("PORT=ftp\n"), 0o600))
+
+	tests := []struct {
+		name  string
+		args  []string
+		class Class
+	}{
+		{name: "Unknown flag", args: []string{"--nope"}, class: ClassUsage},
+		{name: "Invalid instance", args: []string{"--json.a.path=" + good}, class: ClassUsage},
+		{name: "Missing file", args: []string{"--json.a.path=" + filepath.Join(dir, "nope.json"), "--json.a.select=port"}, class: ClassSourceMissing},
+		{name: "Unreadable file", args: []string{"--json.a.path=" + dir, "--json.a.select=port"}, class: ClassSourceUnreadable},
+		{name: "Parse error", args: []string{"--json.a.path=" + bad, "--json.a.select=port"}, class: ClassParse},
+		{name: "Key not found", args: []string{"--json.a.path=" + good, "--json.a.select=host"}, class: ClassKeyNotFound},
+		{name: "Invalid value", args: []string{"--json.a.path=" + good, "--json.a.select=port", "--json.a.type=port"}, class: ClassInvalidValue},
+		{name: "Drift", args: []string{"--check", "--output=" + out, "--json.port.path=" + good, "--json.port.select=port"}, class: ClassDrift},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := Run(context.Background(), "v", "c", tt.args, &bytes.Buffer{})
+			require.Error(t, err)
+			assert.Equal(t, tt.class, Classify(err))
+		})
+	}
+
+	t.Run("Other errors", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, ClassError, Classify(errors.New("boom")))
+	})
+}
+
+func TestReportError(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"token": "hunter2"}`), 0o600))
+
+	t.Run("Text by default", func(t *testing.T) {
+		t.Parallel()
+		err := Run(context.Background(), "v", "c", []string{"--json.a.path=" + path, "--json.a.select=host"}, &bytes.Buffer{})
+		require.Error(t, err)
+
+		var buf bytes.Buffer
+		ReportError(&buf, err, 6)
+		assert.Equal(t, `json "A" (path="`+path+`"): selecting "host": key "host" not found`+"\n", buf.String())
+	})
+
+	t.Run("JSON with the failing variable", func(t *testing.T) {
+		t.Parallel()
+		args := []string{"--error-format=json", "--json.a.path=" + path, "--json.a.select=token", "--json.a.type=int", "--json.a.secret"}
+		err := Run(context.Background(), "v", "c", args, &bytes.Buffer{})
+		require.Error(t, err)
+
+		var buf bytes.Buffer
+		ReportError(&buf, err, 7)
+		var got map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+		assert.Equal(t, map[string]any{
+			"error":     `json "A": invalid value "***": not a valid int`,
+			"class":     "invalid-value",
+			"exit_code": float64(7),
+			"kind":      "json",
+			"var":       "A",
+			"path":      path,
+			"select":    "token",
+		}, got)
+	})
+
+	t.Run("JSON for arguments that do not parse", func(t *testing.T) {
+		t.Parallel()
+		err := Run(context.Background(), "v", "c", []string{"--error-format", "json", "--nope"}, &bytes.Buffer{})
+		require.Error(t, err)
+
+		var buf bytes.Buffer
+		ReportError(&buf, err, 2)
+		var got map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+		assert.Equal(t, "usage", got["class"])
+		assert.Equal(t, float64(2), got["exit_code"])
+		assert.NotContains(t, got, "var")
+	})
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gi8lino/unveil/internal/extract"
+	"github.com/gi8lino/unveil/internal/redact"
+)
+
+// explain prints the provenance of every variable to w. Values are only
+// printed for variables show allows.
+func explain(w io.Writer, origins []extract.Origin, show func(string) bool) {
+	for _, o := range origins {
+		s := o.Spec
+		_, _ = fmt.Fprintf(w, "%s:\n", s.Var)
+		if s.RawVar != "" {
+			printField(w, "renamed", fmt.Sprintf("from %q", s.RawVar))
+		}
+
+		source := string(s.Kind) + " " + o.Path
+		if o.Path != s.Path {
+			source += fmt.Sprintf(" (from %q)", s.Path)
+		}
+		printField(w, "source", source)
+
+		selector := fmt.Sprintf("%q", s.Key)
+		if s.Key == "" {
+			selector = "(whole file)"
+		}
+		printField(w, "select", selector)
+
+		location := "unknown"
+		if o.Line > 0 {
+			location = fmt.Sprintf("line %d, column %d", o.Line, o.Column)
+		}
+		printField(w, "location", location)
+
+		transforms := "none"
+		if len(o.Transforms) > 0 {
+			transforms = strings.Join(o.Transforms, ", ")
+		}
+		printField(w, "transforms", transforms)
+
+		quoteMode := string(s.Quote)
+		if quoteMode == "" {
+			quoteMode = "none"
+		}
+		printField(w, "quote", quoteMode)
+		value := redact.Placeholder
+		if show(s.Var) {
+			value = fmt.Sprintf("%q", o.Value)
+		}
+		printField(w, "value", value)
+	}
+}
+
+// printField prints one indented "name: value" line of explain.
+func printField(w io.Writer, name, value string) {
+	_, _ = fmt.Fprintf(w, "  %-11s %s\n", name+":", value)
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gi8lino/unveil/internal/extract"
+	"github.com/gi8lino/unveil/internal/quote"
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+
+	origins := []extract.Origin{
+		{
+			Spec:       spec.ExtractSpec{Kind: spec.KindYAML, Path: "$CFG/app.yaml", Key: "db.port", Var: "PORT", Quote: quote.QuoteDouble},
+			Path:       "/etc/app.yaml",
+			Line:       3,
+			Column:     9,
+			Transforms: []string{"normalized"},
+			Value:      "31",
+		},
+		{
+			Spec:  spec.ExtractSpec{Kind: spec.KindFILE, Path: "/run/secrets/token", Var: "api_token", RawVar: "api-token", Secret: true},
+			Path:  "/run/secrets/token",
+			Value: "hunter2",
+		},
+	}
+
+	t.Run("Values redacted", func(t *testing.T) {
+		t.Parallel()
+		var out bytes.Buffer
+		explain(&out, origins, showValues([]spec.ExtractSpec{origins[0].Spec, origins[1].Spec}, false))
+		assert.Equal(t, ""+
+			"PORT:\n"+
+			"  source:     yaml /etc/app.yaml (from \"$CFG/app.yaml\")\n"+
+			"  select:     \"db.port\"\n"+
+			"  location:   line 3, column 9\n"+
+			"  transforms: normalized\n"+
+			"  quote:      double\n"+
+			"  value:      ***\n"+
+			"api_token:\n"+
+			"  renamed:    from \"api-token\"\n"+
+			"  source:     file /run/secrets/token\n"+
+			"  select:     (whole file)\n"+
+			"  location:   unknown\n"+
+			"  transforms: none\n"+
+			"  quote:      none\n"+
+			"  value:      ***\n", out.String())
+	})
+
+	t.Run("Show values except secrets", func(t *testing.T) {
+		t.Parallel()
+		var out bytes.Buffer
+		explain(&out, origins, showValues([]spec.ExtractSpec{origins[0].Spec, origins[1].Spec}, true))
+		assert.Contains(t, out.String(), "  value:      \"31\"\n")
+		assert.NotContains(t, out.String(), "hunter2")
+	})
+}
@@ -1,18 +1,34 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"slices"
+	"time"
 
 	"github.com/containeroo/tinyflags"
 	"github.com/gi8lino/unveil/internal/collector"
-	"github.com/gi8lino/unveil/internal/extract"
 	"github.com/gi8lino/unveil/internal/flag"
 	"github.com/gi8lino/unveil/internal/output"
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/gi8lino/unveil/unveil"
+)
+
+// Errors returned by Run when reading the sources did not finish.
+var (
+	ErrTimeout     = errors.New("timed out reading sources") // --timeout expired
+	ErrInterrupted = errors.New("interrupted")               // ctx was canceled, e.g. by SIGINT
 )
 
 // Run parses flags, builds specs, resolves values, and writes KEY=VAL lines.
+// Canceling ctx stops reading the sources, the watch loop and hooks; a file
+// being written is either replaced completely or left untouched. Errors are
+// printed with ReportError.
 func Run(
+	ctx context.Context,
 	version, commit string,
 	args []string,
 	w io.Writer,
@@ -24,9 +40,13 @@ func Run(
 			_, _ = fmt.Fprintln(w, err)
 			return nil
 		}
-		return err
+		return withFormat(&collector.UsageError{Err: err}, requestedFormat(args))
 	}
+	return withFormat(run(ctx, flags, w), flags.ErrorFormat)
+}
 
+// run implements Run for parsed flags.
+func run(ctx context.Context, flags flag.Flags, w io.Writer) error {
 	// collect specs
 	specs, err := collector.Collect(&flags)
 	if err != nil {
@@ -35,17 +55,112 @@ func Run(
 	if len(specs) == 0 {
 		return nil
 	}
+	reportRenames(os.Stderr, specs)
 
 	// resolve values
-	kv, err := extract.ExtractAll(specs)
+	r := resolver{timeout: flags.Timeout, parallel: flags.Parallel}
+	res, err := r.resolve(ctx, specs)
 	if err != nil {
 		return err
 	}
+	read := resolved(res.Origins)
+	if flags.Explain {
+		explain(os.Stderr, res.Origins, showValues(read, flags.ShowValues))
+	}
 
 	// write output (atomic file or stdout)
-	if flags.Output != "" {
-		return output.WriteEnvLinesAtomic(flags.Output, kv, flags.Export)
+	opts := unveil.Options{Format: flags.Format, Shell: flags.Shell, Export: flags.Export, Merge: flags.Merge, Order: flags.Order}
+	if flags.Output == "" {
+		return unveil.Write(w, res, opts)
+	}
+	if flags.Check {
+		return checkOutput(flags.Output, res.Vars, opts, showValues(read, flags.ShowValues), w)
+	}
+
+	if !flags.Hook.IsZero() {
+		h := flags.Hook
+		h.Stderr = os.Stderr
+		opts.OnWrite = func() error { return h.Fire(ctx) }
+	}
+	opts.File = flags.File
+	opts.File.Private = hasSecrets(read) && !flags.AllowInsecure
+	if !flags.Watch {
+		return insecureHint(unveil.WriteFile(flags.Output, res, opts))
+	}
+
+	// keep the output in sync until interrupted
+	watcher, err := newWatcher(specs)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = watcher.Close() }()
+
+	data, err := unveil.Render(res, opts)
+	if err != nil {
+		return err
+	}
+	if err := unveil.WriteFile(flags.Output, res, opts); err != nil {
+		if !errors.Is(err, output.ErrHookFailed) {
+			return insecureHint(err)
+		}
+		_, _ = fmt.Fprintln(os.Stderr, err)
+	}
+	return watchOutput(ctx, watcher, flags.Output, specs, opts, data, flags.Debounce, r, os.Stderr)
+}
+
+// resolver extracts specs with the limits from the command line.
+type resolver struct {
+	timeout  time.Duration // give up after this long if positive
+	parallel int           // number of sources read at once
+}
+
+// resolve extracts specs.
+func (r resolver) resolve(ctx context.Context, specs []spec.ExtractSpec) (unveil.Result, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	res, err := unveil.ExtractParallel(ctx, specs, r.parallel)
+	// Only the end of ctx itself ends the run; a request timing out on its
+	// own is a failure of its source.
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return unveil.Result{}, fmt.Errorf("%w after %s: %w", ErrTimeout, r.timeout, err)
+	case errors.Is(err, context.Canceled) && errors.Is(ctx.Err(), context.Canceled):
+		return unveil.Result{}, fmt.Errorf("%w: %w", ErrInterrupted, err)
+	}
+	return res, err
+}
+
+// reportRenames prints the variables renamed by --sanitize-names.
+func reportRenames(w io.Writer, specs []spec.ExtractSpec) {
+	for _, s := range specs {
+		if s.RawVar != "" {
+			_, _ = fmt.Fprintf(w, "renamed variable %q to %q\n", s.RawVar, s.Var)
+		}
 	}
+}
 
-	return output.WriteEnvLines(w, kv, flags.Export)
+// resolved returns the specs of origins as reading left them: values
+// decrypted from SOPS files are secret too.
+func resolved(origins []unveil.Origin) []spec.ExtractSpec {
+	specs := make([]spec.ExtractSpec, len(origins))
+	for i, o := range origins {
+		specs[i] = o.Spec
+	}
+	return specs
+}
+
+// hasSecrets reports whether any spec is marked secret.
+func hasSecrets(specs []spec.ExtractSpec) bool {
+	return slices.ContainsFunc(specs, func(s spec.ExtractSpec) bool { return s.Secret })
+}
+
+// insecureHint adds the flag overriding output.ErrInsecureDir to err.
+func insecureHint(err error) error {
+	if errors.Is(err, output.ErrInsecureDir) {
+		return fmt.Errorf("%w (use --allow-insecure-dir to write anyway)", err)
+	}
+	return err
 }
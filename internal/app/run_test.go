@@ -2,11 +2,18 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/gi8lino/unveil/internal/output"
+	"github.com/gi8lino/unveil/internal/spec"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -19,7 +26,7 @@ func TestRun_HelpRequested(t *testing.T) {
 	t.Parallel()
 
 	var out bytes.Buffer
-	err := Run("1.0.0", "abc", []string{"--help"}, &out)
+	err := Run(context.Background(), "1.0.0", "abc", []string{"--help"}, &out)
 	require.NoError(t, err)
 	// tinyflags prints a help message to stdout; just ensure something was printed
 	assert.NotEmpty(t, out.String())
@@ -29,7 +36,7 @@ func TestRun_VersionRequested(t *testing.T) {
 	t.Parallel()
 
 	var out bytes.Buffer
-	err := Run("9.9.9", "deadbeef", []string{"--version"}, &out)
+	err := Run(context.Background(), "9.9.9", "deadbeef", []string{"--version"}, &out)
 	require.NoError(t, err)
 	// Version text should be printed; don't depend on exact wording
 	assert.Equal(t, "9.9.9\n", out.String())
@@ -42,7 +49,7 @@ func TestRun_ParseError_MissingRequired(t *testing.T) {
 	t.Run("Missing required --json.id.select", func(t *testing.T) {
 		var out bytes.Buffer
 		// Missing required --json.id.select
-		err := Run("v", "c", []string{"--json.id.path=./cfg.json"}, &out)
+		err := Run(context.Background(), "v", "c", []string{"--json.id.path=./cfg.json"}, &out)
 		require.Error(t, err)
 		assert.Empty(t, out.String())
 	})
@@ -51,7 +58,7 @@ func TestRun_ParseError_MissingRequired(t *testing.T) {
 		t.Parallel()
 
 		var out bytes.Buffer
-		err := Run("v", "c", []string{}, &out)
+		err := Run(context.Background(), "v", "c", []string{}, &out)
 		require.NoError(t, err)
 		assert.Equal(t, "", out.String())
 	})
@@ -89,7 +96,7 @@ func TestRun_ParseError_MissingRequired(t *testing.T) {
 		}
 
 		var out bytes.Buffer
-		err := Run("v", "c", args, &out)
+		err := Run(context.Background(), "v", "c", args, &out)
 		require.NoError(t, err)
 
 		// Output must be sorted by KEY alphabetically
@@ -115,7 +122,7 @@ func TestRun_ParseError_MissingRequired(t *testing.T) {
 		}
 
 		var out bytes.Buffer
-		err := Run("v", "c", args, &out)
+		err := Run(context.Background(), "v", "c", args, &out)
 		require.Error(t, err)
 		assert.Empty(t, out.String())
 	})
@@ -134,7 +141,7 @@ func TestRun_ParseError_MissingRequired(t *testing.T) {
 		}
 
 		w := &errWriter{err: errors.New("sink broken")}
-		err := Run("v", "c", args, w)
+		err := Run(context.Background(), "v", "c", args, w)
 		require.Error(t, err)
 	})
 
@@ -147,7 +154,7 @@ func TestRun_ParseError_MissingRequired(t *testing.T) {
 		}
 
 		var out bytes.Buffer
-		err := Run("v", "c", args, &out)
+		err := Run(context.Background(), "v", "c", args, &out)
 		require.Error(t, err)
 		assert.EqualError(t, err, "unknown dynamic group \"unknown\" in flag --unknown.a.path=unknown.txt\nunknown dynamic group \"unknown\" in flag --unknown.a.select=k\nunknown dynamic group \"unknown\" in flag --unknown.a.as=K")
 	})
@@ -168,7 +175,7 @@ func TestRun_ParseError_MissingRequired(t *testing.T) {
 		}
 
 		var out bytes.Buffer
-		err := Run("v", "c", args, &out)
+		err := Run(context.Background(), "v", "c", args, &out)
 		require.NoError(t, err)
 
 		got, err := os.ReadFile(dst)
@@ -178,4 +185,314 @@ func TestRun_ParseError_MissingRequired(t *testing.T) {
 		assert.Equal(t, want, string(got))
 		assert.Equal(t, "", out.String())
 	})
+	t.Run("Shell dialect output", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		src := filepath.Join(dir, "app.env")
+		require.NoError(t, os.WriteFile(src, []byte("TOKEN=it's\n"), 0o666))
+
+		args := []string{
+			"--shell=fish",
+			"--file.env.path=" + src,
+			"--file.env.select=TOKEN",
+		}
+
+		var out bytes.Buffer
+		err := Run(context.Background(), "v", "c", args, &out)
+		require.NoError(t, err)
+		assert.Equal(t, "set -gx ENV 'it\\'s'\n", out.String())
+	})
+
+	t.Run("systemd format", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		src := filepath.Join(dir, "app.env")
+		require.NoError(t, os.WriteFile(src, []byte("TOKEN='a \"b\" $c'\n"), 0o666))
+
+		args := []string{
+			"--format=systemd",
+			"--file.env.path=" + src,
+			"--file.env.select=TOKEN",
+		}
+
+		var out bytes.Buffer
+		err := Run(context.Background(), "v", "c", args, &out)
+		require.NoError(t, err)
+		assert.Equal(t, "ENV=\"a \\\"b\\\" \\$c\"\n", out.String())
+	})
+
+	t.Run("On-change hook runs after write", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		src := filepath.Join(dir, "app.env")
+		dst := filepath.Join(dir, "out.env")
+		marker := filepath.Join(dir, "marker")
+		require.NoError(t, os.WriteFile(src, []byte("TOKEN=x\n"), 0o666))
+
+		args := []string{
+			"--file.env.path=" + src,
+			"--file.env.select=TOKEN",
+			"--output=" + dst,
+			"--on-change-exec=cp " + dst + " " + marker,
+		}
+
+		var out bytes.Buffer
+		err := Run(context.Background(), "v", "c", args, &out)
+		require.NoError(t, err)
+		got, err := os.ReadFile(marker)
+		require.NoError(t, err)
+		assert.Equal(t, "ENV=x\n", string(got))
+	})
+
+	t.Run("On-change hook failure", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		src := filepath.Join(dir, "app.env")
+		require.NoError(t, os.WriteFile(src, []byte("TOKEN=x\n"), 0o666))
+
+		args := []string{
+			"--file.env.path=" + src,
+			"--file.env.select=TOKEN",
+			"--output=" + filepath.Join(dir, "out.env"),
+			"--on-change-exec=exit 1",
+			"--on-change-retries=0",
+		}
+
+		var out bytes.Buffer
+		err := Run(context.Background(), "v", "c", args, &out)
+		require.Error(t, err)
+		assert.EqualError(t, err, `on-change hook failed: running "exit 1": exit status 1`)
+	})
+
+	t.Run("Merge into existing file", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		src := filepath.Join(dir, "app.env")
+		dst := filepath.Join(dir, ".env")
+		require.NoError(t, os.WriteFile(src, []byte("TOKEN=new\n"), 0o666))
+		require.NoError(t, os.WriteFile(dst, []byte("# local settings\nDEBUG=1\nAPI_TOKEN=old\n"), 0o666))
+
+		args := []string{
+			"--merge",
+			"--output=" + dst,
+			"--file.env.path=" + src,
+			"--file.env.select=TOKEN",
+			"--file.env.as=API_TOKEN",
+		}
+
+		var out bytes.Buffer
+		require.NoError(t, Run(context.Background(), "v", "c", args, &out))
+		got, err := os.ReadFile(dst)
+		require.NoError(t, err)
+		assert.Equal(t, "# local settings\nDEBUG=1\nAPI_TOKEN=new\n", string(got))
+	})
+
+	t.Run("Declared order", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		src := filepath.Join(dir, "app.env")
+		require.NoError(t, os.WriteFile(src, []byte("HOST=h\nURL=http://$HOST\n"), 0o666))
+
+		args := []string{
+			"--order=declared",
+			"--file.z.path=" + src,
+			"--file.z.select=HOST",
+			"--file.a.path=" + src,
+			"--file.a.select=URL",
+		}
+
+		var out bytes.Buffer
+		require.NoError(t, Run(context.Background(), "v", "c", args, &out))
+		assert.Equal(t, "Z=h\nA=http://$HOST\n", out.String())
+	})
+
+	t.Run("Invalid variable name", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		src := filepath.Join(dir, "app.env")
+		require.NoError(t, os.WriteFile(src, []byte("TOKEN=x\n"), 0o666))
+
+		args := []string{
+			"--file.env.path=" + src,
+			"--file.env.select=TOKEN",
+			"--file.env.as=my-token",
+		}
+
+		var out bytes.Buffer
+		err := Run(context.Background(), "v", "c", args, &out)
+		require.Error(t, err)
+		assert.EqualError(t, err, `--file.env.as: invalid variable name "my-token": must be a POSIX identifier ([A-Za-z_][A-Za-z0-9_]*) (use --sanitize-names to rewrite it)`)
+	})
+
+	t.Run("Sanitized variable name", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		src := filepath.Join(dir, "app.env")
+		require.NoError(t, os.WriteFile(src, []byte("TOKEN=x\n"), 0o666))
+
+		args := []string{
+			"--sanitize-names",
+			"--file.env.path=" + src,
+			"--file.env.select=TOKEN",
+			"--file.env.as=my-token",
+		}
+
+		var out bytes.Buffer
+		require.NoError(t, Run(context.Background(), "v", "c", args, &out))
+		assert.Equal(t, "my_token=x\n", out.String())
+	})
+}
+
+func TestRun_OutputFile(t *testing.T) {
+	t.Parallel()
+
+	setup := func(t *testing.T, dirMode os.FileMode) (src, dst string) {
+		t.Helper()
+		dir := t.TempDir()
+		require.NoError(t, os.Chmod(dir, dirMode))
+		src = filepath.Join(dir, "app.env")
+		require.NoError(t, os.WriteFile(src, []byte("TOKEN=x\n"), 0o600))
+		return src, filepath.Join(dir, "out.env")
+	}
+
+	t.Run("Mode", func(t *testing.T) {
+		t.Parallel()
+		src, dst := setup(t, 0o700)
+
+		args := []string{"--output=" + dst, "--output-mode=0640", "--file.env.path=" + src, "--file.env.select=TOKEN"}
+		require.NoError(t, Run(context.Background(), "v", "c", args, &bytes.Buffer{}))
+		info, err := os.Stat(dst)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+	})
+
+	t.Run("Secret refused in world-readable directory", func(t *testing.T) {
+		t.Parallel()
+		src, dst := setup(t, 0o755)
+
+		args := []string{"--output=" + dst, "--file.env.path=" + src, "--file.env.select=TOKEN", "--file.env.secret"}
+		err := Run(context.Background(), "v", "c", args, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.EqualError(t, err, `refusing to write secrets into world-readable directory "`+filepath.Dir(dst)+`" (use --allow-insecure-dir to write anyway)`)
+	})
+
+	t.Run("Secret forced into world-readable directory", func(t *testing.T) {
+		t.Parallel()
+		src, dst := setup(t, 0o755)
+
+		args := []string{"--output=" + dst, "--allow-insecure-dir", "--file.env.path=" + src, "--file.env.select=TOKEN", "--file.env.secret"}
+		require.NoError(t, Run(context.Background(), "v", "c", args, &bytes.Buffer{}))
+	})
+
+	t.Run("SOPS values refused in world-readable directory", func(t *testing.T) {
+		t.Parallel()
+		_, dst := setup(t, 0o755)
+
+		args := []string{"--output=" + dst, "--yaml.a.path=../sops/testdata/app.enc.yaml", "--yaml.a.select=db.password", "--yaml.a.identity=../sops/testdata/key.txt"}
+		err := Run(context.Background(), "v", "c", args, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, output.ErrInsecureDir)
+	})
+
+	t.Run("No secrets in world-readable directory", func(t *testing.T) {
+		t.Parallel()
+		src, dst := setup(t, 0o755)
+
+		args := []string{"--output=" + dst, "--file.env.path=" + src, "--file.env.select=TOKEN"}
+		require.NoError(t, Run(context.Background(), "v", "c", args, &bytes.Buffer{}))
+	})
+}
+
+func TestRun_Parallel(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var args []string
+	for _, name := range []string{"c", "a", "d", "b"} {
+		path := filepath.Join(dir, name+".env")
+		require.NoError(t, os.WriteFile(path, []byte("V="+name+"\n"), 0o600))
+		args = append(args, "--file."+name+".path="+path, "--file."+name+".select=V")
+	}
+
+	var serial, parallel bytes.Buffer
+	require.NoError(t, Run(context.Background(), "v", "c", append(args, "--order=declared"), &serial))
+	require.NoError(t, Run(context.Background(), "v", "c", append(args, "--order=declared", "--parallel=3"), &parallel))
+	assert.Equal(t, "C=c\nA=a\nD=d\nB=b\n", serial.String())
+	assert.Equal(t, serial.String(), parallel.String())
+}
+
+func TestRun_Timeout(t *testing.T) {
+	t.Parallel()
+
+	setup := func(t *testing.T) (fifo, dst string) {
+		t.Helper()
+		dir := t.TempDir()
+		fifo = filepath.Join(dir, "fifo")
+		require.NoError(t, syscall.Mkfifo(fifo, 0o600)) // reading blocks without a writer
+		return fifo, filepath.Join(dir, "out.env")
+	}
+
+	t.Run("Timeout", func(t *testing.T) {
+		t.Parallel()
+		fifo, dst := setup(t)
+
+		args := []string{"--output=" + dst, "--timeout=50ms", "--file.x.path=" + fifo, "--file.x.select=X"}
+		err := Run(context.Background(), "v", "c", args, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrTimeout)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.EqualError(t, err, `timed out reading sources after 50ms: file "X" (path="`+fifo+`"): reading file: context deadline exceeded`)
+		assert.NoFileExists(t, dst)
+	})
+
+	t.Run("Interrupted", func(t *testing.T) {
+		t.Parallel()
+		fifo, dst := setup(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		args := []string{"--output=" + dst, "--file.x.path=" + fifo, "--file.x.select=X"}
+		err := Run(ctx, "v", "c", args, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrInterrupted)
+		assert.NotErrorIs(t, err, ErrTimeout)
+
+		entries, err := os.ReadDir(filepath.Dir(dst))
+		require.NoError(t, err)
+		require.Len(t, entries, 1) // only the fifo: no output or temp file
+	})
+	t.Run("Request timeout is a source failure", func(t *testing.T) {
+		t.Parallel()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done() // hang until the client gives up
+		}))
+		t.Cleanup(srv.Close)
+
+		args := []string{"--json.a.path=" + srv.URL + "/app.json", "--json.a.select=port", "--json.a.timeout=50ms"}
+		err := Run(context.Background(), "v", "c", args, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrTimeout)
+		assert.Equal(t, ClassSourceUnreadable, Classify(err))
+		assert.ErrorContains(t, err, "request timed out after 50ms: ")
+	})
+}
+
+func TestReportRenames(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	reportRenames(&out, []spec.ExtractSpec{
+		{Var: "my_var", RawVar: "my-var"},
+		{Var: "KEPT"},
+		{Var: "_1ABC", RawVar: "1ABC"},
+	})
+	assert.Equal(t, "renamed variable \"my-var\" to \"my_var\"\nrenamed variable \"1ABC\" to \"_1ABC\"\n", out.String())
 }
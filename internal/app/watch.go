@@ -0,0 +1,70 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gi8lino/unveil/internal/extract"
+	"github.com/gi8lino/unveil/internal/output"
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/gi8lino/unveil/internal/watch"
+	"github.com/gi8lino/unveil/unveil"
+)
+
+// newWatcher watches the files of all specs. Remote sources are not watched;
+// they are read again whenever a file changes.
+func newWatcher(specs []spec.ExtractSpec) (*watch.Watcher, error) {
+	paths := make([]string, 0, len(specs))
+	for _, s := range specs {
+		if extract.Watchable(s) {
+			paths = append(paths, s.FilePath())
+		}
+	}
+	return watch.New(paths)
+}
+
+// watchOutput re-renders specs whenever watcher reports a change and
+// atomically rewrites path if the content differs from last, which also fires
+// opts.OnWrite. Sources are read with the limits of r. Failures are reported to errw and keep the previous output in place.
+func watchOutput(
+	ctx context.Context,
+	watcher *watch.Watcher,
+	path string,
+	specs []spec.ExtractSpec,
+	opts unveil.Options,
+	last []byte,
+	debounce time.Duration,
+	r resolver,
+	errw io.Writer,
+) error {
+	return watcher.Run(ctx, debounce, func() {
+		res, err := r.resolve(ctx, specs)
+		if err != nil {
+			_, _ = fmt.Fprintf(errw, "keeping %s: %v\n", path, err)
+			return
+		}
+		data, err := unveil.Render(res, opts)
+		if err != nil {
+			_, _ = fmt.Fprintf(errw, "keeping %s: %v\n", path, err)
+			return
+		}
+		if bytes.Equal(data, last) {
+			return
+		}
+		if err := unveil.WriteFile(path, res, opts); err != nil {
+			if !errors.Is(err, output.ErrHookFailed) {
+				_, _ = fmt.Fprintf(errw, "keeping %s: %v\n", path, err)
+				return
+			}
+			_, _ = fmt.Fprintf(errw, "updated %s: %v\n", path, err)
+			last = data
+			return
+		}
+		last = data
+		_, _ = fmt.Fprintf(errw, "updated %s\n", path)
+	})
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gi8lino/unveil/internal/output"
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWatchOutput(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app.env")
+	dst := filepath.Join(dir, "out", "vars.env")
+	require.NoError(t, os.WriteFile(src, []byte("A=1\nB=x\n"), 0o666))
+
+	specs := []spec.ExtractSpec{{Kind: spec.KindFILE, Path: src, Key: "A", Var: "A"}}
+	initial := []byte("A=1\n")
+	require.NoError(t, output.WriteFileAtomic(dst, initial, output.FileOptions{}))
+	info, err := os.Stat(dst)
+	require.NoError(t, err)
+
+	watcher, err := newWatcher(specs)
+	require.NoError(t, err)
+	defer func() { _ = watcher.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var log syncBuffer
+	done := make(chan error, 1)
+	go func() {
+		done <- watchOutput(ctx, watcher, dst, specs, output.Options{}, initial, 20*time.Millisecond, resolver{parallel: 1}, &log)
+	}()
+
+	// Unrelated change: same rendered content, file is left alone.
+	require.NoError(t, os.WriteFile(src, []byte("A=1\nB=y\n"), 0o666))
+	time.Sleep(200 * time.Millisecond)
+	after, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(info, after), "output must not be rewritten")
+
+	// Broken source: error is reported, output is kept.
+	require.NoError(t, os.WriteFile(src, []byte("B=y\n"), 0o666))
+	require.Eventually(t, func() bool { return strings.Contains(log.String(), "keeping "+dst) },
+		5*time.Second, 10*time.Millisecond)
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "A=1\n", string(got))
+
+	// Real change: output is rewritten.
+	require.NoError(t, os.WriteFile(src, []byte("A=2\n"), 0o666))
+	require.Eventually(t, func() bool {
+		got, err := os.ReadFile(dst)
+		return err == nil && string(got) == "A=2\n"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return strings.Contains(log.String(), "updated "+dst) },
+		5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
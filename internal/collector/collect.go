@@ -2,21 +2,57 @@ package collector
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/gi8lino/unveil/internal/extract"
+	"github.com/gi8lino/unveil/internal/fetch"
 	"github.com/gi8lino/unveil/internal/flag"
+	"github.com/gi8lino/unveil/internal/output"
 	"github.com/gi8lino/unveil/internal/quote"
 	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/gi8lino/unveil/internal/validate"
 
 	"github.com/containeroo/tinyflags"
 )
 
-// Collect flattens all dynamic group instances into a slice of ExtractSpec.
+// instance is a collected spec and the dynamic instance it came from.
+type instance struct {
+	name string // "group.id"
+	spec spec.ExtractSpec
+}
+
+// UsageError is returned for an invalid command line.
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string { return e.Err.Error() }
+func (e *UsageError) Unwrap() error { return e.Err }
+
+// Collect flattens all dynamic group instances into a slice of ExtractSpec,
+// in the order the instances were declared on the command line. Instances
+// writing the same variable are resolved according to flags.OnDuplicate.
+// Errors are *UsageError.
 func Collect(flags *flag.Flags) ([]spec.ExtractSpec, error) {
-	var out []spec.ExtractSpec
+	specs, err := collect(flags)
+	if err != nil {
+		return nil, &UsageError{Err: err}
+	}
+	return specs, nil
+}
+
+// collect implements Collect.
+func collect(flags *flag.Flags) ([]spec.ExtractSpec, error) {
+	var all []instance
 
 	for _, g := range flags.FlagSet.DynamicGroups() {
-		groupName := g.Name() // one of: "json", "yaml", "toml", "ini", "file"
+		groupName := g.Name()
 
 		for _, id := range g.Instances() {
 			// Read per-instance values
@@ -25,33 +61,258 @@ func Collect(flags *flag.Flags) ([]spec.ExtractSpec, error) {
 			varName := tinyflags.GetOrDefaultDynamic[string](g, id, "as")
 			quoteStr := tinyflags.GetOrDefaultDynamic[string](g, id, "quote")
 
-			// Effective quoting: instance override if present, else global default
+			// Effective quoting: instance override if present, else global default.
+			// Non-POSIX shells dictate the quoting of every value.
 			quoteKind := flags.Quote
 			if quoteStr != "" {
 				quoteKind = quote.QuoteKind(quoteStr)
 			}
+			if q := flags.Shell.QuoteKind(); q != "" {
+				if quoteStr != "" {
+					return nil, fmt.Errorf("--%s.%s.quote cannot be combined with --shell %s", groupName, id, flags.Shell)
+				}
+				quoteKind = q
+			}
+			if flags.Format.RawValues() {
+				if quoteStr != "" {
+					return nil, fmt.Errorf("--%s.%s.quote cannot be combined with --format %s", groupName, id, flags.Format)
+				}
+				quoteKind = quote.QuoteNone
+			}
 
+			nameFlag := "--" + groupName + "." + id + ".as"
 			if varName == "" {
 				varName = strings.ToUpper(id)
+				nameFlag = "--" + groupName + "." + id
+			}
+			var rawVar string
+			if err := output.CheckName(varName, flags.Format); err != nil {
+				if !flags.Sanitize {
+					return nil, fmt.Errorf("%s: %w (use --sanitize-names to rewrite it)", nameFlag, err)
+				}
+				rawVar, varName = varName, output.SanitizeName(varName, flags.Format)
 			}
 
-			// Append with appropriate Kind
-			switch groupName {
-			case "json":
-				out = append(out, spec.ExtractSpec{Kind: spec.KindJSON, Path: path, Key: key, Var: varName, Quote: quoteKind})
-			case "yaml":
-				out = append(out, spec.ExtractSpec{Kind: spec.KindYAML, Path: path, Key: key, Var: varName, Quote: quoteKind})
-			case "toml":
-				out = append(out, spec.ExtractSpec{Kind: spec.KindTOML, Path: path, Key: key, Var: varName, Quote: quoteKind})
-			case "ini":
-				out = append(out, spec.ExtractSpec{Kind: spec.KindINI, Path: path, Key: key, Var: varName, Quote: quoteKind})
-			case "file":
-				out = append(out, spec.ExtractSpec{Kind: spec.KindFILE, Path: path, Key: key, Var: varName, Quote: quoteKind})
-			default:
-				// Should not happen; forward-compat
-				return nil, fmt.Errorf("unknown group %q", groupName)
+			rules, err := collectRules(g, id)
+			if err != nil {
+				return nil, err
+			}
+			// Flags only exist for the kinds that support them
+			d := extract.Describe(spec.Kind(groupName))
+			var decryption spec.Decryption
+			var identity string
+			if d.Decrypt {
+				if decryption, identity, err = collectDecrypt(g, id); err != nil {
+					return nil, err
+				}
 			}
+			var httpOpts fetch.Options
+			if d.URLs {
+				if httpOpts, err = collectHTTP(g, id, path); err != nil {
+					return nil, err
+				}
+			}
+
+			s := spec.ExtractSpec{
+				Kind:     spec.Kind(groupName), // groups are registered per source kind
+				Path:     path,
+				Key:      key,
+				Var:      varName,
+				RawVar:   rawVar,
+				Quote:    quoteKind,
+				Literal:  flags.Literal || tinyflags.GetOrDefaultDynamic[bool](g, id, "literal"),
+				Rules:    rules,
+				Decrypt:  decryption,
+				Identity: identity,
+				HTTP:     httpOpts,
+			}
+			secret, err := isSecret(g, id, s, d.Secret, flags.SecretDirs)
+			if err != nil {
+				return nil, err
+			}
+			s.Secret = secret
+			s.Public = tinyflags.GetOrDefaultDynamic[bool](g, id, "public")
+
+			all = append(all, instance{name: groupName + "." + id, spec: s})
 		}
 	}
+
+	// Keep the command-line order; undeclared instances (e.g. from defaults) go last.
+	rank := make(map[string]int, len(flags.Declared))
+	for i, name := range flags.Declared {
+		rank[name] = i
+	}
+	pos := func(inst instance) int {
+		if r, ok := rank[inst.name]; ok {
+			return r
+		}
+		return len(rank)
+	}
+	sort.SliceStable(all, func(a, b int) bool { return pos(all[a]) < pos(all[b]) })
+
+	all, err := resolveDuplicates(all, flags.OnDuplicate)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]spec.ExtractSpec, 0, len(all))
+	for _, inst := range all {
+		out = append(out, inst.spec)
+	}
+	// Only local files are watched; remote sources are re-read when one changes.
+	if flags.Watch && len(out) > 0 && !slices.ContainsFunc(out, extract.Watchable) {
+		return nil, fmt.Errorf("--watch requires at least one local source file")
+	}
 	return out, nil
 }
+
+// resolveDuplicates applies policy to instances sharing a variable: it fails
+// naming both instances, or keeps only the first or the last of them.
+func resolveDuplicates(all []instance, policy string) ([]instance, error) {
+	first := make(map[string]int, len(all)) // variable -> index in all
+	drop := make([]bool, len(all))
+	for i, inst := range all {
+		j, ok := first[inst.spec.Var]
+		if !ok {
+			first[inst.spec.Var] = i
+			continue
+		}
+		switch policy {
+		case flag.DuplicateFirst:
+			drop[i] = true
+		case flag.DuplicateLast:
+			drop[j] = true
+			first[inst.spec.Var] = i
+		default:
+			return nil, fmt.Errorf("variable %q is set by both %s and %s (use --on-duplicate=first or last to pick one)",
+				inst.spec.Var, describe(all[j]), describe(inst))
+		}
+	}
+
+	out := all[:0:0]
+	for i, inst := range all {
+		if !drop[i] {
+			out = append(out, inst)
+		}
+	}
+	return out, nil
+}
+
+// describe names an instance and its source for messages.
+func describe(inst instance) string {
+	return fmt.Sprintf("--%s (path=%q, select=%q)", inst.name, inst.spec.Path, inst.spec.Key)
+}
+
+// isSecret reports whether an instance is secret: if it is marked so, or if
+// its kind is secret by default, its file is decrypted or lies below one of
+// dirs and it is not marked public.
+func isSecret(g *tinyflags.DynamicGroup, id string, s spec.ExtractSpec, byDefault bool, dirs []string) (bool, error) {
+	secret := tinyflags.GetOrDefaultDynamic[bool](g, id, "secret")
+	public := tinyflags.GetOrDefaultDynamic[bool](g, id, "public")
+	if secret && public {
+		return false, fmt.Errorf("--%s.%s.secret cannot be combined with --%s.%s.public", g.Name(), id, g.Name(), id)
+	}
+	if secret || public {
+		return secret, nil
+	}
+	if byDefault || s.Decrypt != spec.DecryptNone {
+		return true, nil
+	}
+	for _, dir := range dirs {
+		if dir != "" && below(s.FilePath(), dir) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// below reports whether path lies inside dir.
+func below(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// collectDecrypt reads the per-instance decryption flags.
+func collectDecrypt(g *tinyflags.DynamicGroup, id string) (spec.Decryption, string, error) {
+	decryption := spec.Decryption(tinyflags.GetOrDefaultDynamic[string](g, id, "decrypt"))
+	identity := tinyflags.GetOrDefaultDynamic[string](g, id, "identity")
+	if decryption == spec.DecryptAge && identity == "" {
+		return "", "", fmt.Errorf("--%s.%s.decrypt=age requires --%s.%s.identity", g.Name(), id, g.Name(), id)
+	}
+	return decryption, identity, nil
+}
+
+// collectHTTP reads the per-instance request flags. They are only valid for
+// URL paths.
+func collectHTTP(g *tinyflags.DynamicGroup, id, path string) (fetch.Options, error) {
+	opts := fetch.Options{
+		Headers:         tinyflags.GetOrDefaultDynamic[[]string](g, id, "header"),
+		HeaderFiles:     tinyflags.GetOrDefaultDynamic[[]string](g, id, "header-file"),
+		BearerTokenFile: tinyflags.GetOrDefaultDynamic[string](g, id, "bearer-token-file"),
+		CAFile:          tinyflags.GetOrDefaultDynamic[string](g, id, "ca-file"),
+		Timeout:         tinyflags.GetOrDefaultDynamic[time.Duration](g, id, "timeout"),
+		MaxSize:         tinyflags.GetOrDefaultDynamic[int64](g, id, "max-size"),
+		CacheDir:        tinyflags.GetOrDefaultDynamic[string](g, id, "cache-dir"),
+	}
+	flagName := func(field string) string { return "--" + g.Name() + "." + id + "." + field }
+	if opts.Timeout < 0 {
+		return fetch.Options{}, fmt.Errorf("%s must not be negative", flagName("timeout"))
+	}
+	if opts.MaxSize <= 0 {
+		return fetch.Options{}, fmt.Errorf("%s must be positive", flagName("max-size"))
+	}
+	if fetch.IsURL(os.ExpandEnv(path)) {
+		return opts, nil
+	}
+	set := g.Get(id)
+	for _, field := range []string{"header", "header-file", "bearer-token-file", "ca-file", "timeout", "max-size", "cache-dir"} {
+		if _, ok := set[field]; ok {
+			return fetch.Options{}, fmt.Errorf("%s requires an http(s) URL in %s", flagName(field), flagName("path"))
+		}
+	}
+	return fetch.Options{}, nil
+}
+
+// collectRules reads the per-instance validation flags into validate.Rules.
+func collectRules(g *tinyflags.DynamicGroup, id string) (validate.Rules, error) {
+	rules := validate.Rules{
+		Type:     validate.Type(tinyflags.GetOrDefaultDynamic[string](g, id, "type")),
+		Enum:     tinyflags.GetOrDefaultDynamic[[]string](g, id, "enum"),
+		NonEmpty: tinyflags.GetOrDefaultDynamic[bool](g, id, "non-empty"),
+		MinLen:   tinyflags.GetOrDefaultDynamic[int](g, id, "min-len"),
+		MaxLen:   tinyflags.GetOrDefaultDynamic[int](g, id, "max-len"),
+	}
+	flagName := func(field string) string { return "--" + g.Name() + "." + id + "." + field }
+
+	if r := tinyflags.GetOrDefaultDynamic[string](g, id, "range"); r != "" {
+		if rules.Type != validate.TypeInt && rules.Type != validate.TypePort {
+			return validate.Rules{}, fmt.Errorf("%s requires %s=int or port", flagName("range"), flagName("type"))
+		}
+		rng, err := validate.ParseRange(r)
+		if err != nil {
+			return validate.Rules{}, fmt.Errorf("%s: %w", flagName("range"), err)
+		}
+		rules.Range = rng
+	}
+	if m := tinyflags.GetOrDefaultDynamic[string](g, id, "match"); m != "" {
+		re, err := regexp.Compile(m)
+		if err != nil {
+			return validate.Rules{}, fmt.Errorf("%s: %w", flagName("match"), err)
+		}
+		rules.Match = re
+	}
+	if rules.MinLen < 0 || rules.MaxLen < 0 {
+		return validate.Rules{}, fmt.Errorf("%s and %s must not be negative", flagName("min-len"), flagName("max-len"))
+	}
+	if rules.MaxLen > 0 && rules.MinLen > rules.MaxLen {
+		return validate.Rules{}, fmt.Errorf("%s is greater than %s", flagName("min-len"), flagName("max-len"))
+	}
+	return rules, nil
+}
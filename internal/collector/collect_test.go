@@ -1,11 +1,16 @@
 package collector
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/gi8lino/unveil/internal/extract"
+	"github.com/gi8lino/unveil/internal/fetch"
 	"github.com/gi8lino/unveil/internal/flag"
 	"github.com/gi8lino/unveil/internal/quote"
 	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/gi8lino/unveil/internal/validate"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -161,3 +166,507 @@ func TestCollect(t *testing.T) {
 		assert.Equal(t, quote.QuoteNone, dbu.Quote)
 	})
 }
+
+func TestCollect_Rules(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Validation flags populate rules", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{
+			"--yaml.port.path=/cfg.yaml",
+			"--yaml.port.select=server.port",
+			"--yaml.port.type=port",
+			"--yaml.port.range=1024..65535",
+			"--yaml.env.path=/cfg.yaml",
+			"--yaml.env.select=env",
+			"--yaml.env.enum=dev,prod",
+			"--yaml.env.match=^[a-z]+$",
+			"--yaml.env.non-empty",
+			"--yaml.env.min-len=3",
+			"--yaml.env.max-len=4",
+			"--yaml.env.secret",
+		}
+		flags, err := flag.ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		m := byVar(specs)
+
+		port := m["PORT"]
+		assert.Equal(t, validate.TypePort, port.Rules.Type)
+		assert.Equal(t, &validate.Range{Min: 1024, Max: 65535}, port.Rules.Range)
+		assert.False(t, port.Secret)
+
+		env := m["ENV"]
+		assert.Equal(t, []string{"dev", "prod"}, env.Rules.Enum)
+		require.NotNil(t, env.Rules.Match)
+		assert.Equal(t, "^[a-z]+$", env.Rules.Match.String())
+		assert.True(t, env.Rules.NonEmpty)
+		assert.Equal(t, 3, env.Rules.MinLen)
+		assert.Equal(t, 4, env.Rules.MaxLen)
+		assert.True(t, env.Secret)
+	})
+
+	t.Run("No validation flags yield zero rules", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--json.a.path=/a.json", "--json.a.select=foo"}
+		flags, err := flag.ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		require.Len(t, specs, 1)
+		assert.Equal(t, validate.Rules{}, specs[0].Rules)
+	})
+
+	t.Run("Range requires numeric type", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--json.a.path=/a.json", "--json.a.select=foo", "--json.a.range=1..2"}
+		flags, err := flag.ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.EqualError(t, err, "--json.a.range requires --json.a.type=int or port")
+	})
+
+	t.Run("Min length greater than max length", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--json.a.path=/a.json", "--json.a.select=foo", "--json.a.min-len=5", "--json.a.max-len=2"}
+		flags, err := flag.ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.EqualError(t, err, "--json.a.min-len is greater than --json.a.max-len")
+	})
+}
+
+func TestCollect_Shell(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Dialect sets quoting", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--shell", "fish", "--json.a.path=/a.json", "--json.a.select=foo"}
+		flags, err := flag.ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		require.Len(t, specs, 1)
+		assert.Equal(t, quote.QuoteFish, specs[0].Quote)
+	})
+
+	t.Run("Instance quote conflicts with dialect", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--shell", "cmd", "--json.a.path=/a.json", "--json.a.select=foo", "--json.a.quote=double"}
+		flags, err := flag.ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.EqualError(t, err, "--json.a.quote cannot be combined with --shell cmd")
+	})
+}
+
+func TestCollect_Format(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Format forces raw values", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--format", "systemd", "--json.a.path=/a.json", "--json.a.select=foo"}
+		flags, err := flag.ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		require.Len(t, specs, 1)
+		assert.Equal(t, quote.QuoteNone, specs[0].Quote)
+	})
+
+	t.Run("Instance quote conflicts with format", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--format", "docker-env", "--json.a.path=/a.json", "--json.a.select=foo", "--json.a.quote=single"}
+		flags, err := flag.ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.EqualError(t, err, "--json.a.quote cannot be combined with --format docker-env")
+	})
+}
+
+func TestCollect_Order(t *testing.T) {
+	t.Parallel()
+
+	args := []string{
+		"--yaml.z.path=/z.yaml", "--yaml.z.select=a",
+		"--json.b.path=/b.json", "--json.b.select=b",
+		"--yaml.a.path=/a.yaml", "--yaml.a.select=c",
+		"--json.a.path=/a.json", "--json.a.select=d", "--json.a.as=A2",
+	}
+	flags, err := flag.ParseFlags(args, "v", "c")
+	require.NoError(t, err)
+
+	specs, err := Collect(&flags)
+	require.NoError(t, err)
+
+	var keys []string
+	for _, s := range specs {
+		keys = append(keys, s.Key)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d"}, keys)
+}
+
+func TestCollect_Duplicates(t *testing.T) {
+	t.Parallel()
+
+	args := []string{
+		"--json.db.path=/a.json", "--json.db.select=user",
+		"--yaml.other.path=/o.yaml", "--yaml.other.select=x",
+		"--yaml.DB.path=/b.yaml", "--yaml.DB.select=name",
+	}
+
+	t.Run("Error by default", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.EqualError(t, err, `variable "DB" is set by both --json.db (path="/a.json", select="user") and --yaml.DB (path="/b.yaml", select="name") (use --on-duplicate=first or last to pick one)`)
+		var usage *UsageError
+		assert.ErrorAs(t, err, &usage)
+	})
+
+	t.Run("Explicit as clashes", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{
+			"--json.a.path=/a.json", "--json.a.select=x", "--json.a.as=X",
+			"--json.b.path=/b.json", "--json.b.select=y", "--json.b.as=X",
+		}, "v", "c")
+		require.NoError(t, err)
+
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `variable "X" is set by both --json.a`)
+	})
+
+	t.Run("First", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags(append([]string{"--on-duplicate=first"}, args...), "v", "c")
+		require.NoError(t, err)
+
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		require.Len(t, specs, 2)
+		assert.Equal(t, "/a.json", specs[0].Path)
+		assert.Equal(t, "/o.yaml", specs[1].Path)
+	})
+
+	t.Run("Last", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags(append([]string{"--on-duplicate=last"}, args...), "v", "c")
+		require.NoError(t, err)
+
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		require.Len(t, specs, 2)
+		assert.Equal(t, "/o.yaml", specs[0].Path)
+		assert.Equal(t, "/b.yaml", specs[1].Path)
+	})
+
+	t.Run("Invalid policy", func(t *testing.T) {
+		t.Parallel()
+		_, err := flag.ParseFlags([]string{"--on-duplicate=merge"}, "v", "c")
+		require.Error(t, err)
+	})
+}
+
+func TestCollect_Names(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Invalid ID", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{"--json.1st.path=/a.json", "--json.1st.select=x"}, "v", "c")
+		require.NoError(t, err)
+
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.EqualError(t, err, `--json.1st: invalid variable name "1ST": must be a POSIX identifier ([A-Za-z_][A-Za-z0-9_]*) (use --sanitize-names to rewrite it)`)
+	})
+
+	t.Run("Dialect rules", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{"--format=tfvars", "--json.a.path=/a.json", "--json.a.select=x", "--json.a.as=db-host"}, "v", "c")
+		require.NoError(t, err)
+
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		require.Len(t, specs, 1)
+		assert.Equal(t, "db-host", specs[0].Var)
+		assert.Empty(t, specs[0].RawVar)
+	})
+
+	t.Run("Sanitize", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{"--sanitize-names", "--json.a.path=/a.json", "--json.a.select=x", "--json.a.as=db-host"}, "v", "c")
+		require.NoError(t, err)
+
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		require.Len(t, specs, 1)
+		assert.Equal(t, "db_host", specs[0].Var)
+		assert.Equal(t, "db-host", specs[0].RawVar)
+	})
+
+	t.Run("Sanitized names clash", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{
+			"--sanitize-names",
+			"--json.a.path=/a.json", "--json.a.select=x", "--json.a.as=db-host",
+			"--json.b.path=/b.json", "--json.b.select=y", "--json.b.as=db_host",
+		}, "v", "c")
+		require.NoError(t, err)
+
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `variable "db_host" is set by both --json.a`)
+	})
+}
+
+func TestCollect_Secret(t *testing.T) {
+	t.Parallel()
+
+	collect := func(t *testing.T, args ...string) []spec.ExtractSpec {
+		t.Helper()
+		flags, err := flag.ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		return specs
+	}
+
+	t.Run("Marked secret", func(t *testing.T) {
+		t.Parallel()
+		specs := collect(t, "--json.a.path=/etc/a.json", "--json.a.select=x", "--json.a.secret")
+		assert.True(t, specs[0].Secret)
+	})
+
+	t.Run("Not secret by default", func(t *testing.T) {
+		t.Parallel()
+		specs := collect(t, "--json.a.path=/etc/a.json", "--json.a.select=x")
+		assert.False(t, specs[0].Secret)
+	})
+
+	t.Run("Default secret dirs", func(t *testing.T) {
+		t.Parallel()
+		specs := collect(t,
+			"--file.a.path=/run/secrets/db", "--file.a.select=",
+			"--file.b.path=/var/run/secrets/kubernetes.io/token", "--file.b.select=",
+			"--file.c.path=/run/secrets-other/db", "--file.c.select=",
+		)
+		require.Len(t, specs, 3)
+		assert.True(t, specs[0].Secret)
+		assert.True(t, specs[1].Secret)
+		assert.False(t, specs[2].Secret)
+	})
+
+	t.Run("Custom secret dir", func(t *testing.T) {
+		t.Parallel()
+		specs := collect(t, "--secret-dir=/vault", "--json.a.path=/vault/../vault/app/a.json", "--json.a.select=x",
+			"--json.b.path=/run/secrets/b.json", "--json.b.select=x")
+		assert.True(t, specs[0].Secret)
+		assert.False(t, specs[1].Secret, "--secret-dir replaces the defaults")
+	})
+
+	t.Run("Public opts out", func(t *testing.T) {
+		t.Parallel()
+		specs := collect(t, "--file.a.path=/run/secrets/user", "--file.a.select=", "--file.a.public")
+		assert.False(t, specs[0].Secret)
+	})
+
+	t.Run("Vault secrets", func(t *testing.T) {
+		t.Parallel()
+		specs := collect(t, "--vault.a.path=secret/data/app", "--vault.a.select=data.password",
+			"--vault.b.path=secret/data/app", "--vault.b.select=data.user", "--vault.b.public")
+		require.Len(t, specs, 2)
+		assert.Equal(t, spec.KindVault, specs[0].Kind)
+		assert.True(t, specs[0].Secret)
+		assert.False(t, specs[1].Secret)
+	})
+
+	t.Run("Secret and public", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{"--file.a.path=/x", "--file.a.select=", "--file.a.secret", "--file.a.public"}, "v", "c")
+		require.NoError(t, err)
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.EqualError(t, err, "--file.a.secret cannot be combined with --file.a.public")
+	})
+}
+
+func TestCollect_Decrypt(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Age with identity", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{
+			"--yaml.a.path=/etc/app.yaml.age", "--yaml.a.select=x", "--yaml.a.decrypt=age", "--yaml.a.identity=$HOME/age.txt",
+			"--yaml.b.path=/etc/app.enc.yaml", "--yaml.b.select=x", "--yaml.b.identity=/etc/sops.txt",
+		}, "v", "c")
+		require.NoError(t, err)
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		got := byVar(specs)
+		assert.Equal(t, spec.DecryptAge, got["A"].Decrypt)
+		assert.Equal(t, "$HOME/age.txt", got["A"].Identity)
+		assert.True(t, got["A"].Secret, "decrypted values are secret")
+		assert.Equal(t, spec.DecryptNone, got["B"].Decrypt)
+		assert.Equal(t, "/etc/sops.txt", got["B"].Identity)
+		assert.False(t, got["B"].Secret)
+	})
+
+	t.Run("Public opts out", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{"--file.a.path=/x.age", "--file.a.select=A", "--file.a.decrypt=age", "--file.a.identity=/k", "--file.a.public"}, "v", "c")
+		require.NoError(t, err)
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		assert.False(t, specs[0].Secret)
+	})
+
+	t.Run("Age requires identity", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{"--json.a.path=/x.age", "--json.a.select=x", "--json.a.decrypt=age"}, "v", "c")
+		require.NoError(t, err)
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.EqualError(t, err, "--json.a.decrypt=age requires --json.a.identity")
+	})
+}
+
+func TestCollect_HTTP(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Request options of URL paths", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{
+			"--json.a.path=https://config.internal/app.json", "--json.a.select=x",
+			"--json.a.header=Accept: application/json, text/plain", "--json.a.header=X-Team: ${TEAM}",
+			"--json.a.header-file=X-Api-Key: /run/secrets/api-key", "--json.a.bearer-token-file=/run/secrets/token",
+			"--json.a.ca-file=/etc/ssl/internal.pem", "--json.a.timeout=5s", "--json.a.max-size=1024", "--json.a.cache-dir=/var/cache/unveil",
+			"--yaml.b.path=http://localhost:8080/app.yaml", "--yaml.b.select=x",
+		}, "v", "c")
+		require.NoError(t, err)
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		got := byVar(specs)
+		assert.Equal(t, fetch.Options{
+			Headers:         []string{"Accept: application/json, text/plain", "X-Team: ${TEAM}"},
+			HeaderFiles:     []string{"X-Api-Key: /run/secrets/api-key"},
+			BearerTokenFile: "/run/secrets/token",
+			CAFile:          "/etc/ssl/internal.pem",
+			Timeout:         5 * time.Second,
+			MaxSize:         1024,
+			CacheDir:        "/var/cache/unveil",
+		}, got["A"].HTTP)
+		assert.Equal(t, fetch.Options{MaxSize: fetch.DefaultMaxSize}, got["B"].HTTP)
+		assert.False(t, got["A"].Secret)
+	})
+
+	t.Run("Local paths have no request options", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{"--json.a.path=/etc/app.json", "--json.a.select=x"}, "v", "c")
+		require.NoError(t, err)
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		assert.Equal(t, fetch.Options{}, specs[0].HTTP)
+	})
+
+	t.Run("Request options require a URL", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{"--json.a.path=/etc/app.json", "--json.a.select=x", "--json.a.bearer-token-file=/t"}, "v", "c")
+		require.NoError(t, err)
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.EqualError(t, err, "--json.a.bearer-token-file requires an http(s) URL in --json.a.path")
+	})
+
+	t.Run("Invalid limits", func(t *testing.T) {
+		t.Parallel()
+		for args, want := range map[string]string{
+			"--json.a.timeout=-1s": "--json.a.timeout must not be negative",
+			"--json.a.max-size=0":  "--json.a.max-size must be positive",
+		} {
+			flags, err := flag.ParseFlags([]string{"--json.a.path=https://x/a.json", "--json.a.select=x", args}, "v", "c")
+			require.NoError(t, err)
+			_, err = Collect(&flags)
+			require.Error(t, err)
+			assert.EqualError(t, err, want)
+		}
+	})
+}
+
+// stubSource is a source for a kind registered by the tests.
+type stubSource struct{}
+
+func (stubSource) Read(context.Context, spec.ExtractSpec) ([]byte, error) { return nil, nil }
+func (stubSource) Parse([]byte) (any, error)                              { return nil, nil }
+func (stubSource) Select(any, string) (any, error)                        { return nil, nil }
+
+func TestCollect_Watch(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Remote sources only", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{"--output=/tmp/out.env", "--watch",
+			"--vault.a.path=secret/data/app", "--vault.a.select=data.user",
+			"--json.b.path=https://example.com/app.json", "--json.b.select=port"}, "v", "c")
+		require.NoError(t, err)
+		_, err = Collect(&flags)
+		require.Error(t, err)
+		assert.EqualError(t, err, "--watch requires at least one local source file")
+	})
+
+	t.Run("With a local source", func(t *testing.T) {
+		t.Parallel()
+		flags, err := flag.ParseFlags([]string{"--output=/tmp/out.env", "--watch",
+			"--vault.a.path=secret/data/app", "--vault.a.select=data.user",
+			"--file.b.path=/run/app.env", "--file.b.select=PORT"}, "v", "c")
+		require.NoError(t, err)
+		specs, err := Collect(&flags)
+		require.NoError(t, err)
+		assert.Len(t, specs, 2)
+	})
+}
+
+func TestCollect_RegisteredKind(t *testing.T) {
+	t.Parallel()
+
+	extract.Register("test-stub", stubSource{})
+
+	flags, err := flag.ParseFlags([]string{
+		"--order=declared",
+		"--test-stub.a.path=/x", "--test-stub.a.select=k",
+		"--json.b.path=/b.json", "--json.b.select=k",
+	}, "v", "c")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test-stub.a", "json.b"}, flags.Declared)
+
+	specs, err := Collect(&flags)
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+	assert.Equal(t, spec.Kind("test-stub"), specs[0].Kind)
+	assert.Equal(t, "A", specs[0].Var)
+	assert.Equal(t, spec.KindJSON, specs[1].Kind)
+}
@@ -0,0 +1,44 @@
+// Package decrypt decrypts encrypted source files in memory.
+package decrypt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// Identities reads the age identities in file, as written by age-keygen.
+func Identities(file string) ([]age.Identity, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading age identities: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading age identities from %s: %w", file, err)
+	}
+	return ids, nil
+}
+
+// Age decrypts data, an age file in binary or armored form, with the
+// identities in identityFile. The plaintext is only kept in memory.
+func Age(data []byte, identityFile string) ([]byte, error) {
+	ids, err := Identities(identityFile)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = bytes.NewReader(data)
+	if trimmed := bytes.TrimLeft(data, " \t\r\n"); bytes.HasPrefix(trimmed, []byte(armor.Header)) {
+		r = armor.NewReader(bytes.NewReader(trimmed))
+	}
+	plain, err := age.Decrypt(r, ids...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(plain)
+}
@@ -0,0 +1,98 @@
+package decrypt
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// identity writes a new age identity to a file and returns both.
+func identity(t *testing.T) (*age.X25519Identity, string) {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# test key\n"+id.String()+"\n"), 0o600))
+	return id, path
+}
+
+// encrypt encrypts plain for id, armored if requested.
+func encrypt(t *testing.T, id *age.X25519Identity, plain string, armored bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var aw io.WriteCloser
+	if armored {
+		aw = armor.NewWriter(&buf)
+		out = aw
+	}
+	w, err := age.Encrypt(out, id.Recipient())
+	require.NoError(t, err)
+	_, err = io.WriteString(w, plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	if aw != nil {
+		require.NoError(t, aw.Close())
+	}
+	return buf.Bytes()
+}
+
+func TestAge(t *testing.T) {
+	t.Parallel()
+
+	id, keys := identity(t)
+
+	t.Run("Binary", func(t *testing.T) {
+		t.Parallel()
+		plain, err := Age(encrypt(t, id, "db:\n  password: hunter2\n", false), keys)
+		require.NoError(t, err)
+		assert.Equal(t, "db:\n  password: hunter2\n", string(plain))
+	})
+
+	t.Run("Armored", func(t *testing.T) {
+		t.Parallel()
+		data := append([]byte("\n"), encrypt(t, id, "TOKEN=abc\n", true)...)
+		plain, err := Age(data, keys)
+		require.NoError(t, err)
+		assert.Equal(t, "TOKEN=abc\n", string(plain))
+	})
+
+	t.Run("Other identity", func(t *testing.T) {
+		t.Parallel()
+		_, other := identity(t)
+		_, err := Age(encrypt(t, id, "x", false), other)
+		require.Error(t, err)
+		var noMatch *age.NoIdentityMatchError
+		assert.ErrorAs(t, err, &noMatch)
+	})
+
+	t.Run("Not encrypted", func(t *testing.T) {
+		t.Parallel()
+		_, err := Age([]byte("db:\n  password: hunter2\n"), keys)
+		require.Error(t, err)
+	})
+
+	t.Run("Missing identity file", func(t *testing.T) {
+		t.Parallel()
+		_, err := Age(encrypt(t, id, "x", false), filepath.Join(t.TempDir(), "missing.txt"))
+		require.Error(t, err)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("Invalid identity file", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "keys.txt")
+		require.NoError(t, os.WriteFile(path, []byte("not a key\n"), 0o600))
+		_, err := Age(encrypt(t, id, "x", false), path)
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "reading age identities from "+path+": ")
+	})
+}
@@ -0,0 +1,561 @@
+package extract
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+)
+
+// navigate walks doc along the path tokens.
+// Filter tokens compare the canonical form of the field with the wanted value.
+func navigate(doc any, tokens []string) (any, error) {
+	current := doc
+	for _, tok := range tokens {
+		switch curr := current.(type) {
+		case map[string]any:
+			val, ok := curr[tok]
+			if !ok {
+				return nil, fmt.Errorf("key %q not found", tok)
+			}
+			current = val
+
+		case []any:
+			if field, want, ok := parseFilter(tok); ok {
+				found := false
+				for _, elem := range curr {
+					m, ok := elem.(map[string]any)
+					if !ok {
+						continue
+					}
+					if got, ok := m[field].(Scalar); ok && got.String() == want {
+						current = elem
+						found = true
+						break
+					}
+				}
+				if !found {
+					return nil, fmt.Errorf("no array element where %s=%s", field, want)
+				}
+				continue
+			}
+
+			idx, err := strconv.Atoi(tok)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a valid array index or filter", tok)
+			}
+			if idx < 0 || idx >= len(curr) {
+				return nil, fmt.Errorf("array index %d out of bounds", idx)
+			}
+			current = curr[idx]
+
+		default:
+			return nil, fmt.Errorf("path segment %q not found", tok)
+		}
+	}
+	return current, nil
+}
+
+// parseFilter parses a "[field=value]" token; surrounding quotes on value are removed.
+func parseFilter(tok string) (field, value string, ok bool) {
+	inner, ok := strings.CutPrefix(tok, "[")
+	if !ok {
+		return "", "", false
+	}
+	inner, ok = strings.CutSuffix(inner, "]")
+	if !ok {
+		return "", "", false
+	}
+	field, value, ok = strings.Cut(inner, "=")
+	if !ok {
+		return "", "", false
+	}
+	field = strings.TrimSpace(field)
+	value = strings.TrimSpace(value)
+	if n := len(value); n >= 2 && (value[0] == '"' || value[0] == '\'') && value[n-1] == value[0] {
+		value = value[1 : n-1]
+	}
+	return field, value, field != ""
+}
+
+// parseJSON decodes a JSON document, keeping number literals.
+func parseJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unexpected data after top-level value")
+	}
+	return wrapJSON(v), nil
+}
+
+// wrapJSON converts decoded JSON values into document nodes.
+func wrapJSON(v any) any {
+	switch vv := v.(type) {
+	case map[string]any:
+		for k, e := range vv {
+			vv[k] = wrapJSON(e)
+		}
+		return vv
+	case []any:
+		for i, e := range vv {
+			vv[i] = wrapJSON(e)
+		}
+		return vv
+	case json.Number:
+		return Scalar{Value: parseNumber(string(vv)), Literal: string(vv)}
+	case bool:
+		return Scalar{Value: vv, Literal: strconv.FormatBool(vv)}
+	case nil:
+		return Scalar{Value: nil, Literal: "null"}
+	default:
+		return Scalar{Value: vv, Literal: fmt.Sprint(vv)}
+	}
+}
+
+// parseNumber converts a JSON number literal into int64, *big.Int or float64.
+func parseNumber(s string) any {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if !strings.ContainsAny(s, ".eE") {
+		if b, ok := new(big.Int).SetString(s, 10); ok {
+			return b
+		}
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// parseYAML decodes the first YAML document.
+func parseYAML(data []byte) (any, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if root.Kind == 0 {
+		return Scalar{}, nil // empty document
+	}
+	c := yamlConverter{expanding: make(map[*yaml.Node]bool)}
+	return c.wrap(&root)
+}
+
+// maxYAMLNodes bounds the number of nodes produced while expanding aliases,
+// so documents like "billion laughs" fail instead of exhausting memory.
+const maxYAMLNodes = 1_000_000
+
+// yamlConverter converts YAML nodes into document nodes, resolving aliases and merge keys.
+type yamlConverter struct {
+	expanding map[*yaml.Node]bool // alias targets currently being expanded
+	nodes     int                 // nodes produced so far
+}
+
+// wrap converts n and its children.
+func (c *yamlConverter) wrap(n *yaml.Node) (any, error) {
+	if c.nodes++; c.nodes > maxYAMLNodes {
+		return nil, fmt.Errorf("document expands to more than %d nodes", maxYAMLNodes)
+	}
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return Scalar{}, nil
+		}
+		return c.wrap(n.Content[0])
+	case yaml.AliasNode:
+		if c.expanding[n.Alias] {
+			return nil, fmt.Errorf("alias %q at line %d references itself", n.Value, n.Line)
+		}
+		c.expanding[n.Alias] = true
+		defer delete(c.expanding, n.Alias)
+		return c.wrap(n.Alias)
+	case yaml.SequenceNode:
+		out := make([]any, 0, len(n.Content))
+		for _, e := range n.Content {
+			v, err := c.wrap(e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case yaml.MappingNode:
+		out := make(map[string]any, len(n.Content)/2)
+		var merges []*yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.ShortTag() == "!!merge" {
+				merges = append(merges, v)
+				continue
+			}
+			val, err := c.wrap(v)
+			if err != nil {
+				return nil, err
+			}
+			out[k.Value] = val
+		}
+		// Merged keys never override explicit ones.
+		for _, m := range merges {
+			if err := c.merge(out, m); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case yaml.ScalarNode:
+		return yamlScalar(n)
+	default:
+		return nil, fmt.Errorf("unsupported YAML node at line %d", n.Line)
+	}
+}
+
+// merge adds keys of the mapping (or list of mappings) m that are missing in out.
+func (c *yamlConverter) merge(out map[string]any, m *yaml.Node) error {
+	v, err := c.wrap(m)
+	if err != nil {
+		return err
+	}
+	sources, ok := v.([]any)
+	if !ok {
+		sources = []any{v}
+	}
+	for _, src := range sources {
+		mm, ok := src.(map[string]any)
+		if !ok {
+			return fmt.Errorf("merge key at line %d must reference a mapping", m.Line)
+		}
+		for k, e := range mm {
+			if _, exists := out[k]; !exists {
+				out[k] = e
+			}
+		}
+	}
+	return nil
+}
+
+// yamlScalar decodes a YAML scalar node according to its resolved tag.
+func yamlScalar(n *yaml.Node) (Scalar, error) {
+	s := Scalar{Literal: n.Value, Line: n.Line, Column: n.Column}
+	switch n.ShortTag() {
+	case "!!null":
+		return s, nil
+	case "!!bool":
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return s, err
+		}
+		s.Value = b
+	case "!!int":
+		var i any
+		if err := n.Decode(&i); err != nil {
+			return s, err
+		}
+		switch iv := i.(type) {
+		case int:
+			s.Value = int64(iv)
+		default:
+			s.Value = iv
+		}
+	case "!!float":
+		var f float64
+		if err := n.Decode(&f); err != nil {
+			return s, err
+		}
+		s.Value = f
+	case "!!timestamp":
+		var t time.Time
+		if err := n.Decode(&t); err != nil {
+			return s, err
+		}
+		s.Value = t
+	case "!!str":
+		s.Value = n.Value
+	default:
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return s, err
+		}
+		s.Value = v
+	}
+	return s, nil
+}
+
+// parseTOML decodes a TOML document and attaches the source literal of every scalar.
+func parseTOML(data []byte) (any, error) {
+	var content map[string]any
+	if err := toml.Unmarshal(data, &content); err != nil {
+		return nil, err
+	}
+	literals, err := tomlLiterals(data)
+	if err != nil {
+		return nil, err
+	}
+	return wrapTOML(content, nil, literals), nil
+}
+
+// tomlLiteral is the source text and position of a TOML scalar.
+type tomlLiteral struct {
+	text string
+	pos  unstable.Position
+}
+
+// wrapTOML converts decoded TOML values into document nodes.
+func wrapTOML(v any, path []string, literals map[string]tomlLiteral) any {
+	switch vv := v.(type) {
+	case map[string]any:
+		for k, e := range vv {
+			vv[k] = wrapTOML(e, append(path, k), literals)
+		}
+		return vv
+	case []any:
+		for i, e := range vv {
+			vv[i] = wrapTOML(e, append(path, strconv.Itoa(i)), literals)
+		}
+		return vv
+	case []map[string]any:
+		out := make([]any, len(vv))
+		for i, e := range vv {
+			out[i] = wrapTOML(e, append(path, strconv.Itoa(i)), literals)
+		}
+		return out
+	default:
+		s := Scalar{Value: vv}
+		lit, ok := literals[tomlPathKey(path)]
+		if !ok {
+			lit.text = s.String()
+		}
+		s.Literal = lit.text
+		s.Line, s.Column = lit.pos.Line, lit.pos.Column
+		return s
+	}
+}
+
+// tomlPathKey joins path segments into a map key.
+func tomlPathKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+// tomlLiterals maps the path of every scalar in data to its source literal
+// and position. Array table elements are addressed by their index, like in
+// the decoded tree.
+func tomlLiterals(data []byte) (map[string]tomlLiteral, error) {
+	literals := make(map[string]tomlLiteral)
+	arrays := make(map[string]int) // path of [[array]] tables → number of elements so far
+
+	// resolve turns a header key into a path, descending into the last
+	// element of any array table along the way.
+	resolve := func(parts []string) []string {
+		var out []string
+		for i, p := range parts {
+			out = append(out, p)
+			if i == len(parts)-1 {
+				break
+			}
+			if n, ok := arrays[tomlPathKey(out)]; ok {
+				out = append(out, strconv.Itoa(n-1))
+			}
+		}
+		return out
+	}
+
+	var table []string
+	p := unstable.Parser{}
+	p.Reset(data)
+
+	var collect func(path []string, n *unstable.Node)
+	collect = func(path []string, n *unstable.Node) {
+		switch n.Kind {
+		case unstable.Array:
+			it := n.Children()
+			for i := 0; it.Next(); i++ {
+				collect(append(path, strconv.Itoa(i)), it.Node())
+			}
+		case unstable.InlineTable:
+			it := n.Children()
+			for it.Next() {
+				kv := it.Node()
+				collect(append(path, tomlKey(kv.Key())...), kv.Value())
+			}
+		default:
+			literals[tomlPathKey(path)] = tomlLiteral{text: string(n.Data), pos: p.Shape(n.Raw).Start}
+		}
+	}
+	for p.NextExpression() {
+		e := p.Expression()
+		switch e.Kind {
+		case unstable.Table:
+			table = resolve(tomlKey(e.Key()))
+		case unstable.ArrayTable:
+			table = resolve(tomlKey(e.Key()))
+			key := tomlPathKey(table)
+			table = append(table, strconv.Itoa(arrays[key]))
+			arrays[key]++
+		case unstable.KeyValue:
+			path := append(append([]string(nil), table...), tomlKey(e.Key())...)
+			collect(path, e.Value())
+		}
+	}
+	if err := p.Error(); err != nil {
+		return nil, err
+	}
+	return literals, nil
+}
+
+// tomlKey collects the parts of a (possibly dotted) TOML key.
+func tomlKey(it unstable.Iterator) []string {
+	var parts []string
+	for it.Next() {
+		parts = append(parts, string(it.Node().Data))
+	}
+	return parts
+}
+
+// parseINI decodes an INI document into sections of string values.
+// Keys outside any section belong to ini.DefaultSection.
+func parseINI(data []byte) (any, error) {
+	cfg, err := ini.Load(data)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]any)
+	for _, section := range cfg.Sections() {
+		keys := make(map[string]any)
+		for _, k := range section.Keys() {
+			keys[k.Name()] = Scalar{Value: k.String(), Literal: k.String()}
+		}
+		out[section.Name()] = keys
+	}
+	return out, nil
+}
+
+// parseKeyValue decodes a key=value (.env) file. The first occurrence of a key wins.
+func parseKeyValue(data []byte) (any, error) {
+	out := make(map[string]any)
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(data, []byte("\uFEFF"))))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		k, v, ok := parseKV(scanner.Text())
+		if !ok {
+			continue
+		}
+		if _, exists := out[k]; !exists {
+			out[k] = Scalar{Value: v, Literal: v, Line: line, Column: valueColumn(scanner.Text())}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// parseKV parses a single line of the form:
+//
+//	[export ]KEY = VALUE[ # inline comment]
+//
+// Single- or double-quoted values have their quotes stripped; double-quoted
+// values additionally resolve \n \r \t \\ \" \' escapes. An unquoted '#'
+// preceded by whitespace starts a comment.
+func parseKV(line string) (k, v string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	if rest, has := strings.CutPrefix(line, "export "); has {
+		line = strings.TrimSpace(rest)
+	}
+	k, v, ok = strings.Cut(line, "=")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" {
+		return "", "", false
+	}
+	v = cutInlineComment(strings.TrimSpace(v))
+	return k, strings.TrimSpace(unquote(v)), true
+}
+
+// valueColumn returns the 1-based byte column where the value of a key=value
+// line starts.
+func valueColumn(line string) int {
+	_, v, _ := strings.Cut(line, "=")
+	return len(line) - len(strings.TrimLeft(v, " \t")) + 1
+}
+
+// cutInlineComment removes a trailing comment that starts with an unquoted '#'
+// preceded by whitespace.
+func cutInlineComment(s string) string {
+	inSingle, inDouble := false, false
+	seenSpace := true // a leading '#' starts a comment as well
+	for i, r := range s {
+		switch r {
+		case '\'':
+			if !inDouble {
+				inSingle = !inSingle
+			}
+		case '"':
+			if !inSingle {
+				inDouble = !inDouble
+			}
+		case '#':
+			if !inSingle && !inDouble && seenSpace {
+				return strings.TrimSpace(s[:i])
+			}
+		}
+		seenSpace = unicode.IsSpace(r)
+	}
+	return s
+}
+
+// unquote strips matching surrounding quotes from s and resolves escapes in
+// double-quoted values.
+func unquote(s string) string {
+	n := len(s)
+	if n < 2 || s[0] != s[n-1] {
+		return s
+	}
+	switch s[0] {
+	case '\'':
+		return strings.ReplaceAll(s[1:n-1], `\'`, `'`)
+	case '"':
+		var b strings.Builder
+		escape := false
+		for _, r := range s[1 : n-1] {
+			if !escape {
+				if r == '\\' {
+					escape = true
+					continue
+				}
+				b.WriteRune(r)
+				continue
+			}
+			switch r {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteRune(r) // \\ \" \' and unknown escapes keep the character
+			}
+			escape = false
+		}
+		if escape {
+			b.WriteByte('\\')
+		}
+		return b.String()
+	default:
+		return s
+	}
+}
@@ -0,0 +1,130 @@
+package extract
+
+import (
+	"testing"
+
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sourceOf returns the source registered for kind.
+func sourceOf(t *testing.T, kind spec.Kind) Source {
+	t.Helper()
+	src, err := sourceFor(kind)
+	require.NoError(t, err)
+	return src
+}
+
+func TestSelect(t *testing.T) {
+	t.Parallel()
+
+	src := sourceOf(t, spec.KindYAML)
+	doc, err := src.Parse([]byte(`
+servers:
+  - name: a
+    port: 80
+  - name: "db.local"
+    port: 5432
+`))
+	require.NoError(t, err)
+
+	t.Run("Index", func(t *testing.T) {
+		t.Parallel()
+		v, err := src.Select(doc, "servers.1.port")
+		require.NoError(t, err)
+		assert.Equal(t, "5432", v.(Scalar).String())
+	})
+
+	t.Run("Filter with dots and quotes", func(t *testing.T) {
+		t.Parallel()
+		v, err := src.Select(doc, `servers.[name="db.local"].port`)
+		require.NoError(t, err)
+		assert.Equal(t, "5432", v.(Scalar).String())
+	})
+
+	t.Run("Filter compares canonical values", func(t *testing.T) {
+		t.Parallel()
+		v, err := src.Select(doc, "servers.[port=80].name")
+		require.NoError(t, err)
+		assert.Equal(t, "a", v.(Scalar).String())
+	})
+
+	t.Run("Filter without match", func(t *testing.T) {
+		t.Parallel()
+		_, err := src.Select(doc, "servers.[name=x].port")
+		require.Error(t, err)
+		assert.EqualError(t, err, "no array element where name=x")
+	})
+
+	t.Run("Index out of bounds", func(t *testing.T) {
+		t.Parallel()
+		_, err := src.Select(doc, "servers.2")
+		require.Error(t, err)
+		assert.EqualError(t, err, "array index 2 out of bounds")
+	})
+
+	t.Run("Descending into scalar", func(t *testing.T) {
+		t.Parallel()
+		_, err := src.Select(doc, "servers.0.port.x")
+		require.Error(t, err)
+		assert.EqualError(t, err, `path segment "x" not found`)
+	})
+
+	t.Run("Missing key", func(t *testing.T) {
+		t.Parallel()
+		_, err := src.Select(doc, "clients")
+		require.Error(t, err)
+		assert.EqualError(t, err, `key "clients" not found`)
+	})
+}
+
+func TestSelect_INI(t *testing.T) {
+	t.Parallel()
+
+	src := sourceOf(t, spec.KindINI)
+	doc, err := src.Parse([]byte("root = r\n[DB]\nUser = alice\n"))
+	require.NoError(t, err)
+
+	v, err := src.Select(doc, "DB.User")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", v.(Scalar).String())
+
+	v, err = src.Select(doc, "root")
+	require.NoError(t, err)
+	assert.Equal(t, "r", v.(Scalar).String())
+
+	_, err = src.Select(doc, "DB.")
+	require.Error(t, err)
+	assert.EqualError(t, err, `empty key in "DB."`)
+}
+
+func TestParse_Errors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("JSON trailing data", func(t *testing.T) {
+		t.Parallel()
+		_, err := sourceOf(t, spec.KindJSON).Parse([]byte(`{"a":1} {"b":2}`))
+		require.Error(t, err)
+		assert.EqualError(t, err, "unexpected data after top-level value")
+	})
+
+	t.Run("YAML self-referencing alias", func(t *testing.T) {
+		t.Parallel()
+		_, err := sourceOf(t, spec.KindYAML).Parse([]byte("a: &x\n  b: *x\n"))
+		require.Error(t, err)
+	})
+
+	t.Run("TOML syntax", func(t *testing.T) {
+		t.Parallel()
+		_, err := sourceOf(t, spec.KindTOML).Parse([]byte("a = "))
+		require.Error(t, err)
+	})
+
+	t.Run("Unknown kind", func(t *testing.T) {
+		t.Parallel()
+		_, err := sourceFor(spec.Kind("xml"))
+		require.Error(t, err)
+		assert.EqualError(t, err, `unsupported kind "xml"`)
+	})
+}
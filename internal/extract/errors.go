@@ -0,0 +1,57 @@
+package extract
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/gi8lino/unveil/internal/spec"
+)
+
+// Reason classifies why a spec could not be resolved.
+type Reason string
+
+const (
+	ReasonMissing  Reason = "source-missing"    // the source does not exist
+	ReasonRead     Reason = "source-unreadable" // the source exists but could not be read
+	ReasonParse    Reason = "parse"             // the source is not a valid document of its kind
+	ReasonNotFound Reason = "key-not-found"     // the selector matched nothing
+	ReasonInvalid  Reason = "invalid-value"     // the value does not satisfy the rules
+)
+
+// Error is returned by Resolve for the spec that failed. Its message never
+// contains the values of secret specs.
+type Error struct {
+	Reason Reason
+	Spec   spec.ExtractSpec
+	Err    error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// stepError marks the step of extractValue that failed.
+type stepError struct {
+	reason Reason
+	err    error
+}
+
+func (e *stepError) Error() string { return e.err.Error() }
+func (e *stepError) Unwrap() error { return e.err }
+
+// mark marks err as failing for reason.
+func mark(reason Reason, err error) error {
+	return &stepError{reason: reason, err: err}
+}
+
+// reasonOf returns the reason err was marked with. Unmarked read errors are
+// ReasonMissing if the file does not exist.
+func reasonOf(err error) Reason {
+	var step *stepError
+	if errors.As(err, &step) {
+		return step.reason
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return ReasonMissing
+	}
+	return ReasonRead
+}
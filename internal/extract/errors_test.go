@@ -0,0 +1,74 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/gi8lino/unveil/internal/validate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve_Errors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	require.NoError(t, os.WriteFile(good, []byte(`{"port": "http", "token": "hunter2"}`), 0o600))
+	bad := filepath.Join(dir, "bad.json")
+	require.NoError(t, os.WriteFile(bad, []byte(`{"port":`), 0o600))
+
+	tests := []struct {
+		name   string
+		spec   spec.ExtractSpec
+		reason Reason
+		msg    string
+	}{
+		{
+			name:   "Missing file",
+			spec:   spec.ExtractSpec{Kind: spec.KindJSON, Path: filepath.Join(dir, "nope.json"), Key: "port", Var: "PORT"},
+			reason: ReasonMissing,
+		},
+		{
+			name:   "Unreadable file",
+			spec:   spec.ExtractSpec{Kind: spec.KindJSON, Path: dir, Key: "port", Var: "PORT"},
+			reason: ReasonRead,
+		},
+		{
+			name:   "Parse error",
+			spec:   spec.ExtractSpec{Kind: spec.KindJSON, Path: bad, Key: "port", Var: "PORT"},
+			reason: ReasonParse,
+		},
+		{
+			name:   "Key not found",
+			spec:   spec.ExtractSpec{Kind: spec.KindJSON, Path: good, Key: "host", Var: "HOST"},
+			reason: ReasonNotFound,
+			msg:    `json "HOST" (path="` + good + `"): selecting "host": key "host" not found`,
+		},
+		{
+			name:   "Invalid secret value",
+			spec:   spec.ExtractSpec{Kind: spec.KindJSON, Path: good, Key: "token", Var: "TOKEN", Secret: true, Rules: validate.Rules{Type: validate.TypeInt}},
+			reason: ReasonInvalid,
+			msg:    `json "TOKEN": invalid value "***": not a valid int`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, _, err := Resolve(context.Background(), []spec.ExtractSpec{tt.spec}, 1)
+			require.Error(t, err)
+			var e *Error
+			require.True(t, errors.As(err, &e))
+			assert.Equal(t, tt.reason, e.Reason)
+			assert.Equal(t, tt.spec, e.Spec)
+			if tt.msg != "" {
+				assert.EqualError(t, err, tt.msg)
+			}
+		})
+	}
+}
@@ -1,27 +1,272 @@
 package extract
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gi8lino/unveil/internal/quote"
+	"github.com/gi8lino/unveil/internal/redact"
 	"github.com/gi8lino/unveil/internal/spec"
-
-	"github.com/containeroo/resolver"
 )
 
-// ExtractAll resolves all specs and returns a map of KEY=VALUE.
-// Values are quoted according to spec.Quote.
-func ExtractAll(specs []spec.ExtractSpec) (map[string]string, error) {
-	out := make(map[string]string, len(specs))
-	for _, s := range specs {
-		// Compose resolver URI: "kind:/path//key"
-		value := fmt.Sprintf("%s:%s//%s", s.Kind, s.Path, s.Key)
-		val, err := resolver.ResolveVariable(value)
+// Origin describes where the value of a variable came from.
+type Origin struct {
+	Spec       spec.ExtractSpec // instruction that produced the value
+	Path       string           // file read, with environment variables expanded
+	Line       int              // 1-based line of the value; 0 if the parser does not report it
+	Column     int              // 1-based byte column of the value; 0 if unknown
+	Transforms []string         // steps from the source text to the unquoted value
+	Value      string           // unquoted value
+}
+
+// ExtractAll resolves all specs and returns their variables in spec order.
+// Values are rendered canonically (or as source literals if spec.Literal is set),
+// validated against spec.Rules and quoted according to spec.Quote.
+// If several specs share a variable, the last value wins at the first position.
+// Errors never contain the values of secret specs. It stops with ctx.Err()
+// once ctx is done.
+func ExtractAll(ctx context.Context, specs []spec.ExtractSpec) ([]spec.Var, error) {
+	vars, _, err := Explain(ctx, specs)
+	return vars, err
+}
+
+// Explain is like ExtractAll but also returns the origin of each variable,
+// in the same order. It stops with ctx.Err() once ctx is done.
+func Explain(ctx context.Context, specs []spec.ExtractSpec) ([]spec.Var, []Origin, error) {
+	return Resolve(ctx, specs, 1)
+}
+
+// Resolve is Explain reading up to parallel documents at once; specs sharing
+// a document read it once. The result does not depend on parallel: values
+// keep spec order and of several failing specs, the first one is reported as
+// an *Error.
+func Resolve(ctx context.Context, specs []spec.ExtractSpec, parallel int) ([]spec.Var, []Origin, error) {
+	results := readAll(ctx, specs, parallel)
+	out := make([]spec.Var, 0, len(specs))
+	origins := make([]Origin, 0, len(specs))
+	index := make(map[string]int, len(specs))
+	var secrets redact.Redactor
+	for i, s := range specs {
+		r := results[i]
+		if r.stopped {
+			return nil, nil, r.err
+		}
+		origin, err := r.origin, r.err
 		if err != nil {
-			return nil, fmt.Errorf("%s %q (%s=%q): %w", s.Kind, s.Var, "path", s.Path, err)
+			return nil, nil, &Error{Reason: reasonOf(err), Spec: s, Err: secrets.Error(fmt.Errorf("%s %q (%s=%q): %w", s.Kind, s.Var, "path", s.Path, err))}
+		}
+		s = origin.Spec // reading may have made it secret
+		val := origin.Value
+		if s.Secret {
+			secrets.Add(val)
+		}
+		// Check constraints on the raw value, before quoting
+		if err := s.Rules.Check(val); err != nil {
+			return nil, nil, &Error{Reason: ReasonInvalid, Spec: s, Err: secrets.Error(fmt.Errorf("%s %q: invalid value %q: %w", s.Kind, s.Var, redact.Value(val, s.Secret), err))}
 		}
 		// Apply quoting policy
-		out[s.Var] = quote.QuoteValue(val, s.Quote)
+		v := spec.Var{Name: s.Var, Value: quote.QuoteValue(val, s.Quote), Secret: s.Secret}
+		if i, ok := index[s.Var]; ok {
+			out[i], origins[i] = v, origin
+			continue
+		}
+		index[s.Var] = len(out)
+		out = append(out, v)
+		origins = append(origins, origin)
+	}
+	return out, origins, nil
+}
+
+// result is the outcome of extractValue for one spec.
+type result struct {
+	origin  Origin
+	err     error
+	stopped bool // ctx was done before the spec was read; err is ctx.Err()
+}
+
+// readAll extracts specs with up to parallel workers and returns the results
+// in spec order. Each document is read and parsed once for all specs sharing
+// it. Documents behind a failed spec are skipped, as only the first failure
+// is reported.
+func readAll(ctx context.Context, specs []spec.ExtractSpec, parallel int) []result {
+	results := make([]result, len(specs))
+	groups := groupBySource(specs)
+	var next atomic.Int64   // index of the next group to read
+	var failed atomic.Int64 // lowest index of a failed spec
+	failed.Store(int64(len(specs)))
+
+	var wg sync.WaitGroup
+	for range max(1, min(parallel, len(groups))) {
+		wg.Go(func() {
+			for {
+				g := next.Add(1) - 1
+				// Groups are ordered by their first spec, so all further ones are behind a failure too.
+				if g >= int64(len(groups)) || int64(groups[g][0]) > failed.Load() {
+					return
+				}
+				extractGroup(ctx, specs, groups[g], results)
+				for _, i := range groups[g] {
+					if results[i].err != nil {
+						lower(&failed, int64(i))
+						break
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// sourceKey identifies the document a spec reads.
+type sourceKey struct {
+	kind     spec.Kind
+	path     string
+	decrypt  spec.Decryption
+	identity string
+	http     string // request options, formatted as they hold slices
+}
+
+// groupBySource returns the indexes of specs grouped by the document they
+// read, in the order of the first spec of each group.
+func groupBySource(specs []spec.ExtractSpec) [][]int {
+	index := make(map[sourceKey]int, len(specs))
+	var groups [][]int
+	for i, s := range specs {
+		k := sourceKey{kind: s.Kind, path: s.FilePath(), decrypt: s.Decrypt, identity: s.IdentityFile(), http: fmt.Sprintf("%#v", s.HTTP)}
+		g, ok := index[k]
+		if !ok {
+			g = len(groups)
+			index[k] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+// extractGroup reads the document shared by the specs at indexes once and
+// stores the result of each spec in results.
+func extractGroup(ctx context.Context, specs []spec.ExtractSpec, indexes []int, results []result) {
+	if err := ctx.Err(); err != nil {
+		for _, i := range indexes {
+			results[i] = result{err: err, stopped: true}
+		}
+		return
+	}
+	d, err := openDocument(ctx, specs[indexes[0]])
+	for _, i := range indexes {
+		if err != nil {
+			results[i].err = err
+			continue
+		}
+		results[i].origin, results[i].err = d.extract(specs[i])
+	}
+}
+
+// lower sets v to n unless v is lower already.
+func lower(v *atomic.Int64, n int64) {
+	for {
+		cur := v.Load()
+		if n >= cur || v.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+// extractValue reads the document of s with the source of its kind, evaluates
+// its selector and returns the unquoted value and where it was found.
+// An empty key returns the whole document, trimmed.
+func extractValue(ctx context.Context, s spec.ExtractSpec) (Origin, error) {
+	d, err := openDocument(ctx, s)
+	if err != nil {
+		return Origin{}, err
+	}
+	return d.extract(s)
+}
+
+// document is a document read by a source, parsed on first use.
+type document struct {
+	src       Source
+	data      []byte
+	decrypted bool // values are secret unless the spec is public
+	parsed    bool
+	tree      any
+	err       error // of parsing
+}
+
+// openDocument reads the document of s with the source of its kind.
+func openDocument(ctx context.Context, s spec.ExtractSpec) (*document, error) {
+	src, err := sourceFor(s.Kind)
+	if err != nil {
+		return nil, err
+	}
+	data, decrypted, err := read(ctx, src, s)
+	if err != nil {
+		return nil, err
+	}
+	return &document{src: src, data: data, decrypted: decrypted}, nil
+}
+
+// parse returns the tree of d, parsing it on the first call.
+func (d *document) parse(kind spec.Kind) (any, error) {
+	if !d.parsed {
+		d.parsed = true
+		if d.tree, d.err = d.src.Parse(d.data); d.err != nil {
+			d.err = mark(ReasonParse, fmt.Errorf("parsing %s: %w", kind, d.err))
+		}
+	}
+	return d.tree, d.err
+}
+
+// extract evaluates the selector of s against d.
+func (d *document) extract(s spec.ExtractSpec) (Origin, error) {
+	origin := Origin{Spec: s, Path: s.FilePath()}
+	if d.decrypted && !s.Public {
+		origin.Spec.Secret = true
+	}
+	if s.Key == "" {
+		origin.Value = strings.TrimSpace(strings.TrimPrefix(string(d.data), "\uFEFF"))
+		origin.Transforms = []string{"whole file, trimmed"}
+		return origin, nil
+	}
+
+	doc, err := d.parse(s.Kind)
+	if err != nil {
+		return Origin{}, err
+	}
+	val, err := d.src.Select(doc, s.Key)
+	if err != nil {
+		return Origin{}, mark(ReasonNotFound, fmt.Errorf("selecting %q: %w", s.Key, err))
+	}
+	origin.Value, err = render(val, s.Literal)
+	if err != nil {
+		return Origin{}, mark(ReasonParse, err)
+	}
+
+	switch v := val.(type) {
+	case Scalar:
+		origin.Line, origin.Column = v.Line, v.Column
+		switch {
+		case s.Literal:
+			origin.Transforms = []string{"kept as written (literal)"}
+		case origin.Value != v.Literal:
+			origin.Transforms = []string{"normalized"}
+		}
+	case map[string]any, []any:
+		origin.Transforms = []string{"encoded as JSON"}
+	}
+	return origin, nil
+}
+
+// read reads the document of s and reports whether it was decrypted.
+func read(ctx context.Context, src Source, s spec.ExtractSpec) ([]byte, bool, error) {
+	if d, ok := src.(decryptingSource); ok {
+		return d.readDecrypted(ctx, s)
 	}
-	return out, nil
+	data, err := src.Read(ctx, s)
+	return data, false, err
 }
@@ -1,12 +1,19 @@
 package extract
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gi8lino/unveil/internal/quote"
 	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/gi8lino/unveil/internal/validate"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -27,9 +34,9 @@ func TestExtractAll(t *testing.T) {
 			Quote: quote.QuoteNone,
 		}}
 
-		out, err := ExtractAll(specs)
+		out, err := ExtractAll(context.Background(), specs)
 		require.NoError(t, err)
-		assert.Equal(t, map[string]string{"HOST": "localhost"}, out)
+		assert.Equal(t, []spec.Var{{Name: "HOST", Value: "localhost"}}, out)
 	})
 
 	t.Run("Single YAML with single quotes", func(t *testing.T) {
@@ -48,9 +55,9 @@ func TestExtractAll(t *testing.T) {
 			Quote: quote.QuoteSingle,
 		}}
 
-		out, err := ExtractAll(specs)
+		out, err := ExtractAll(context.Background(), specs)
 		require.NoError(t, err)
-		assert.Equal(t, map[string]string{"VAL": "'bar'"}, out)
+		assert.Equal(t, []spec.Var{{Name: "VAL", Value: "'bar'"}}, out)
 	})
 
 	t.Run("Multiple specs mixed quoting", func(t *testing.T) {
@@ -72,10 +79,9 @@ func TestExtractAll(t *testing.T) {
 			{Kind: spec.KindINI, Path: ipath, Key: "S.K2", Var: "V2", Quote: quote.QuoteDouble},
 		}
 
-		out, err := ExtractAll(specs)
+		out, err := ExtractAll(context.Background(), specs)
 		require.NoError(t, err)
-		assert.Equal(t, "v1", out["V1"])
-		assert.Equal(t, `"v2"`, out["V2"])
+		assert.Equal(t, []spec.Var{{Name: "V1", Value: "v1"}, {Name: "V2", Value: `"v2"`}}, out)
 	})
 
 	t.Run("TOML missing key error", func(t *testing.T) {
@@ -94,7 +100,7 @@ func TestExtractAll(t *testing.T) {
 			Quote: quote.QuoteNone,
 		}}
 
-		out, err := ExtractAll(specs)
+		out, err := ExtractAll(context.Background(), specs)
 		require.Error(t, err)
 		assert.Nil(t, out)
 	})
@@ -115,9 +121,306 @@ func TestExtractAll(t *testing.T) {
 			Quote: quote.QuoteJSON,
 		}}
 
-		out, err := ExtractAll(specs)
+		out, err := ExtractAll(context.Background(), specs)
 		require.NoError(t, err)
 		// Expect a JSON-encoded string value (double-quoted with escapes)
-		assert.Equal(t, `"line1\nline2\t\"q\"\\slash\\"`, out["MSG"])
+		assert.Equal(t, `"line1\nline2\t\"q\"\\slash\\"`, out[0].Value)
+	})
+}
+
+func TestExtractAll_Validation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ypath := filepath.Join(dir, "cfg.yaml")
+	require.NoError(t, os.WriteFile(ypath, []byte("port: eighty\nenv: prod\n"), 0o666))
+
+	t.Run("Valid value passes", func(t *testing.T) {
+		t.Parallel()
+
+		specs := []spec.ExtractSpec{{
+			Kind:  spec.KindYAML,
+			Path:  ypath,
+			Key:   "env",
+			Var:   "ENV",
+			Rules: validate.Rules{Enum: []string{"dev", "prod"}},
+		}}
+
+		out, err := ExtractAll(context.Background(), specs)
+		require.NoError(t, err)
+		assert.Equal(t, []spec.Var{{Name: "ENV", Value: "prod"}}, out)
+	})
+
+	t.Run("Invalid value names variable and value", func(t *testing.T) {
+		t.Parallel()
+
+		specs := []spec.ExtractSpec{{
+			Kind:  spec.KindYAML,
+			Path:  ypath,
+			Key:   "port",
+			Var:   "PORT",
+			Rules: validate.Rules{Type: validate.TypePort},
+		}}
+
+		out, err := ExtractAll(context.Background(), specs)
+		require.Error(t, err)
+		assert.Nil(t, out)
+		assert.EqualError(t, err, `yaml "PORT": invalid value "eighty": not a valid port`)
+	})
+
+	t.Run("Secret value is redacted", func(t *testing.T) {
+		t.Parallel()
+
+		specs := []spec.ExtractSpec{{
+			Kind:   spec.KindYAML,
+			Path:   ypath,
+			Key:    "port",
+			Var:    "PORT",
+			Rules:  validate.Rules{Type: validate.TypePort},
+			Secret: true,
+		}}
+
+		_, err := ExtractAll(context.Background(), specs)
+		require.Error(t, err)
+		assert.EqualError(t, err, `yaml "PORT": invalid value "***": not a valid port`)
+	})
+
+	t.Run("Secret value is redacted in later errors", func(t *testing.T) {
+		t.Parallel()
+
+		specs := []spec.ExtractSpec{
+			{Kind: spec.KindYAML, Path: ypath, Key: "env", Var: "TOKEN", Secret: true},
+			{Kind: spec.KindYAML, Path: ypath, Key: "env", Var: "STAGE", Rules: validate.Rules{Enum: []string{"dev"}}},
+			{Kind: spec.KindYAML, Path: ypath, Key: "prod", Var: "MISSING"},
+		}
+
+		_, err := ExtractAll(context.Background(), specs)
+		require.Error(t, err)
+		assert.EqualError(t, err, `yaml "STAGE": invalid value "***": must be one of [dev]`)
+
+		_, err = ExtractAll(context.Background(), []spec.ExtractSpec{specs[0], specs[2]})
+		require.Error(t, err)
+		assert.EqualError(t, err, `yaml "MISSING" (path="`+ypath+`"): selecting "***": key "***" not found`)
+	})
+
+	t.Run("Rules apply before quoting", func(t *testing.T) {
+		t.Parallel()
+
+		specs := []spec.ExtractSpec{{
+			Kind:  spec.KindYAML,
+			Path:  ypath,
+			Key:   "env",
+			Var:   "ENV",
+			Quote: quote.QuoteDouble,
+			Rules: validate.Rules{MaxLen: 4},
+		}}
+
+		out, err := ExtractAll(context.Background(), specs)
+		require.NoError(t, err)
+		assert.Equal(t, `"prod"`, out[0].Value)
+	})
+}
+
+func TestExtractAll_Order(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.env")
+	require.NoError(t, os.WriteFile(path, []byte("A=1\nB=2\nC=3\n"), 0o666))
+
+	specs := []spec.ExtractSpec{
+		{Kind: spec.KindFILE, Path: path, Key: "C", Var: "Z"},
+		{Kind: spec.KindFILE, Path: path, Key: "A", Var: "X"},
+		{Kind: spec.KindFILE, Path: path, Key: "B", Var: "Z"}, // redefines Z
+	}
+
+	out, err := ExtractAll(context.Background(), specs)
+	require.NoError(t, err)
+	assert.Equal(t, []spec.Var{{Name: "Z", Value: "2"}, {Name: "X", Value: "1"}}, out)
+}
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o666))
+		return path
+	}
+	ypath := write("cfg.yaml", "db:\n  host: db.local\n  port: 0x1F\n  tags: [a, b]\n")
+	tpath := write("cfg.toml", "title = \"x\"\n\n[db]\nport =   5432\n")
+	fpath := write("app.env", "# comment\nexport TOKEN = 'abc' # note\n")
+	jpath := write("cfg.json", `{"a": 1}`)
+
+	specs := []spec.ExtractSpec{
+		{Kind: spec.KindYAML, Path: ypath, Key: "db.host", Var: "HOST", Quote: quote.QuoteDouble},
+		{Kind: spec.KindYAML, Path: ypath, Key: "db.port", Var: "PORT"},
+		{Kind: spec.KindYAML, Path: ypath, Key: "db.port", Var: "RAW_PORT", Literal: true},
+		{Kind: spec.KindYAML, Path: ypath, Key: "db.tags", Var: "TAGS"},
+		{Kind: spec.KindTOML, Path: tpath, Key: "db.port", Var: "TOML_PORT"},
+		{Kind: spec.KindFILE, Path: fpath, Key: "TOKEN", Var: "TOKEN"},
+		{Kind: spec.KindJSON, Path: jpath, Key: "a", Var: "A"},
+		{Kind: spec.KindFILE, Path: fpath, Var: "WHOLE"},
+	}
+
+	vars, origins, err := Explain(context.Background(), specs)
+	require.NoError(t, err)
+	require.Len(t, origins, len(vars))
+	assert.Equal(t, `"db.local"`, vars[0].Value)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = Explain(ctx, specs)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	type pos struct {
+		line, column int
+		transforms   []string
+		value        string
+	}
+	want := []pos{
+		{2, 9, nil, "db.local"},
+		{3, 9, []string{"normalized"}, "31"},
+		{3, 9, []string{"kept as written (literal)"}, "0x1F"},
+		{0, 0, []string{"encoded as JSON"}, `["a","b"]`},
+		{4, 10, nil, "5432"},
+		{2, 16, nil, "abc"},
+		{0, 0, nil, "1"},
+		{0, 0, []string{"whole file, trimmed"}, "# comment\nexport TOKEN = 'abc' # note"},
+	}
+	for i, w := range want {
+		o := origins[i]
+		assert.Equal(t, specs[i], o.Spec, specs[i].Var)
+		assert.Equal(t, specs[i].Path, o.Path, specs[i].Var)
+		assert.Equal(t, w, pos{o.Line, o.Column, o.Transforms, o.Value}, specs[i].Var)
+	}
+}
+
+// funcSource reads documents with a function; documents are selected like memSource.
+type funcSource func(ctx context.Context, s spec.ExtractSpec) ([]byte, error)
+
+func (f funcSource) Read(ctx context.Context, s spec.ExtractSpec) ([]byte, error) { return f(ctx, s) }
+func (f funcSource) Parse(data []byte) (any, error)                               { return memSource{}.Parse(data) }
+func (f funcSource) Select(doc any, key string) (any, error)                      { return memSource{}.Select(doc, key) }
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Same result for any parallelism", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		var specs []spec.ExtractSpec
+		for i := range 20 {
+			path := filepath.Join(dir, fmt.Sprintf("%02d.env", i))
+			require.NoError(t, os.WriteFile(path, fmt.Appendf(nil, "V=%d\n", i), 0o600))
+			specs = append(specs, spec.ExtractSpec{Kind: spec.KindFILE, Path: path, Key: "V", Var: fmt.Sprintf("V%d", i%15)})
+		}
+
+		wantVars, wantOrigins, err := Resolve(context.Background(), specs, 1)
+		require.NoError(t, err)
+		require.Len(t, wantVars, 15)
+		assert.Equal(t, spec.Var{Name: "V0", Value: "15"}, wantVars[0]) // last value, first position
+		for _, n := range []int{0, 4, 32} {
+			vars, origins, err := Resolve(context.Background(), specs, n)
+			require.NoError(t, err)
+			assert.Equal(t, wantVars, vars, "parallel %d", n)
+			assert.Equal(t, wantOrigins, origins, "parallel %d", n)
+		}
+	})
+
+	t.Run("Bounded concurrency", func(t *testing.T) {
+		t.Parallel()
+		var running, peak atomic.Int32
+		const kind spec.Kind = "test-bounded"
+		Register(kind, funcSource(func(_ context.Context, s spec.ExtractSpec) ([]byte, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(10 * time.Millisecond)
+			return []byte(s.Path), nil
+		}))
+
+		var specs []spec.ExtractSpec
+		for i := range 12 {
+			specs = append(specs, spec.ExtractSpec{Kind: kind, Path: strconv.Itoa(i), Key: "value", Var: fmt.Sprintf("V%d", i)})
+		}
+		vars, _, err := Resolve(context.Background(), specs, 3)
+		require.NoError(t, err)
+		require.Len(t, vars, 12)
+		assert.Equal(t, "11", vars[11].Value)
+		assert.Equal(t, int32(3), peak.Load())
+	})
+
+	t.Run("First failing spec is reported", func(t *testing.T) {
+		t.Parallel()
+		const kind spec.Kind = "test-ordered"
+		Register(kind, funcSource(func(_ context.Context, s spec.ExtractSpec) ([]byte, error) {
+			switch s.Path {
+			case "slow":
+				time.Sleep(50 * time.Millisecond) // fails after "fast"
+				return nil, fmt.Errorf("slow failed")
+			case "fast":
+				return nil, fmt.Errorf("fast failed")
+			}
+			return []byte("ok"), nil
+		}))
+
+		specs := []spec.ExtractSpec{
+			{Kind: kind, Path: "ok", Key: "value", Var: "A"},
+			{Kind: kind, Path: "slow", Key: "value", Var: "B"},
+			{Kind: kind, Path: "fast", Key: "value", Var: "C"},
+		}
+		_, _, err := Resolve(context.Background(), specs, 3)
+		require.Error(t, err)
+		assert.EqualError(t, err, `test-ordered "B" (path="slow"): slow failed`)
+	})
+	t.Run("Shared documents are read once", func(t *testing.T) {
+		t.Parallel()
+		var mu sync.Mutex
+		reads := map[string]int{}
+		const kind spec.Kind = "test-shared"
+		Register(kind, funcSource(func(_ context.Context, s spec.ExtractSpec) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reads[s.Path+"/"+s.Identity]++
+			return []byte(s.Path), nil
+		}))
+
+		specs := []spec.ExtractSpec{
+			{Kind: kind, Path: "a", Key: "value", Var: "A1"},
+			{Kind: kind, Path: "b", Key: "value", Var: "B"},
+			{Kind: kind, Path: "a", Var: "A2"},
+			{Kind: kind, Path: "a", Key: "value", Var: "A3", Literal: true},
+			{Kind: kind, Path: "a", Identity: "k", Key: "value", Var: "A4"},
+		}
+		vars, _, err := Resolve(context.Background(), specs, 3)
+		require.NoError(t, err)
+		assert.Equal(t, []spec.Var{{Name: "A1", Value: "a"}, {Name: "B", Value: "b"}, {Name: "A2", Value: "a"}, {Name: "A3", Value: "a"}, {Name: "A4", Value: "a"}}, vars)
+		assert.Equal(t, map[string]int{"a/": 1, "b/": 1, "a/k": 1}, reads)
+	})
+
+	t.Run("Shared document failing reports its first spec", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int32
+		const kind spec.Kind = "test-shared-failing"
+		Register(kind, funcSource(func(_ context.Context, s spec.ExtractSpec) ([]byte, error) {
+			if s.Path == "bad" {
+				calls.Add(1)
+				return nil, fmt.Errorf("bad failed")
+			}
+			return []byte("ok"), nil
+		}))
+
+		specs := []spec.ExtractSpec{
+			{Kind: kind, Path: "ok", Key: "value", Var: "A"},
+			{Kind: kind, Path: "bad", Key: "value", Var: "B"},
+			{Kind: kind, Path: "bad", Key: "value", Var: "C"},
+		}
+		_, _, err := Resolve(context.Background(), specs, 2)
+		require.Error(t, err)
+		assert.EqualError(t, err, `test-shared-failing "B" (path="bad"): bad failed`)
+		assert.Equal(t, int32(1), calls.Load())
 	})
 }
@@ -0,0 +1,149 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scalar is a leaf value of a parsed document.
+//
+// Value holds the typed value decoded by the format's parser and Literal the
+// scalar exactly as written in the source (without surrounding quotes).
+// Canonical rendering depends only on Value, so the same logical value yields
+// the same output regardless of the source format.
+// Line and Column locate the scalar in the source if the parser reports it.
+type Scalar struct {
+	Value   any    // nil, bool, int64, uint64, *big.Int, float64, string, time.Time or a fmt.Stringer
+	Literal string // source text, e.g. "yes", "1e3", "0x1F", "~"
+	Line    int    // 1-based line of the value; 0 if unknown
+	Column  int    // 1-based byte column of the value; 0 if unknown
+}
+
+// String returns the canonical form of the scalar.
+func (s Scalar) String() string {
+	return canonical(s.Value)
+}
+
+// render stringifies a selected value.
+// Scalars render canonically, or as their source literal if literal is set.
+// Maps and lists always render as compact JSON with canonical scalars.
+func render(v any, literal bool) (string, error) {
+	switch vv := v.(type) {
+	case Scalar:
+		if literal {
+			return vv.Literal, nil
+		}
+		return vv.String(), nil
+	case map[string]any, []any:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(plain(vv)); err != nil {
+			return "", fmt.Errorf("encoding value as JSON: %w", err)
+		}
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	default:
+		return canonical(vv), nil
+	}
+}
+
+// canonical renders a typed scalar value:
+//
+//	null               → "" (empty)
+//	true/false         → "true"/"false"
+//	integers           → base 10, no sign for zero, no separators ("0x1F" → "31")
+//	integral floats    → like integers ("1e3" → "1000", "1.0" → "1")
+//	other floats       → shortest round-trip form, exponent only below 1e-6 or from 1e21
+//	±infinity, NaN     → "inf", "-inf", "nan"
+//	timestamps         → RFC 3339 with optional fractional seconds
+//	strings            → unchanged
+func canonical(v any) string {
+	switch vv := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return vv
+	case bool:
+		return strconv.FormatBool(vv)
+	case int:
+		return strconv.Itoa(vv)
+	case int64:
+		return strconv.FormatInt(vv, 10)
+	case uint64:
+		return strconv.FormatUint(vv, 10)
+	case *big.Int:
+		return vv.String()
+	case float64:
+		return formatFloat(vv)
+	case time.Time:
+		return vv.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return vv.String()
+	default:
+		return fmt.Sprint(vv)
+	}
+}
+
+// formatFloat renders f in the canonical float form described on canonical.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == 0:
+		return "0" // also normalizes -0
+	}
+	abs := math.Abs(f)
+	if abs < 1e-6 || abs >= 1e21 {
+		// Same exponent form as encoding/json: 1e+21 → 1e21, 1e-07 → 1e-7.
+		s := strconv.FormatFloat(f, 'e', -1, 64)
+		s = strings.Replace(s, "e+", "e", 1)
+		if i := strings.Index(s, "e-0"); i >= 0 {
+			s = s[:i+2] + s[i+3:]
+		}
+		return s
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// plain converts a document subtree into values encoding/json can marshal,
+// keeping numbers and booleans typed and rendering everything else canonically.
+func plain(v any) any {
+	switch vv := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(vv))
+		for k, e := range vv {
+			out[k] = plain(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(vv))
+		for i, e := range vv {
+			out[i] = plain(e)
+		}
+		return out
+	case Scalar:
+		switch val := vv.Value.(type) {
+		case nil, bool, string, int, int64, uint64, *big.Int:
+			return val
+		case float64:
+			if math.IsNaN(val) || math.IsInf(val, 0) {
+				return formatFloat(val)
+			}
+			return json.Number(formatFloat(val))
+		default:
+			return canonical(val)
+		}
+	default:
+		return canonical(vv)
+	}
+}
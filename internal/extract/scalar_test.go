@@ -0,0 +1,248 @@
+package extract
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonical(t *testing.T) {
+	t.Parallel()
+
+	big20, _ := new(big.Int).SetString("12345678901234567890", 10)
+
+	tests := []struct {
+		name string
+		in   any
+		want string
+	}{
+		{name: "Null", in: nil, want: ""},
+		{name: "True", in: true, want: "true"},
+		{name: "False", in: false, want: "false"},
+		{name: "Int", in: int64(-42), want: "-42"},
+		{name: "Uint", in: uint64(18446744073709551615), want: "18446744073709551615"},
+		{name: "Big int", in: big20, want: "12345678901234567890"},
+		{name: "Integral float", in: 1e3, want: "1000"},
+		{name: "Negative zero", in: math.Copysign(0, -1), want: "0"},
+		{name: "Fraction", in: 0.5, want: "0.5"},
+		{name: "Small float", in: 1.5e-7, want: "1.5e-7"},
+		{name: "Large float", in: 1e21, want: "1e21"},
+		{name: "Infinity", in: math.Inf(1), want: "inf"},
+		{name: "Negative infinity", in: math.Inf(-1), want: "-inf"},
+		{name: "NaN", in: math.NaN(), want: "nan"},
+		{name: "Time", in: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), want: "2024-01-02T03:04:05Z"},
+		{name: "String", in: "  as is ", want: "  as is "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, canonical(tt.in))
+		})
+	}
+}
+
+// canonicalCase is one selector with its expected canonical and literal rendering.
+type canonicalCase struct {
+	key     string
+	want    string
+	literal string
+}
+
+func runCanonicalCases(t *testing.T, kind spec.Kind, file, content string, cases []canonicalCase) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), file)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o666))
+
+	for _, c := range cases {
+		s := spec.ExtractSpec{Kind: kind, Path: path, Key: c.key}
+
+		got, err := extractValue(context.Background(), s)
+		require.NoError(t, err, c.key)
+		assert.Equal(t, c.want, got.Value, "canonical %s", c.key)
+
+		s.Literal = true
+		got, err = extractValue(context.Background(), s)
+		require.NoError(t, err, c.key)
+		assert.Equal(t, c.literal, got.Value, "literal %s", c.key)
+	}
+}
+
+func TestExtractValue_CanonicalJSON(t *testing.T) {
+	t.Parallel()
+
+	runCanonicalCases(t, spec.KindJSON, "cfg.json", `{
+		"bool": true,
+		"int": 42,
+		"exp": 1e3,
+		"float": 1.50,
+		"big": 12345678901234567890,
+		"null": null,
+		"str": "x<y>",
+		"list": [1, 1e3, "a", null, false],
+		"obj": {"b": 2.0, "a": "<x>"}
+	}`, []canonicalCase{
+		{key: "bool", want: "true", literal: "true"},
+		{key: "int", want: "42", literal: "42"},
+		{key: "exp", want: "1000", literal: "1e3"},
+		{key: "float", want: "1.5", literal: "1.50"},
+		{key: "big", want: "12345678901234567890", literal: "12345678901234567890"},
+		{key: "null", want: "", literal: "null"},
+		{key: "str", want: "x<y>", literal: "x<y>"},
+		{key: "list", want: `[1,1000,"a",null,false]`, literal: `[1,1000,"a",null,false]`},
+		{key: "obj", want: `{"a":"<x>","b":2}`, literal: `{"a":"<x>","b":2}`},
+	})
+}
+
+func TestExtractValue_CanonicalYAML(t *testing.T) {
+	t.Parallel()
+
+	runCanonicalCases(t, spec.KindYAML, "cfg.yaml", `
+country: no
+off11: Off
+quoted: "yes"
+caps: True
+bool: true
+int: 0x1F
+octal: 0o17
+exp: 1e3
+float: 1.0
+inf: .inf
+nan: .NaN
+null: ~
+empty:
+ts: 2024-01-02T03:04:05Z
+str: plain text
+base: &base
+  port: 80
+derived:
+  <<: *base
+  host: h
+`, []canonicalCase{
+		{key: "country", want: "no", literal: "no"},
+		{key: "caps", want: "true", literal: "True"},
+		{key: "off11", want: "Off", literal: "Off"},
+		{key: "quoted", want: "yes", literal: "yes"},
+		{key: "bool", want: "true", literal: "true"},
+		{key: "int", want: "31", literal: "0x1F"},
+		{key: "octal", want: "15", literal: "0o17"},
+		{key: "exp", want: "1000", literal: "1e3"},
+		{key: "float", want: "1", literal: "1.0"},
+		{key: "inf", want: "inf", literal: ".inf"},
+		{key: "nan", want: "nan", literal: ".NaN"},
+		{key: "null", want: "", literal: "~"},
+		{key: "empty", want: "", literal: ""},
+		{key: "ts", want: "2024-01-02T03:04:05Z", literal: "2024-01-02T03:04:05Z"},
+		{key: "str", want: "plain text", literal: "plain text"},
+		{key: "derived", want: `{"host":"h","port":80}`, literal: `{"host":"h","port":80}`},
+		{key: "derived.port", want: "80", literal: "80"},
+	})
+}
+
+func TestExtractValue_CanonicalTOML(t *testing.T) {
+	t.Parallel()
+
+	runCanonicalCases(t, spec.KindTOML, "cfg.toml", `
+bool = true
+hex = 0x1F
+sep = 1_000
+exp = 1e3
+float = 1.50
+inf = -inf
+str = 'single'
+date = 2024-01-02
+dt = 2024-01-02T03:04:05Z
+list = [1, 1e3, "a"]
+inline = { b = 2.0, a = "x" }
+
+[server]
+port = 8080
+
+[[servers]]
+name = "a"
+port = 0x50
+
+[[servers]]
+name = "b"
+port = 1_080
+
+[servers.tls]
+enabled = true
+`, []canonicalCase{
+		{key: "bool", want: "true", literal: "true"},
+		{key: "hex", want: "31", literal: "0x1F"},
+		{key: "sep", want: "1000", literal: "1_000"},
+		{key: "exp", want: "1000", literal: "1e3"},
+		{key: "float", want: "1.5", literal: "1.50"},
+		{key: "inf", want: "-inf", literal: "-inf"},
+		{key: "str", want: "single", literal: "single"},
+		{key: "date", want: "2024-01-02", literal: "2024-01-02"},
+		{key: "dt", want: "2024-01-02T03:04:05Z", literal: "2024-01-02T03:04:05Z"},
+		{key: "list.1", want: "1000", literal: "1e3"},
+		{key: "list", want: `[1,1000,"a"]`, literal: `[1,1000,"a"]`},
+		{key: "inline.b", want: "2", literal: "2.0"},
+		{key: "server.port", want: "8080", literal: "8080"},
+		{key: "servers.0.port", want: "80", literal: "0x50"},
+		{key: "servers.[name=b].port", want: "1080", literal: "1_080"},
+		{key: "servers.1.tls.enabled", want: "true", literal: "true"},
+	})
+}
+
+func TestExtractValue_CanonicalINIAndFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("INI values are strings", func(t *testing.T) {
+		t.Parallel()
+		runCanonicalCases(t, spec.KindINI, "conf.ini", "top = 1e3\n[S]\nflag = yes\nnested.key = v\n", []canonicalCase{
+			{key: "top", want: "1e3", literal: "1e3"},
+			{key: "S.flag", want: "yes", literal: "yes"},
+			{key: "S.nested.key", want: "v", literal: "v"},
+		})
+	})
+
+	t.Run("Key-value values are strings", func(t *testing.T) {
+		t.Parallel()
+		runCanonicalCases(t, spec.KindFILE, "app.env", "export A=1e3\nB=\"a\\tb\" # comment\nC='x#y'\nA=second\n", []canonicalCase{
+			{key: "A", want: "1e3", literal: "1e3"},
+			{key: "B", want: "a\tb", literal: "a\tb"},
+			{key: "C", want: "x#y", literal: "x#y"},
+		})
+	})
+}
+
+func TestExtractValue_SameValueAcrossFormats(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[spec.Kind]string{
+		spec.KindJSON: `{"enabled": true, "size": 1e3, "ratio": 0.50, "tags": ["a", 1.0]}`,
+		spec.KindYAML: "enabled: true\nsize: 1000.0\nratio: .5\ntags: [a, 1]\n",
+		spec.KindTOML: "enabled = true\nsize = 1_000\nratio = 5e-1\ntags = [\"a\", 1]\n",
+	}
+	want := map[string]string{
+		"enabled": "true",
+		"size":    "1000",
+		"ratio":   "0.5",
+		"tags":    `["a",1]`,
+	}
+
+	for kind, content := range files {
+		path := filepath.Join(dir, "cfg."+string(kind))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o666))
+
+		for key, w := range want {
+			got, err := extractValue(context.Background(), spec.ExtractSpec{Kind: kind, Path: path, Key: key})
+			require.NoError(t, err, "%s %s", kind, key)
+			assert.Equal(t, w, got.Value, "%s %s", kind, key)
+		}
+	}
+}
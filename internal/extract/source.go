@@ -0,0 +1,232 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gi8lino/unveil/internal/decrypt"
+	"github.com/gi8lino/unveil/internal/fetch"
+	"github.com/gi8lino/unveil/internal/sops"
+	"github.com/gi8lino/unveil/internal/spec"
+
+	"github.com/containeroo/resolver/selector"
+	"gopkg.in/ini.v1"
+)
+
+// Source reads and evaluates the documents of one kind.
+type Source interface {
+	// Read returns the raw document s refers to. Without a selector, the
+	// trimmed document is the value.
+	Read(ctx context.Context, s spec.ExtractSpec) ([]byte, error)
+	// Parse parses a document into a tree of map[string]any, []any and
+	// Scalar leaves.
+	Parse(data []byte) (any, error)
+	// Select evaluates key against a tree returned by Parse.
+	Select(doc any, key string) (any, error)
+}
+
+// Describer is implemented by sources that tell the CLI how to offer their
+// kind. Sources without it are described as local files without decryption
+// or URL support.
+type Describer interface {
+	Describe() Description
+}
+
+// Description tells the CLI how to offer a source kind.
+type Description struct {
+	Title    string // heading of the flag group; empty means "KIND files:"
+	PathHelp string // help of the path flag; empty means "path to KIND file", or its URL
+	Secret   bool   // values are secret unless an instance is marked public
+	Remote   bool   // paths never name local files, so watch mode cannot observe them
+	Decrypt  bool   // instances accept decrypt and identity
+	URLs     bool   // paths may be http(s) URLs with request options
+}
+
+// decryptingSource is implemented by sources that report whether the
+// document they read was decrypted. Values of decrypted documents are secret
+// unless the spec is public.
+type decryptingSource interface {
+	readDecrypted(ctx context.Context, s spec.ExtractSpec) ([]byte, bool, error)
+}
+
+// registry holds the sources by kind, in registration order.
+var registry = struct {
+	sync.RWMutex
+	kinds   []spec.Kind
+	sources map[spec.Kind]Source
+}{sources: make(map[spec.Kind]Source)}
+
+// Register makes src available for kind. Each kind becomes a dynamic flag
+// group of the CLI. It panics if kind is registered twice.
+func Register(kind spec.Kind, src Source) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.sources[kind]; ok {
+		panic(fmt.Sprintf("extract: source %q registered twice", kind))
+	}
+	registry.kinds = append(registry.kinds, kind)
+	registry.sources[kind] = src
+}
+
+// Kinds returns the registered kinds in registration order.
+func Kinds() []spec.Kind {
+	registry.RLock()
+	defer registry.RUnlock()
+	return append([]spec.Kind(nil), registry.kinds...)
+}
+
+// sourceFor returns the source registered for kind.
+func sourceFor(kind spec.Kind) (Source, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	src, ok := registry.sources[kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported kind %q", kind)
+	}
+	return src, nil
+}
+
+// Describe returns the description of kind, with defaults filled in.
+func Describe(kind spec.Kind) Description {
+	var d Description
+	if src, err := sourceFor(kind); err == nil {
+		if ds, ok := src.(Describer); ok {
+			d = ds.Describe()
+		}
+	}
+	if d.Title == "" {
+		d.Title = string(kind) + " files:"
+	}
+	if d.PathHelp == "" {
+		d.PathHelp = "path to " + string(kind) + " file"
+		if d.URLs {
+			d.PathHelp += ", or its http(s) URL"
+		}
+	}
+	return d
+}
+
+// Watchable reports whether s reads a local file, which watch mode can observe.
+func Watchable(s spec.ExtractSpec) bool {
+	return !Describe(s.Kind).Remote && !s.Remote()
+}
+
+func init() {
+	Register(spec.KindJSON, fileSource{parse: parseJSON, sel: selectPath, sops: sops.FormatJSON})
+	Register(spec.KindYAML, fileSource{parse: parseYAML, sel: selectPath, sops: sops.FormatYAML})
+	Register(spec.KindFILE, fileSource{parse: parseKeyValue, sel: selectKey, sops: sops.FormatDotenv})
+	Register(spec.KindTOML, fileSource{parse: parseTOML, sel: selectPath})
+	Register(spec.KindINI, fileSource{parse: parseINI, sel: selectINI, sops: sops.FormatINI})
+	Register(spec.KindVault, vaultSource{})
+}
+
+// fileSource reads local files, or http(s) URLs with the options of the spec;
+// environment variables in the path are expanded. Reads give up when the
+// context is done. Files are decrypted in memory as the spec requests, and
+// SOPS files too if sops is set.
+type fileSource struct {
+	parse func([]byte) (any, error)
+	sel   func(doc any, key string) (any, error)
+	sops  sops.Format
+}
+
+func (f fileSource) Describe() Description {
+	return Description{Decrypt: true, URLs: true}
+}
+
+func (f fileSource) Read(ctx context.Context, s spec.ExtractSpec) ([]byte, error) {
+	data, _, err := f.readDecrypted(ctx, s)
+	return data, err
+}
+
+func (f fileSource) readDecrypted(ctx context.Context, s spec.ExtractSpec) ([]byte, bool, error) {
+	path := s.FilePath()
+	if strings.TrimSpace(path) == "" {
+		return nil, false, fmt.Errorf("empty file path")
+	}
+	var data []byte
+	var err error
+	if s.Remote() {
+		data, err = fetch.Get(ctx, path, s.HTTP)
+		if err != nil {
+			err = fmt.Errorf("fetching URL: %w", err)
+			if !errors.Is(err, fetch.ErrNotFound) {
+				err = mark(ReasonRead, err) // e.g. a missing bearer token file
+			}
+			return nil, false, err
+		}
+	} else if data, err = readFile(ctx, path); err != nil {
+		return nil, false, fmt.Errorf("reading file: %w", err)
+	}
+	switch s.Decrypt {
+	case spec.DecryptNone:
+	case spec.DecryptAge:
+		if data, err = decrypt.Age(data, s.IdentityFile()); err != nil {
+			return nil, false, mark(ReasonRead, fmt.Errorf("decrypting age file: %w", err))
+		}
+	default:
+		return nil, false, fmt.Errorf("unsupported decryption %q", s.Decrypt)
+	}
+	decrypted := s.Decrypt != spec.DecryptNone
+	if f.sops == "" || !sops.Encrypted(f.sops, data) {
+		return data, decrypted, nil
+	}
+	keyFile := s.IdentityFile()
+	if keyFile == "" {
+		keyFile = sops.KeyFile()
+	}
+	data, err = sops.Decrypt(f.sops, data, keyFile)
+	if err != nil {
+		return nil, false, mark(ReasonRead, fmt.Errorf("decrypting sops file: %w", err))
+	}
+	return data, true, nil
+}
+
+// readFile reads path until ctx is done. A read blocked on a hung mount or a
+// FIFO without writer cannot be interrupted; it is abandoned instead.
+func readFile(ctx context.Context, path string) ([]byte, error) {
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		data, err := os.ReadFile(path)
+		done <- result{data: data, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f fileSource) Parse(data []byte) (any, error)          { return f.parse(data) }
+func (f fileSource) Select(doc any, key string) (any, error) { return f.sel(doc, key) }
+
+// selectPath evaluates a dotted path with array indexes and [field=value] filters.
+func selectPath(doc any, key string) (any, error) {
+	return navigate(doc, selector.ParsePath(key))
+}
+
+// selectKey takes key verbatim.
+func selectKey(doc any, key string) (any, error) {
+	return navigate(doc, []string{key})
+}
+
+// selectINI evaluates "Section.Key", or "Key" for the default section.
+func selectINI(doc any, key string) (any, error) {
+	section, name, ok := strings.Cut(key, ".")
+	if !ok {
+		section, name = ini.DefaultSection, key
+	}
+	if strings.TrimSpace(name) == "" {
+		return nil, fmt.Errorf("empty key in %q", key)
+	}
+	return navigate(doc, []string{section, name})
+}
@@ -0,0 +1,313 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/gi8lino/unveil/internal/fetch"
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/gi8lino/unveil/internal/validate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memSource serves documents from memory.
+type memSource map[string]string
+
+func (m memSource) Read(_ context.Context, s spec.ExtractSpec) ([]byte, error) {
+	v, ok := m[s.Path]
+	if !ok {
+		return nil, fmt.Errorf("no document %q", s.Path)
+	}
+	return []byte(v), nil
+}
+
+func (m memSource) Parse(data []byte) (any, error) {
+	return map[string]any{"value": Scalar{Value: string(data), Literal: string(data)}}, nil
+}
+
+func (m memSource) Select(doc any, key string) (any, error) {
+	return navigate(doc, []string{key})
+}
+
+func TestRegister(t *testing.T) {
+	t.Parallel()
+
+	const kind spec.Kind = "test-mem"
+	Register(kind, memSource{"greeting": " hello "})
+
+	t.Run("Builtin kinds first", func(t *testing.T) {
+		t.Parallel()
+		kinds := Kinds()
+		require.GreaterOrEqual(t, len(kinds), 6)
+		assert.Equal(t, []spec.Kind{spec.KindJSON, spec.KindYAML, spec.KindFILE, spec.KindTOML, spec.KindINI, spec.KindVault}, kinds[:6])
+		assert.Contains(t, kinds, kind)
+	})
+
+	t.Run("Extracts with the registered source", func(t *testing.T) {
+		t.Parallel()
+		vars, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: kind, Path: "greeting", Key: "value", Var: "SELECTED"},
+			{Kind: kind, Path: "greeting", Var: "WHOLE"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []spec.Var{{Name: "SELECTED", Value: " hello "}, {Name: "WHOLE", Value: "hello"}}, vars)
+	})
+
+	t.Run("Source errors", func(t *testing.T) {
+		t.Parallel()
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{{Kind: kind, Path: "missing", Key: "value", Var: "X"}})
+		require.Error(t, err)
+		assert.EqualError(t, err, `test-mem "X" (path="missing"): no document "missing"`)
+	})
+
+	t.Run("Duplicate registration panics", func(t *testing.T) {
+		t.Parallel()
+		assert.PanicsWithValue(t, `extract: source "json" registered twice`, func() {
+			Register(spec.KindJSON, memSource{})
+		})
+	})
+
+	t.Run("Unknown kind", func(t *testing.T) {
+		t.Parallel()
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{{Kind: "xml", Path: "x", Var: "X"}})
+		require.Error(t, err)
+		assert.EqualError(t, err, `xml "X" (path="x"): unsupported kind "xml"`)
+	})
+}
+
+func TestDescribe(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Files", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, Description{Title: "json files:", PathHelp: "path to json file, or its http(s) URL", Decrypt: true, URLs: true}, Describe(spec.KindJSON))
+	})
+
+	t.Run("Vault", func(t *testing.T) {
+		t.Parallel()
+		d := Describe(spec.KindVault)
+		assert.Equal(t, "vault secrets:", d.Title)
+		assert.True(t, d.Secret)
+		assert.True(t, d.Remote)
+		assert.False(t, d.Decrypt)
+		assert.False(t, d.URLs)
+	})
+
+	t.Run("Source without Describer", func(t *testing.T) {
+		t.Parallel()
+		const kind spec.Kind = "test-undescribed"
+		Register(kind, memSource{})
+		assert.Equal(t, Description{Title: "test-undescribed files:", PathHelp: "path to test-undescribed file"}, Describe(kind))
+	})
+}
+
+func TestWatchable(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, Watchable(spec.ExtractSpec{Kind: spec.KindYAML, Path: "/etc/app.yaml"}))
+	assert.False(t, Watchable(spec.ExtractSpec{Kind: spec.KindYAML, Path: "https://example.com/app.yaml"}))
+	assert.False(t, Watchable(spec.ExtractSpec{Kind: spec.KindVault, Path: "secret/data/app"}))
+}
+
+func TestFileSource_Read(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Gives up on a blocked read", func(t *testing.T) {
+		t.Parallel()
+		fifo := filepath.Join(t.TempDir(), "fifo")
+		require.NoError(t, syscall.Mkfifo(fifo, 0o600)) // opening blocks without a writer
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := ExtractAll(ctx, []spec.ExtractSpec{{Kind: spec.KindFILE, Path: fifo, Var: "X"}})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.EqualError(t, err, fmt.Sprintf(`file "X" (path=%q): reading file: context deadline exceeded`, fifo))
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("Decrypts age files before parsing", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		id, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		keys := filepath.Join(dir, "keys.txt")
+		require.NoError(t, os.WriteFile(keys, []byte(id.String()+"\n"), 0o600))
+		var buf bytes.Buffer
+		w, err := age.Encrypt(&buf, id.Recipient())
+		require.NoError(t, err)
+		_, err = io.WriteString(w, "db:\n  password: hunter2\n")
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		path := filepath.Join(dir, "app.yaml.age")
+		require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+
+		vars, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindYAML, Path: path, Key: "db.password", Var: "DB_PASSWORD", Decrypt: spec.DecryptAge, Identity: keys},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []spec.Var{{Name: "DB_PASSWORD", Value: "hunter2", Secret: true}}, vars)
+
+		_, err = ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindYAML, Path: path, Key: "db.password", Var: "DB_PASSWORD", Decrypt: spec.DecryptAge, Identity: "../sops/testdata/key.txt"},
+		})
+		require.Error(t, err)
+		var e *Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, ReasonRead, e.Reason)
+		assert.EqualError(t, err, fmt.Sprintf(`yaml "DB_PASSWORD" (path=%q): decrypting age file: identity did not match any of the recipients: incorrect identity for recipient block`, path))
+	})
+}
+
+func TestFileSource_ReadURL(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer eyJ.token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/app.yaml":
+			_, _ = w.Write([]byte("db:\n  host: db.internal\n  port: 5432\n"))
+		case "/app.ini":
+			_, _ = w.Write([]byte("[db]\nuser = app\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	token := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(token, []byte("eyJ.token\n"), 0o600))
+	opts := fetch.Options{BearerTokenFile: token}
+
+	t.Run("Parses the body as the kind of the spec", func(t *testing.T) {
+		t.Parallel()
+		vars, origins, err := Explain(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindYAML, Path: srv.URL + "/app.yaml", Key: "db.port", Var: "DB_PORT", HTTP: opts},
+			{Kind: spec.KindINI, Path: srv.URL + "/app.ini", Key: "db.user", Var: "DB_USER", HTTP: opts},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []spec.Var{{Name: "DB_PORT", Value: "5432"}, {Name: "DB_USER", Value: "app"}}, vars)
+		assert.Equal(t, srv.URL+"/app.yaml", origins[0].Path)
+		assert.Equal(t, 3, origins[0].Line)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		t.Parallel()
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindJSON, Path: srv.URL + "/missing.json", Key: "a", Var: "A", HTTP: opts},
+		})
+		require.Error(t, err)
+		var e *Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, ReasonMissing, e.Reason)
+		assert.EqualError(t, err, fmt.Sprintf(`json "A" (path=%q): fetching URL: 404 Not Found`, srv.URL+"/missing.json"))
+	})
+
+	t.Run("Rejected request", func(t *testing.T) {
+		t.Parallel()
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindYAML, Path: srv.URL + "/app.yaml", Key: "db.port", Var: "DB_PORT"},
+		})
+		require.Error(t, err)
+		var e *Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, ReasonRead, e.Reason)
+		assert.ErrorContains(t, err, "fetching URL: 401 Unauthorized")
+	})
+
+	t.Run("Missing bearer token file", func(t *testing.T) {
+		t.Parallel()
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindYAML, Path: srv.URL + "/app.yaml", Key: "db.port", Var: "DB_PORT",
+				HTTP: fetch.Options{BearerTokenFile: filepath.Join(t.TempDir(), "token")}},
+		})
+		require.Error(t, err)
+		var e *Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, ReasonRead, e.Reason, "the source itself is not missing")
+	})
+}
+
+func TestFileSource_ReadSOPS(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY_FILE", "../sops/testdata/key.txt")
+
+	t.Run("Decrypts before selecting", func(t *testing.T) {
+		vars, origins, err := Explain(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindYAML, Path: "../sops/testdata/app.enc.yaml", Key: "db.password", Var: "DB_PASSWORD"},
+			{Kind: spec.KindJSON, Path: "../sops/testdata/app.enc.json", Key: "db.port", Var: "DB_PORT"},
+			{Kind: spec.KindFILE, Path: "../sops/testdata/app.enc.env", Key: "GREETING", Var: "GREETING"},
+			{Kind: spec.KindINI, Path: "../sops/testdata/app.enc.ini", Key: "database.password", Var: "INI_PASSWORD"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []spec.Var{
+			{Name: "DB_PASSWORD", Value: "hunter2", Secret: true},
+			{Name: "DB_PORT", Value: "5432", Secret: true},
+			{Name: "GREETING", Value: "hello world", Secret: true},
+			{Name: "INI_PASSWORD", Value: "hunter2", Secret: true},
+		}, vars)
+		assert.Equal(t, 4, origins[0].Line)
+		assert.True(t, origins[0].Spec.Secret)
+	})
+
+	t.Run("Redacts decrypted values", func(t *testing.T) {
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindYAML, Path: "../sops/testdata/app.enc.yaml", Key: "db.password", Var: "A", Rules: validate.Rules{Type: validate.TypeInt}},
+		})
+		require.Error(t, err)
+		assert.EqualError(t, err, `yaml "A": invalid value "***": not a valid int`)
+	})
+
+	t.Run("Public decrypted values", func(t *testing.T) {
+		vars, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindYAML, Path: "../sops/testdata/app.enc.yaml", Key: "db.password", Var: "A", Public: true},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []spec.Var{{Name: "A", Value: "hunter2"}}, vars)
+	})
+
+	t.Run("Plain files stay public", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("db:\n  password: hunter2\n"), 0o600))
+		vars, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindYAML, Path: path, Key: "db.password", Var: "A"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []spec.Var{{Name: "A", Value: "hunter2"}}, vars)
+	})
+
+	t.Run("Decrypts fetched files", func(t *testing.T) {
+		srv := httptest.NewServer(http.FileServer(http.Dir("../sops/testdata")))
+		t.Cleanup(srv.Close)
+		vars, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindJSON, Path: srv.URL + "/app.enc.json", Key: "db.password", Var: "DB_PASSWORD"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []spec.Var{{Name: "DB_PASSWORD", Value: "hunter2", Secret: true}}, vars)
+	})
+
+	t.Run("Decryption errors", func(t *testing.T) {
+		t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join(t.TempDir(), "missing.txt"))
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindYAML, Path: "../sops/testdata/app.enc.yaml", Key: "db.password", Var: "DB_PASSWORD"},
+		})
+		require.Error(t, err)
+		var e *Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, ReasonRead, e.Reason)
+		assert.ErrorContains(t, err, "decrypting sops file: reading age identities: open ")
+	})
+}
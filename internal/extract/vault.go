@@ -0,0 +1,68 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/gi8lino/unveil/internal/vault"
+)
+
+// vaultSource reads secrets from the KV engines of Vault. The document is the
+// data of the secret as JSON, so KV v2 values are below "data". The server
+// and credentials come from the VAULT_* environment variables.
+type vaultSource struct{}
+
+// vaultClients holds a client per configuration, so logins are shared by the
+// specs of a run and reused by watch mode.
+var vaultClients sync.Map // vault.Config -> *vault.Client
+
+func (vaultSource) Describe() Description {
+	return Description{Title: "vault secrets:", PathHelp: "API path of the secret, e.g. secret/data/app", Secret: true, Remote: true}
+}
+
+func (vaultSource) Read(ctx context.Context, s spec.ExtractSpec) ([]byte, error) {
+	path := strings.Trim(s.FilePath(), "/ ")
+	if path == "" {
+		return nil, fmt.Errorf("empty secret path")
+	}
+	if s.Decrypt != spec.DecryptNone {
+		return nil, fmt.Errorf("unsupported decryption %q for vault secrets", s.Decrypt)
+	}
+	client, err := vaultClient()
+	if err != nil {
+		return nil, mark(ReasonRead, fmt.Errorf("configuring vault: %w", err))
+	}
+	data, err := client.Read(ctx, path)
+	if err != nil {
+		err = fmt.Errorf("reading vault secret: %w", err)
+		if !errors.Is(err, vault.ErrNotFound) {
+			err = mark(ReasonRead, err) // e.g. a missing token file
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (vaultSource) Parse(data []byte) (any, error)          { return parseJSON(data) }
+func (vaultSource) Select(doc any, key string) (any, error) { return selectPath(doc, key) }
+
+// vaultClient returns the client for the configuration in the environment.
+func vaultClient() (*vault.Client, error) {
+	cfg, err := vault.ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := vaultClients.Load(cfg); ok {
+		return c.(*vault.Client), nil
+	}
+	c, err := vault.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := vaultClients.LoadOrStore(cfg, c)
+	return actual.(*vault.Client), nil
+}
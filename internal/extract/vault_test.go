@@ -0,0 +1,105 @@
+package extract
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVaultSource_Read(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.root" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/app":
+			_, _ = w.Write([]byte(`{"data":{"data":{"password":"hunter2","port":5432},"metadata":{"version":3}}}`))
+		case "/v1/kv/app":
+			_, _ = w.Write([]byte(`{"data":{"user":"app"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "s.root")
+	t.Setenv("VAULT_MAX_RETRIES", "0")
+
+	t.Run("Selects from KV v1 and v2", func(t *testing.T) {
+		vars, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindVault, Path: "secret/data/app", Key: "data.password", Var: "DB_PASSWORD"},
+			{Kind: spec.KindVault, Path: "secret/data/app", Key: "data.port", Var: "DB_PORT"},
+			{Kind: spec.KindVault, Path: "secret/data/app", Key: "metadata.version", Var: "VERSION"},
+			{Kind: spec.KindVault, Path: "/kv/app", Key: "user", Var: "DB_USER"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []spec.Var{
+			{Name: "DB_PASSWORD", Value: "hunter2"},
+			{Name: "DB_PORT", Value: "5432"},
+			{Name: "VERSION", Value: "3"},
+			{Name: "DB_USER", Value: "app"},
+		}, vars)
+	})
+
+	t.Run("Missing secret", func(t *testing.T) {
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindVault, Path: "kv/missing", Key: "user", Var: "DB_USER"},
+		})
+		require.Error(t, err)
+		var e *Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, ReasonMissing, e.Reason)
+		assert.ErrorContains(t, err, "reading vault secret: secret not found")
+	})
+
+	t.Run("Permission denied", func(t *testing.T) {
+		t.Setenv("VAULT_TOKEN", "s.other")
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindVault, Path: "kv/app", Key: "user", Var: "DB_USER"},
+		})
+		require.Error(t, err)
+		var e *Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, ReasonRead, e.Reason)
+		assert.ErrorContains(t, err, "reading vault secret: permission denied: permission denied")
+	})
+
+	t.Run("Missing token file", func(t *testing.T) {
+		t.Setenv("VAULT_TOKEN", "")
+		t.Setenv("VAULT_TOKEN_FILE", filepath.Join(t.TempDir(), "token"))
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindVault, Path: "kv/app", Key: "user", Var: "DB_USER"},
+		})
+		require.Error(t, err)
+		var e *Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, ReasonRead, e.Reason, "the secret itself is not missing")
+		assert.ErrorContains(t, err, "reading vault secret: reading token: open ")
+	})
+
+	t.Run("Invalid configuration", func(t *testing.T) {
+		t.Setenv("VAULT_MAX_RETRIES", "many")
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindVault, Path: "kv/app", Key: "user", Var: "DB_USER"},
+		})
+		require.Error(t, err)
+		assert.ErrorContains(t, err, `configuring vault: invalid VAULT_MAX_RETRIES "many"`)
+	})
+
+	t.Run("Decryption is rejected", func(t *testing.T) {
+		_, err := ExtractAll(context.Background(), []spec.ExtractSpec{
+			{Kind: spec.KindVault, Path: "kv/app", Key: "user", Var: "DB_USER", Decrypt: spec.DecryptAge},
+		})
+		require.Error(t, err)
+		assert.ErrorContains(t, err, `unsupported decryption "age" for vault secrets`)
+	})
+}
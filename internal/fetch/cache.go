@@ -0,0 +1,69 @@
+package fetch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// cache stores the last body of one URL with its ETag, as NAME.body and
+// NAME.etag where NAME is the SHA-256 of the URL. Files are only readable by
+// their owner since bodies may hold secrets.
+type cache struct {
+	body, etag string
+}
+
+func newCache(dir, url string) *cache {
+	sum := sha256.Sum256([]byte(url))
+	name := filepath.Join(dir, hex.EncodeToString(sum[:]))
+	return &cache{body: name + ".body", etag: name + ".etag"}
+}
+
+// load returns the cached ETag and body, or nothing.
+func (c *cache) load() (string, []byte) {
+	etag, err := os.ReadFile(c.etag)
+	if err != nil || len(etag) == 0 {
+		return "", nil
+	}
+	body, err := os.ReadFile(c.body)
+	if err != nil {
+		return "", nil
+	}
+	return string(etag), body
+}
+
+// store caches body under etag, or drops the entry if etag is empty. The cache
+// only saves requests, so failures to update it are ignored.
+func (c *cache) store(etag string, body []byte) {
+	if etag == "" {
+		_ = os.Remove(c.etag)
+		_ = os.Remove(c.body)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.body), 0o700); err != nil {
+		return
+	}
+	// The ETag is written last, so it never describes another body.
+	_ = os.Remove(c.etag)
+	if writeAtomic(c.body, body) == nil {
+		_ = writeAtomic(c.etag, []byte(etag))
+	}
+}
+
+// writeAtomic replaces path with data.
+func writeAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".fetch-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
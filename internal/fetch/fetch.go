@@ -0,0 +1,191 @@
+// Package fetch reads source documents from http and https URLs.
+package fetch
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSize is the largest body accepted if Options.MaxSize is 0.
+const DefaultMaxSize = 10 << 20
+
+// ErrNotFound is returned for URLs answered with 404 Not Found. It matches
+// fs.ErrNotExist.
+var ErrNotFound error = notFound{}
+
+type notFound struct{}
+
+func (notFound) Error() string        { return "404 Not Found" }
+func (notFound) Is(target error) bool { return target == fs.ErrNotExist }
+
+// Options configure a request.
+type Options struct {
+	Headers         []string      // "Name: value" request headers; environment variables in the value are expanded
+	HeaderFiles     []string      // "Name: FILE" request headers whose value is read from FILE
+	BearerTokenFile string        // file holding a token sent as "Authorization: Bearer TOKEN"
+	CAFile          string        // PEM bundle of CAs to trust instead of the system ones
+	Timeout         time.Duration // limit of the request; 0 waits as long as the context
+	MaxSize         int64         // largest accepted body in bytes; 0 means DefaultMaxSize
+	CacheDir        string        // directory caching bodies by ETag; empty disables caching
+}
+
+// IsURL reports whether path is an http or https URL.
+func IsURL(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://")
+}
+
+// ParseHeader splits "Name: value".
+func ParseHeader(s string) (string, string, error) {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return "", "", fmt.Errorf("invalid header %q: want NAME: VALUE", s)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// Get returns the body of url. With o.CacheDir, a cached body is sent with
+// its ETag and reused if the server answers 304 Not Modified.
+func Get(ctx context.Context, url string, o Options) ([]byte, error) {
+	header, err := o.header()
+	if err != nil {
+		return nil, err
+	}
+	client, err := clientFor(o.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	parent := ctx
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+	maxSize := o.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	var c *cache
+	var etag string
+	var cached []byte
+	if o.CacheDir != "" {
+		c = newCache(o.CacheDir, url)
+		if etag, cached = c.load(); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && parent.Err() == nil {
+			return nil, fmt.Errorf("request timed out after %s: %w", o.Timeout, err)
+		}
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return cached, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, errors.New(resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("body of %d bytes exceeds the limit of %d bytes", resp.ContentLength, maxSize)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("body exceeds the limit of %d bytes", maxSize)
+	}
+	if c != nil {
+		c.store(resp.Header.Get("ETag"), data)
+	}
+	return data, nil
+}
+
+// header builds the request headers of o. Files are read on every request so
+// rotated tokens are picked up.
+func (o Options) header() (http.Header, error) {
+	h := http.Header{}
+	for _, line := range o.Headers {
+		name, value, err := ParseHeader(line)
+		if err != nil {
+			return nil, err
+		}
+		h.Add(name, os.ExpandEnv(value))
+	}
+	for _, line := range o.HeaderFiles {
+		name, file, err := ParseHeader(line)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readValue(os.ExpandEnv(file))
+		if err != nil {
+			return nil, fmt.Errorf("reading header %s: %w", name, err)
+		}
+		h.Add(name, value)
+	}
+	if o.BearerTokenFile != "" {
+		token, err := readValue(os.ExpandEnv(o.BearerTokenFile))
+		if err != nil {
+			return nil, fmt.Errorf("reading bearer token: %w", err)
+		}
+		h.Set("Authorization", "Bearer "+token)
+	}
+	return h, nil
+}
+
+// readValue reads file without surrounding whitespace.
+func readValue(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// clients holds an HTTP client per CA file, so connections are reused.
+var clients sync.Map // string -> *http.Client
+
+// clientFor returns the client trusting the CAs in caFile, or the system CAs
+// if caFile is empty.
+func clientFor(caFile string) (*http.Client, error) {
+	if c, ok := clients.Load(caFile); ok {
+		return c.(*http.Client), nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	}
+	c, _ := clients.LoadOrStore(caFile, &http.Client{Transport: transport})
+	return c.(*http.Client), nil
+}
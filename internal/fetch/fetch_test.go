@@ -0,0 +1,324 @@
+package fetch
+
+import (
+	"context"
+	"encoding/pem"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile writes content to name in a new temporary directory.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// echoHeaders serves the request headers named in the query as "Name=value" lines.
+func echoHeaders(w http.ResponseWriter, r *http.Request) {
+	for _, name := range r.URL.Query()["h"] {
+		_, _ = w.Write([]byte(name + "=" + strings.Join(r.Header.Values(name), "|") + "\n"))
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config.json", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"db":{"host":"db.internal"}}`))
+	})
+	mux.HandleFunc("/headers", echoHeaders)
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("x", 64)))
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, _ *http.Request) {
+		for range 8 {
+			_, _ = w.Write([]byte(strings.Repeat("x", 8)))
+			w.(http.Flusher).Flush() // no Content-Length
+		}
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	t.Run("Body", func(t *testing.T) {
+		t.Parallel()
+		data, err := Get(context.Background(), srv.URL+"/config.json", Options{})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"db":{"host":"db.internal"}}`, string(data))
+	})
+
+	t.Run("Headers", func(t *testing.T) {
+		t.Parallel()
+		data, err := Get(context.Background(), srv.URL+"/headers?h=Accept&h=X-Api-Key&h=Authorization", Options{
+			Headers:         []string{"Accept: application/json, text/plain", "Accept:text/yaml"},
+			HeaderFiles:     []string{"X-Api-Key: " + writeFile(t, "key", "k-123\n")},
+			BearerTokenFile: writeFile(t, "token", "  eyJ.token\n"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Accept=application/json, text/plain|text/yaml\nX-Api-Key=k-123\nAuthorization=Bearer eyJ.token\n", string(data))
+	})
+
+	t.Run("Missing bearer token file", func(t *testing.T) {
+		t.Parallel()
+		_, err := Get(context.Background(), srv.URL+"/config.json", Options{BearerTokenFile: filepath.Join(t.TempDir(), "token")})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		assert.ErrorContains(t, err, "reading bearer token: ")
+	})
+
+	t.Run("Invalid header", func(t *testing.T) {
+		t.Parallel()
+		_, err := Get(context.Background(), srv.URL+"/config.json", Options{HeaderFiles: []string{"X-Api-Key"}})
+		require.Error(t, err)
+		assert.EqualError(t, err, `invalid header "X-Api-Key": want NAME: VALUE`)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		t.Parallel()
+		_, err := Get(context.Background(), srv.URL+"/missing.json", Options{})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("Server error", func(t *testing.T) {
+		t.Parallel()
+		_, err := Get(context.Background(), srv.URL+"/broken", Options{})
+		require.Error(t, err)
+		assert.EqualError(t, err, "500 Internal Server Error")
+	})
+
+	t.Run("Max size", func(t *testing.T) {
+		t.Parallel()
+		data, err := Get(context.Background(), srv.URL+"/large", Options{MaxSize: 64})
+		require.NoError(t, err)
+		assert.Len(t, data, 64)
+
+		_, err = Get(context.Background(), srv.URL+"/large", Options{MaxSize: 63})
+		require.Error(t, err)
+		assert.EqualError(t, err, "body of 64 bytes exceeds the limit of 63 bytes")
+
+		_, err = Get(context.Background(), srv.URL+"/stream", Options{MaxSize: 63})
+		require.Error(t, err)
+		assert.EqualError(t, err, "body exceeds the limit of 63 bytes")
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		t.Parallel()
+		start := time.Now()
+		_, err := Get(context.Background(), srv.URL+"/slow", Options{Timeout: 50 * time.Millisecond})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorContains(t, err, "request timed out after 50ms: ")
+		assert.Less(t, time.Since(start), 2*time.Second)
+	})
+}
+
+func TestGet_Env(t *testing.T) {
+	t.Setenv("API_TOKEN", "s3cret")
+	t.Setenv("SECRETS", t.TempDir())
+	require.NoError(t, os.WriteFile(filepath.Join(os.Getenv("SECRETS"), "key"), []byte("k-123"), 0o600))
+
+	srv := httptest.NewServer(http.HandlerFunc(echoHeaders))
+	t.Cleanup(srv.Close)
+
+	data, err := Get(context.Background(), srv.URL+"/?h=Authorization&h=X-Api-Key", Options{
+		Headers:     []string{"Authorization: Bearer ${API_TOKEN}"},
+		HeaderFiles: []string{"X-Api-Key: $SECRETS/key"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Authorization=Bearer s3cret\nX-Api-Key=k-123\n", string(data))
+}
+
+func TestGet_TLS(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("a: 1\n"))
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Run("CA file", func(t *testing.T) {
+		t.Parallel()
+		ca := writeFile(t, "ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})))
+		data, err := Get(context.Background(), srv.URL, Options{CAFile: ca})
+		require.NoError(t, err)
+		assert.Equal(t, "a: 1\n", string(data))
+	})
+
+	t.Run("Unknown authority", func(t *testing.T) {
+		t.Parallel()
+		_, err := Get(context.Background(), srv.URL, Options{})
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "certificate")
+	})
+
+	t.Run("Invalid CA file", func(t *testing.T) {
+		t.Parallel()
+		ca := writeFile(t, "ca.pem", "not a certificate\n")
+		_, err := Get(context.Background(), srv.URL, Options{CAFile: ca})
+		require.Error(t, err)
+		assert.EqualError(t, err, "no certificates in "+ca)
+	})
+
+	t.Run("Missing CA file", func(t *testing.T) {
+		t.Parallel()
+		_, err := Get(context.Background(), srv.URL, Options{CAFile: filepath.Join(t.TempDir(), "ca.pem")})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
+
+func TestGet_Cache(t *testing.T) {
+	t.Parallel()
+
+	// The server serves body with etag, answering 304 if the client has it.
+	type doc struct{ body, etag string }
+	newServer := func(t *testing.T, current *atomic.Value, notModified *atomic.Int32) string {
+		t.Helper()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := current.Load().(doc)
+			if d.etag != "" {
+				if r.Header.Get("If-None-Match") == d.etag {
+					notModified.Add(1)
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("ETag", d.etag)
+			}
+			_, _ = w.Write([]byte(d.body))
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL + "/config.yaml"
+	}
+
+	t.Run("Reuses the body while the ETag matches", func(t *testing.T) {
+		t.Parallel()
+		var current atomic.Value
+		var notModified atomic.Int32
+		current.Store(doc{body: "a: 1\n", etag: `"v1"`})
+		url := newServer(t, &current, &notModified)
+		opts := Options{CacheDir: filepath.Join(t.TempDir(), "cache")}
+
+		for range 2 {
+			data, err := Get(context.Background(), url, opts)
+			require.NoError(t, err)
+			assert.Equal(t, "a: 1\n", string(data))
+		}
+		assert.EqualValues(t, 1, notModified.Load())
+
+		current.Store(doc{body: "a: 2\n", etag: `"v2"`})
+		data, err := Get(context.Background(), url, opts)
+		require.NoError(t, err)
+		assert.Equal(t, "a: 2\n", string(data))
+		data, err = Get(context.Background(), url, opts)
+		require.NoError(t, err)
+		assert.Equal(t, "a: 2\n", string(data))
+		assert.EqualValues(t, 2, notModified.Load())
+	})
+
+	t.Run("Cache files are private", func(t *testing.T) {
+		t.Parallel()
+		var current atomic.Value
+		var notModified atomic.Int32
+		current.Store(doc{body: "token: x\n", etag: `W/"1"`})
+		url := newServer(t, &current, &notModified)
+		dir := filepath.Join(t.TempDir(), "cache")
+
+		_, err := Get(context.Background(), url, Options{CacheDir: dir})
+		require.NoError(t, err)
+		info, err := os.Stat(dir)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		for _, e := range entries {
+			info, err := e.Info()
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), e.Name())
+		}
+	})
+
+	t.Run("Responses without ETag drop the entry", func(t *testing.T) {
+		t.Parallel()
+		var current atomic.Value
+		var notModified atomic.Int32
+		current.Store(doc{body: "a: 1\n", etag: `"v1"`})
+		url := newServer(t, &current, &notModified)
+		dir := t.TempDir()
+
+		_, err := Get(context.Background(), url, Options{CacheDir: dir})
+		require.NoError(t, err)
+		current.Store(doc{body: "a: 2\n"})
+		data, err := Get(context.Background(), url, Options{CacheDir: dir})
+		require.NoError(t, err)
+		assert.Equal(t, "a: 2\n", string(data))
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("Without cache directory", func(t *testing.T) {
+		t.Parallel()
+		var current atomic.Value
+		var notModified atomic.Int32
+		current.Store(doc{body: "a: 1\n", etag: `"v1"`})
+		url := newServer(t, &current, &notModified)
+
+		for range 2 {
+			_, err := Get(context.Background(), url, Options{})
+			require.NoError(t, err)
+		}
+		assert.EqualValues(t, 0, notModified.Load())
+	})
+}
+
+func TestParseHeader(t *testing.T) {
+	t.Parallel()
+
+	name, value, err := ParseHeader(" Authorization :  Bearer ${TOKEN} ")
+	require.NoError(t, err)
+	assert.Equal(t, "Authorization", name)
+	assert.Equal(t, "Bearer ${TOKEN}", value)
+
+	_, value, err = ParseHeader("X-Empty:")
+	require.NoError(t, err)
+	assert.Empty(t, value)
+
+	for _, s := range []string{"Authorization", ": value", "X Key: value"} {
+		_, _, err := ParseHeader(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestIsURL(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, IsURL("https://config.internal/app.yaml"))
+	assert.True(t, IsURL("HTTP://localhost:8080/app.json"))
+	assert.False(t, IsURL("/etc/app/config.yaml"))
+	assert.False(t, IsURL("https.yaml"))
+	assert.False(t, IsURL("ftp://files.internal/app.ini"))
+}
@@ -1,16 +1,60 @@
 package flag
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/containeroo/tinyflags"
+	"github.com/gi8lino/unveil/internal/extract"
+	"github.com/gi8lino/unveil/internal/fetch"
+	"github.com/gi8lino/unveil/internal/hook"
+	"github.com/gi8lino/unveil/internal/output"
 	"github.com/gi8lino/unveil/internal/quote"
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/gi8lino/unveil/internal/validate"
+)
+
+// Policies for --on-duplicate.
+const (
+	DuplicateError = "error" // fail when two instances write the same variable
+	DuplicateFirst = "first" // keep the first declared instance
+	DuplicateLast  = "last"  // keep the last declared instance
 )
 
+// DefaultSecretDirs are the default --secret-dir values: the mount points of
+// Docker and Kubernetes secrets.
+var DefaultSecretDirs = []string{"/run/secrets", "/var/run/secrets"}
+
 // Flags holds global options and the parsed FlagSet.
 type Flags struct {
-	Quote   quote.QuoteKind // global default quote mode
-	Output  string          // output file
-	Export  bool            // whether to export all variables
-	FlagSet *tinyflags.FlagSet
+	Quote         quote.QuoteKind    // global default quote mode
+	Output        string             // output file
+	File          output.FileOptions // mode and owner of the output file
+	AllowInsecure bool               // write secrets into world-readable directories
+	Export        bool               // whether to export all variables
+	Literal       bool               // render scalars as written in the source
+	Shell         quote.Shell        // shell dialect of the output lines
+	Format        output.Format      // syntax of the output
+	Watch         bool               // rewrite the output whenever a source changes
+	Debounce      time.Duration      // quiet period before re-rendering in watch mode
+	Hook          hook.Hook          // notification after the output was written
+	Check         bool               // compare with --output instead of writing it
+	Merge         bool               // update --output instead of replacing it
+	Order         output.Order       // order of the written variables
+	Declared      []string           // dynamic instances ("group.id") in command-line order
+	OnDuplicate   string             // how to handle instances writing the same variable
+	Sanitize      bool               // rewrite invalid variable names instead of failing
+	ShowValues    bool               // print values in diagnostics
+	Explain       bool               // print where each value came from
+	SecretDirs    []string           // sources below these directories are secret by default
+	Timeout       time.Duration      // give up reading the sources after this long; 0 waits forever
+	Parallel      int                // number of sources read at once
+	ErrorFormat   string             // how errors are printed: "text" or "json"
+	FlagSet       *tinyflags.FlagSet
 }
 
 // ParseFlags parses command-line arguments into Flags.
@@ -29,14 +73,97 @@ func ParseFlags(args []string, version, commit string) (Flags, error) {
 		Value()
 	fs.StringVar(&flags.Output, "output", "", "write to file instead of stdout").
 		Value()
+	outputMode := fs.String("output-mode", "0600", "permissions of the --output file").
+		Validate(func(s string) error {
+			_, err := output.ParseMode(s)
+			return err
+		}).
+		Placeholder("MODE").
+		Value()
+	outputOwner := fs.String("output-owner", "", "owner of the --output file as uid:gid or user:group").
+		Validate(func(s string) error {
+			_, err := output.ParseOwner(s)
+			return err
+		}).
+		Placeholder("OWNER").
+		Value()
+	fs.BoolVar(&flags.AllowInsecure, "allow-insecure-dir", false, "write secrets even if the --output directory is world-readable").
+		Value()
 	fs.BoolVar(&flags.Export, "export", false, "add \"export\" prefix to all variables").
 		Value()
+	shell := fs.String("shell", string(quote.ShellPOSIX), "shell dialect of the output lines").
+		Choices(choices(quote.Shells)...).
+		Placeholder("SHELL").
+		Value()
+	format := fs.String("format", string(output.FormatEnv), "output format").
+		Choices(choices(output.Formats())...).
+		Placeholder("FORMAT").
+		Value()
+	fs.DurationVar(&flags.Timeout, "timeout", 0, "give up reading the sources after this long (0 waits forever)").
+		Placeholder("DURATION").
+		Value()
+	fs.StringVar(&flags.ErrorFormat, "error-format", "text", "how errors are printed to stderr").
+		Choices("text", "json").
+		Placeholder("FORMAT").
+		Value()
+	fs.IntVar(&flags.Parallel, "parallel", 1, "number of sources read at once").
+		Placeholder("N").
+		Value()
+	fs.BoolVar(&flags.Watch, "watch", false, "keep running and rewrite --output when a source file changes").
+		Value()
+	fs.DurationVar(&flags.Debounce, "watch-debounce", 250*time.Millisecond, "wait for changes to settle before re-rendering").
+		Placeholder("DURATION").
+		Value()
+	onChangeSignal := fs.String("on-change-signal", "", "signal the process in --pid-file after the output was written").
+		Validate(func(s string) error {
+			_, err := hook.ParseSignal(s)
+			return err
+		}).
+		Placeholder("SIGNAL").
+		Value()
+	fs.StringVar(&flags.Hook.PIDFile, "pid-file", "", "file holding the PID for --on-change-signal").
+		Placeholder("FILE").
+		Value()
+	fs.StringVar(&flags.Hook.Exec, "on-change-exec", "", "run a shell command after the output was written").
+		Placeholder("COMMAND").
+		Value()
+	fs.IntVar(&flags.Hook.Retries, "on-change-retries", 3, "retries of a failed on-change hook").
+		Placeholder("N").
+		Value()
+	fs.DurationVar(&flags.Hook.Backoff, "on-change-backoff", time.Second, "delay before the first retry, doubled for each further one").
+		Placeholder("DURATION").
+		Value()
+	fs.BoolVar(&flags.Merge, "merge", false, "update the variables in an existing --output file and keep all other lines").
+		Value()
+	fs.BoolVar(&flags.Check, "check", false, "report whether --output is up to date instead of writing it").
+		Value()
+	fs.BoolVar(&flags.Explain, "explain", false, "print where each value came from to stderr").
+		Short("v").
+		Value()
+	fs.BoolVar(&flags.ShowValues, "show-values", false, "print values in diagnostics instead of redacting them").
+		Value()
+	fs.StringSliceVar(&flags.SecretDirs, "secret-dir", DefaultSecretDirs, "treat sources below this directory as secret").
+		Placeholder("DIR").
+		Value()
+	order := fs.String("order", string(output.OrderSorted), "order of the written variables").
+		Choices(choices(output.Orders)...).
+		Placeholder("ORDER").
+		Value()
+	fs.StringVar(&flags.OnDuplicate, "on-duplicate", DuplicateError, "how to handle instances writing the same variable").
+		Choices(DuplicateError, DuplicateFirst, DuplicateLast).
+		Placeholder("POLICY").
+		Value()
+	fs.BoolVar(&flags.Sanitize, "sanitize-names", false, "rewrite invalid characters in variable names instead of failing").
+		Value()
+	fs.BoolVar(&flags.Literal, "literal", false, "keep scalars as written in the source instead of normalizing them").
+		Value()
 
 	// Shared schema for dynamic groups
-	registerGroup := func(name string) {
-		g := fs.DynamicGroup(name)
-		g.Title(name + " files:")
-		g.String("path", "", "path to "+name+" file").Required()
+	registerGroup := func(kind spec.Kind) {
+		d := extract.Describe(kind)
+		g := fs.DynamicGroup(string(kind))
+		g.Title(d.Title)
+		g.String("path", "", d.PathHelp).Required()
 		g.String("select", "", "selector/key to extract").
 			Placeholder("KEY").
 			Required()
@@ -45,10 +172,66 @@ func ParseFlags(args []string, version, commit string) (Flags, error) {
 		g.String("quote", "", "quote mode").
 			Choices(string(quote.QuoteNone), string(quote.QuoteSingle), string(quote.QuoteDouble), string(quote.QuoteJSON)).
 			Placeholder("MODE")
+		g.Bool("literal", false, "keep the value as written in the source")
+		g.Bool("secret", false, "redact the value in diagnostics")
+		g.Bool("public", false, "do not treat the value as secret even if it comes from a --secret-dir, Vault or a decrypted file")
+		if d.Decrypt {
+			g.String("decrypt", "", "decrypt the whole file in memory before parsing it").
+				Choices(string(spec.DecryptAge)).
+				Placeholder("METHOD")
+			g.String("identity", "", "age identity file for decrypt=age and SOPS files").
+				Placeholder("FILE")
+		}
+		// Requests of URL paths
+		if d.URLs {
+			g.StringSlice("header", nil, "HTTP header for URL paths; environment variables in the value are expanded").
+				Delimiter("\n"). // values may contain commas
+				Validate(validHeader).
+				Placeholder("NAME: VALUE")
+			g.StringSlice("header-file", nil, "HTTP header for URL paths with the value read from a file").
+				Delimiter("\n").
+				Validate(validHeader).
+				Placeholder("NAME: FILE")
+			g.String("bearer-token-file", "", "file holding a bearer token for URL paths").
+				Placeholder("FILE")
+			g.String("ca-file", "", "PEM bundle of CAs to trust for URL paths instead of the system ones").
+				Placeholder("FILE")
+			g.Duration("timeout", 0, "timeout of the request for URL paths (0 waits as long as --timeout)").
+				Placeholder("DURATION")
+			g.Int64("max-size", fetch.DefaultMaxSize, "largest accepted body for URL paths in bytes").
+				Placeholder("BYTES")
+			g.String("cache-dir", "", "cache the body of URL paths by ETag in this directory").
+				Placeholder("DIR")
+		}
+
+		// Validation of the extracted value
+		g.String("type", "", "expected value type").
+			Choices(choices(validate.Types)...).
+			Placeholder("TYPE")
+		g.String("range", "", "allowed numeric range for int and port values").
+			Validate(func(s string) error {
+				_, err := validate.ParseRange(s)
+				return err
+			}).
+			Placeholder("MIN..MAX")
+		g.String("match", "", "regular expression the value must match").
+			Validate(func(s string) error {
+				_, err := regexp.Compile(s)
+				return err
+			}).
+			Placeholder("REGEX")
+		g.StringSlice("enum", nil, "allowed values").
+			Placeholder("VALUE")
+		g.Bool("non-empty", false, "reject empty values")
+		g.Int("min-len", 0, "minimum value length").
+			Placeholder("N")
+		g.Int("max-len", 0, "maximum value length").
+			Placeholder("N")
 	}
 
-	for _, name := range []string{"json", "yaml", "file", "toml", "ini"} {
-		registerGroup(name)
+	// One dynamic group per registered source kind
+	for _, kind := range extract.Kinds() {
+		registerGroup(kind)
 	}
 
 	// Parse args
@@ -56,7 +239,135 @@ func ParseFlags(args []string, version, commit string) (Flags, error) {
 		return Flags{}, err
 	}
 	flags.Quote = quote.QuoteKind(*globalQuote)
+	flags.Shell = quote.Shell(*shell)
+	flags.Format = output.Format(*format)
+	flags.Order = output.Order(*order)
+	flags.Declared = declaredInstances(args)
 	flags.FlagSet = fs
 
+	// Non-POSIX dialects always export and use their own quoting.
+	if flags.Shell != quote.ShellPOSIX {
+		if _, ok := fs.OverriddenValues()["quote"]; ok {
+			return Flags{}, fmt.Errorf("--quote cannot be combined with --shell %s", flags.Shell)
+		}
+		if flags.Export {
+			return Flags{}, fmt.Errorf("--export cannot be combined with --shell %s", flags.Shell)
+		}
+	}
+
+	flags.File.Mode, _ = output.ParseMode(*outputMode) // validated above
+	if *outputOwner != "" {
+		owner, _ := output.ParseOwner(*outputOwner) // validated above
+		flags.File.Owner = &owner
+	}
+	for _, name := range []string{"output-mode", "output-owner"} {
+		if _, ok := fs.OverriddenValues()[name]; ok && flags.Output == "" {
+			return Flags{}, fmt.Errorf("--%s requires --output", name)
+		}
+	}
+
+	if *onChangeSignal != "" {
+		flags.Hook.Signal, _ = hook.ParseSignal(*onChangeSignal) // validated above
+	}
+	if (flags.Hook.Signal != 0) != (flags.Hook.PIDFile != "") {
+		return Flags{}, fmt.Errorf("--on-change-signal and --pid-file must be used together")
+	}
+	if !flags.Hook.IsZero() && flags.Output == "" {
+		return Flags{}, fmt.Errorf("--on-change-signal and --on-change-exec require --output")
+	}
+	if flags.Hook.Retries < 0 {
+		return Flags{}, fmt.Errorf("--on-change-retries must not be negative")
+	}
+	if flags.Merge {
+		switch {
+		case flags.Output == "":
+			return Flags{}, fmt.Errorf("--merge requires --output")
+		case !output.Mergeable(flags.Format):
+			return Flags{}, fmt.Errorf("--merge cannot be combined with --format %s", flags.Format)
+		}
+	}
+	if flags.Check {
+		switch {
+		case flags.Output == "":
+			return Flags{}, fmt.Errorf("--check requires --output")
+		case flags.Watch:
+			return Flags{}, fmt.Errorf("--check cannot be combined with --watch")
+		case !flags.Hook.IsZero():
+			return Flags{}, fmt.Errorf("--check cannot be combined with on-change hooks")
+		}
+	}
+	if flags.Watch && flags.Output == "" {
+		return Flags{}, fmt.Errorf("--watch requires --output")
+	}
+	if flags.Debounce <= 0 {
+		return Flags{}, fmt.Errorf("--watch-debounce must be positive")
+	}
+	if flags.Timeout < 0 {
+		return Flags{}, fmt.Errorf("--timeout must not be negative")
+	}
+	if flags.Parallel < 1 {
+		return Flags{}, fmt.Errorf("--parallel must be at least 1")
+	}
+
+	// Formats other than env escape values themselves.
+	if flags.Format.RawValues() {
+		if _, ok := fs.OverriddenValues()["quote"]; ok {
+			return Flags{}, fmt.Errorf("--quote cannot be combined with --format %s", flags.Format)
+		}
+		if flags.Export && !flags.Format.Exportable() {
+			return Flags{}, fmt.Errorf("--export cannot be combined with --format %s", flags.Format)
+		}
+		if flags.Shell != quote.ShellPOSIX {
+			return Flags{}, fmt.Errorf("--shell cannot be combined with --format %s", flags.Format)
+		}
+	}
+
 	return flags, nil
 }
+
+// declaredInstances returns the dynamic instances ("group.id") in the order
+// they first appear in args.
+func declaredInstances(args []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, ok := strings.CutPrefix(arg, "--")
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(name, "=")
+		group, rest, ok := strings.Cut(name, ".")
+		if !ok || !slices.Contains(extract.Kinds(), spec.Kind(group)) {
+			continue
+		}
+		i := strings.LastIndex(rest, ".")
+		if i <= 0 {
+			continue
+		}
+		if inst := group + "." + rest[:i]; !seen[inst] {
+			seen[inst] = true
+			out = append(out, inst)
+		}
+	}
+	return out
+}
+
+// choices converts typed string constants into flag choices.
+func choices[T ~string](values []T) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		out = append(out, string(v))
+	}
+	return out
+}
+
+// validHeader accepts "Name: value" request headers.
+func validHeader(s string) error {
+	if _, _, err := fetch.ParseHeader(s); err != nil {
+		return errors.New("want NAME: VALUE")
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package flag
 
 import (
+	"os"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/containeroo/tinyflags"
+	"github.com/gi8lino/unveil/internal/output"
 	"github.com/gi8lino/unveil/internal/quote"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -30,7 +34,7 @@ func TestParseFlags_Defaults_NoGroups(t *testing.T) {
 		require.NotNil(t, flags.FlagSet)
 		assert.Equal(t, quote.QuoteNone, flags.Quote)
 		// No groups instantiated
-		assert.Len(t, flags.FlagSet.DynamicGroups(), 5) // json, yaml, file, toml, ini are registered
+		assert.Len(t, flags.FlagSet.DynamicGroups(), 6) // json, yaml, file, toml, ini, vault are registered
 	})
 
 	t.Run("global quote double", func(t *testing.T) {
@@ -112,3 +116,436 @@ func TestParseFlags_Defaults_NoGroups(t *testing.T) {
 		assert.Equal(t, "API_KEY", as)
 	})
 }
+
+func TestParseFlags_Validation(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Unknown type rejected", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--json.a.path=./a.json", "--json.a.select=k", "--json.a.type=uuid"}
+		_, err := ParseFlags(args, "v", "c")
+		require.Error(t, err)
+	})
+
+	t.Run("Invalid regex rejected", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--json.a.path=./a.json", "--json.a.select=k", "--json.a.match=("}
+		_, err := ParseFlags(args, "v", "c")
+		require.Error(t, err)
+	})
+
+	t.Run("Invalid range rejected", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--json.a.path=./a.json", "--json.a.select=k", "--json.a.range=1-10"}
+		_, err := ParseFlags(args, "v", "c")
+		require.Error(t, err)
+	})
+
+	t.Run("Unknown decryption rejected", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--json.a.path=./a.json.gpg", "--json.a.select=k", "--json.a.decrypt=gpg"}
+		_, err := ParseFlags(args, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, `invalid value for flag --json.a.decrypt: "gpg" must be one of: age`)
+	})
+
+	t.Run("Invalid header rejected", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{"--json.a.path=https://config.internal/a.json", "--json.a.select=k", "--json.a.header=Authorization"}
+		_, err := ParseFlags(args, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, `invalid value for flag --json.a.header: invalid value "Authorization": want NAME: VALUE`)
+	})
+
+	for _, field := range []string{"decrypt=age", "identity=/k", "header=A: b", "ca-file=/ca.pem", "timeout=1s", "cache-dir=/c"} {
+		t.Run("Vault rejects "+field, func(t *testing.T) {
+			t.Parallel()
+
+			args := []string{"--vault.a.path=secret/data/app", "--vault.a.select=data.k", "--vault.a." + field}
+			_, err := ParseFlags(args, "v", "c")
+			require.Error(t, err)
+			assert.ErrorContains(t, err, "unknown dynamic field")
+		})
+	}
+
+	t.Run("Validation flags accepted", func(t *testing.T) {
+		t.Parallel()
+
+		args := []string{
+			"--json.a.path=./a.json",
+			"--json.a.select=k",
+			"--json.a.type=int",
+			"--json.a.range=1..10",
+			"--json.a.secret",
+		}
+		flags, err := ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+
+		g := getGroup(t, flags.FlagSet, "json")
+		assert.Equal(t, "int", tinyflags.GetOrDefaultDynamic[string](g, "a", "type"))
+		assert.Equal(t, "1..10", tinyflags.GetOrDefaultDynamic[string](g, "a", "range"))
+		assert.True(t, tinyflags.GetOrDefaultDynamic[bool](g, "a", "secret"))
+	})
+}
+
+func TestParseFlags_Shell(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Defaults to posix", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{}, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, quote.ShellPOSIX, flags.Shell)
+	})
+
+	t.Run("Dialect selected", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{"--shell", "fish"}, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, quote.ShellFish, flags.Shell)
+	})
+
+	t.Run("Unknown dialect rejected", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--shell", "ksh"}, "v", "c")
+		require.Error(t, err)
+	})
+
+	t.Run("Quote conflicts with dialect", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--shell", "pwsh", "--quote", "single"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--quote cannot be combined with --shell pwsh")
+	})
+
+	t.Run("Export conflicts with dialect", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--shell", "csh", "--export"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--export cannot be combined with --shell csh")
+	})
+
+	t.Run("Quote and export allowed for posix", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--shell", "posix", "--quote", "single", "--export"}, "v", "c")
+		require.NoError(t, err)
+	})
+}
+
+func TestParseFlags_Format(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Defaults to env", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{}, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, output.FormatEnv, flags.Format)
+	})
+
+	t.Run("Format selected", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{"--format", "systemd"}, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, output.FormatSystemd, flags.Format)
+	})
+
+	t.Run("Unknown format rejected", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--format", "xml"}, "v", "c")
+		require.Error(t, err)
+	})
+
+	t.Run("Quote conflicts with format", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--format", "docker-env", "--quote", "single"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--quote cannot be combined with --format docker-env")
+	})
+
+	t.Run("Export conflicts with format", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--format", "systemd", "--export"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--export cannot be combined with --format systemd")
+	})
+
+	t.Run("Export allowed for make", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{"--format", "make", "--export"}, "v", "c")
+		require.NoError(t, err)
+		assert.True(t, flags.Export)
+	})
+
+	t.Run("Shell conflicts with format", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--format", "systemd", "--shell", "fish"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--shell cannot be combined with --format systemd")
+	})
+}
+
+func TestParseFlags_Watch(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Requires output", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--watch"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--watch requires --output")
+	})
+
+	t.Run("Defaults", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{"--watch", "--output", "out.env"}, "v", "c")
+		require.NoError(t, err)
+		assert.True(t, flags.Watch)
+		assert.Equal(t, 250*time.Millisecond, flags.Debounce)
+	})
+
+	t.Run("Debounce must be positive", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--watch-debounce", "0s"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--watch-debounce must be positive")
+	})
+}
+
+func TestParseFlags_Timeout(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Defaults to no timeout", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags(nil, "v", "c")
+		require.NoError(t, err)
+		assert.Zero(t, flags.Timeout)
+	})
+
+	t.Run("Parsed", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{"--timeout", "2s"}, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, 2*time.Second, flags.Timeout)
+	})
+
+	t.Run("Negative rejected", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--timeout=-1s"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--timeout must not be negative")
+	})
+}
+
+func TestParseFlags_Parallel(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Defaults to serial", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags(nil, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, 1, flags.Parallel)
+	})
+
+	t.Run("Parsed", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{"--parallel", "8"}, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, 8, flags.Parallel)
+	})
+
+	t.Run("Zero rejected", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--parallel", "0"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--parallel must be at least 1")
+	})
+}
+
+func TestParseFlags_Hook(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Signal and pid file", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{"--output", "o.env", "--on-change-signal", "SIGHUP", "--pid-file", "app.pid"}, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, syscall.SIGHUP, flags.Hook.Signal)
+		assert.Equal(t, "app.pid", flags.Hook.PIDFile)
+		assert.Equal(t, 3, flags.Hook.Retries)
+		assert.Equal(t, time.Second, flags.Hook.Backoff)
+	})
+
+	t.Run("Signal requires pid file", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--output", "o.env", "--on-change-signal", "HUP"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--on-change-signal and --pid-file must be used together")
+	})
+
+	t.Run("Unsupported signal", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--on-change-signal", "KILL"}, "v", "c")
+		require.Error(t, err)
+	})
+
+	t.Run("Exec requires output", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--on-change-exec", "true"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--on-change-signal and --on-change-exec require --output")
+	})
+
+	t.Run("Negative retries", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--on-change-retries=-1"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--on-change-retries must not be negative")
+	})
+}
+
+func TestParseFlags_Check(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Requires output", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--check"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--check requires --output")
+	})
+
+	t.Run("Conflicts with watch", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--check", "--output", "o.env", "--watch"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--check cannot be combined with --watch")
+	})
+
+	t.Run("Conflicts with hooks", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--check", "--output", "o.env", "--on-change-exec", "true"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--check cannot be combined with on-change hooks")
+	})
+}
+
+func TestParseFlags_Merge(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Requires output", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--merge"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--merge requires --output")
+	})
+
+	t.Run("Rejects tfvars-json", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--merge", "--output", "o.json", "--format", "tfvars-json"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--merge cannot be combined with --format tfvars-json")
+	})
+
+	t.Run("Allowed with yaml", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{"--merge", "--output", "o.yaml", "--format", "yaml"}, "v", "c")
+		require.NoError(t, err)
+		assert.True(t, flags.Merge)
+	})
+
+	t.Run("Allowed with check", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{"--merge", "--check", "--output", "o.env"}, "v", "c")
+		require.NoError(t, err)
+		assert.True(t, flags.Merge)
+	})
+}
+
+func TestParseFlags_OutputFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Defaults", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{"--output", "o.env"}, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, output.DefaultMode, flags.File.Mode)
+		assert.Nil(t, flags.File.Owner)
+		assert.False(t, flags.AllowInsecure)
+	})
+
+	t.Run("Mode and owner", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{"--output", "o.env", "--output-mode", "0640", "--output-owner", "1000:2000"}, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o640), flags.File.Mode)
+		assert.Equal(t, &output.Owner{UID: 1000, GID: 2000}, flags.File.Owner)
+	})
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--output", "o.env", "--output-mode", "0980"}, "v", "c")
+		require.Error(t, err)
+	})
+
+	t.Run("Requires output", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--output-owner", "1000"}, "v", "c")
+		require.Error(t, err)
+		assert.EqualError(t, err, "--output-owner requires --output")
+	})
+}
+
+func TestParseFlags_Explain(t *testing.T) {
+	t.Parallel()
+
+	for _, arg := range []string{"-v", "--explain"} {
+		t.Run(arg, func(t *testing.T) {
+			t.Parallel()
+			flags, err := ParseFlags([]string{arg}, "v", "c")
+			require.NoError(t, err)
+			assert.True(t, flags.Explain)
+		})
+	}
+}
+
+func TestParseFlags_Order(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Defaults to sorted", func(t *testing.T) {
+		t.Parallel()
+		flags, err := ParseFlags([]string{}, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, output.OrderSorted, flags.Order)
+	})
+
+	t.Run("Declared instances in command-line order", func(t *testing.T) {
+		t.Parallel()
+		args := []string{
+			"--order", "declared",
+			"--yaml.b.path", "/b.yaml",
+			"--json.a.path=/a.json",
+			"--yaml.b.select=x",
+			"--json.a.select", "y",
+			"--file.c.path=/c.env", "--file.c.select=Z",
+		}
+		flags, err := ParseFlags(args, "v", "c")
+		require.NoError(t, err)
+		assert.Equal(t, output.OrderDeclared, flags.Order)
+		assert.Equal(t, []string{"yaml.b", "json.a", "file.c"}, flags.Declared)
+	})
+
+	t.Run("Unknown order rejected", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseFlags([]string{"--order", "random"}, "v", "c")
+		require.Error(t, err)
+	})
+}
+
+func TestDeclaredInstances(t *testing.T) {
+	t.Parallel()
+
+	got := declaredInstances([]string{"--quote=single", "--xml.a.path=x", "--toml.t.path", "--json.a", "--ini.i.select=k", "--", "--json.z.path=p"})
+	assert.Equal(t, []string{"toml.t", "ini.i"}, got)
+}
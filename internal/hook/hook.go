@@ -0,0 +1,122 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxBackoff caps the delay between retries.
+const maxBackoff = 30 * time.Second
+
+// signals maps the accepted signal names to signals.
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// SignalNames lists the accepted signal names, sorted.
+func SignalNames() []string {
+	names := make([]string, 0, len(signals))
+	for n := range signals {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseSignal parses a signal name like "HUP" or "SIGHUP" (case-insensitive).
+func ParseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported signal %q (allowed: %s)", name, strings.Join(SignalNames(), ", "))
+	}
+	return sig, nil
+}
+
+// Hook notifies another process that the output file changed.
+type Hook struct {
+	Signal  syscall.Signal // signal sent to the process in PIDFile; 0 disables
+	PIDFile string         // file holding the PID to signal
+	Exec    string         // shell command run with "sh -c"; empty disables
+	Retries int            // additional attempts after a failure
+	Backoff time.Duration  // delay before the first retry, doubled for each further one
+	Stderr  io.Writer      // receives the output of Exec; nil discards it
+}
+
+// IsZero reports whether h does nothing.
+func (h Hook) IsZero() bool {
+	return h.Signal == 0 && h.Exec == ""
+}
+
+// Fire sends the signal and runs the command, retrying failed attempts with
+// exponential backoff. It gives up early when ctx is canceled.
+func (h Hook) Fire(ctx context.Context) error {
+	delay := h.Backoff
+	for attempt := 0; ; attempt++ {
+		err := h.fire(ctx)
+		if err == nil || attempt >= h.Retries {
+			return err
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		delay = min(2*delay, maxBackoff)
+	}
+}
+
+// fire makes a single attempt.
+func (h Hook) fire(ctx context.Context) error {
+	if h.Signal != 0 {
+		// Re-read the PID on every attempt; the process may have restarted.
+		pid, err := readPID(h.PIDFile)
+		if err != nil {
+			return err
+		}
+		proc, err := os.FindProcess(pid)
+		if err != nil {
+			return fmt.Errorf("finding process %d: %w", pid, err)
+		}
+		if err := proc.Signal(h.Signal); err != nil {
+			return fmt.Errorf("sending %s to process %d: %w", h.Signal, pid, err)
+		}
+	}
+	if h.Exec != "" {
+		cmd := exec.CommandContext(ctx, "sh", "-c", h.Exec)
+		cmd.Stdout = h.Stderr
+		cmd.Stderr = h.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("running %q: %w", h.Exec, err)
+		}
+	}
+	return nil
+}
+
+// readPID reads a positive process ID from path.
+func readPID(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("reading pid file: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("pid file %q does not contain a valid pid", path)
+	}
+	return pid, nil
+}
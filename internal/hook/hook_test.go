@@ -0,0 +1,132 @@
+package hook
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSignal(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   string
+		want syscall.Signal
+	}{
+		{in: "HUP", want: syscall.SIGHUP},
+		{in: "SIGHUP", want: syscall.SIGHUP},
+		{in: "usr1", want: syscall.SIGUSR1},
+		{in: "sigterm", want: syscall.SIGTERM},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseSignal(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("Unsupported", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseSignal("KILL")
+		require.Error(t, err)
+		assert.EqualError(t, err, `unsupported signal "KILL" (allowed: HUP, INT, QUIT, TERM, USR1, USR2)`)
+	})
+}
+
+func TestHook_Fire(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Exec", func(t *testing.T) {
+		t.Parallel()
+		marker := filepath.Join(t.TempDir(), "marker")
+		var out strings.Builder
+		h := Hook{Exec: "echo reloaded; echo fired > " + marker, Stderr: &out}
+
+		require.NoError(t, h.Fire(context.Background()))
+		got, err := os.ReadFile(marker)
+		require.NoError(t, err)
+		assert.Equal(t, "fired\n", string(got))
+		assert.Equal(t, "reloaded\n", out.String())
+	})
+
+	t.Run("Exec failure is retried with backoff", func(t *testing.T) {
+		t.Parallel()
+		marker := filepath.Join(t.TempDir(), "marker")
+		h := Hook{Exec: "echo x >> " + marker + "; exit 3", Retries: 2, Backoff: 20 * time.Millisecond}
+
+		start := time.Now()
+		err := h.Fire(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exit status 3")
+		assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond) // 20ms + 40ms
+
+		got, err := os.ReadFile(marker)
+		require.NoError(t, err)
+		assert.Equal(t, "x\nx\nx\n", string(got))
+	})
+
+	t.Run("Retry succeeds", func(t *testing.T) {
+		t.Parallel()
+		marker := filepath.Join(t.TempDir(), "marker")
+		// fails on the first attempt only
+		h := Hook{Exec: "test -e " + marker + " || { touch " + marker + "; exit 1; }", Retries: 1, Backoff: time.Millisecond}
+		require.NoError(t, h.Fire(context.Background()))
+	})
+
+	t.Run("Canceled context stops retries", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		h := Hook{Exec: "exit 1", Retries: 5, Backoff: time.Hour}
+		require.Error(t, h.Fire(ctx))
+	})
+
+	t.Run("Signal", func(t *testing.T) {
+		t.Parallel()
+		received := make(chan os.Signal, 1)
+		signal.Notify(received, syscall.SIGUSR1)
+		defer signal.Stop(received)
+
+		pidFile := filepath.Join(t.TempDir(), "app.pid")
+		require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o666))
+
+		h := Hook{Signal: syscall.SIGUSR1, PIDFile: pidFile}
+		require.NoError(t, h.Fire(context.Background()))
+		select {
+		case sig := <-received:
+			assert.Equal(t, syscall.SIGUSR1, sig)
+		case <-time.After(5 * time.Second):
+			t.Fatal("signal not received")
+		}
+	})
+
+	t.Run("Invalid pid file", func(t *testing.T) {
+		t.Parallel()
+		pidFile := filepath.Join(t.TempDir(), "app.pid")
+		require.NoError(t, os.WriteFile(pidFile, []byte("nope"), 0o666))
+
+		h := Hook{Signal: syscall.SIGHUP, PIDFile: pidFile}
+		err := h.Fire(context.Background())
+		require.Error(t, err)
+		assert.EqualError(t, err, `pid file "`+pidFile+`" does not contain a valid pid`)
+	})
+
+	t.Run("Missing pid file", func(t *testing.T) {
+		t.Parallel()
+		h := Hook{Signal: syscall.SIGHUP, PIDFile: filepath.Join(t.TempDir(), "missing.pid")}
+		err := h.Fire(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "reading pid file")
+	})
+}
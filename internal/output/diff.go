@@ -0,0 +1,181 @@
+package output
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/gi8lino/unveil/internal/quote"
+	"github.com/gi8lino/unveil/internal/spec"
+)
+
+// ChangeKind classifies a difference between two outputs.
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "+" // key only in the new output
+	Removed ChangeKind = "-" // key only in the existing output
+	Changed ChangeKind = "~" // key in both, with different assignments
+)
+
+// Change is the difference for one key. Old and New hold the complete
+// assignments as written in the file.
+type Change struct {
+	Kind ChangeKind
+	Key  string
+	Old  string
+	New  string
+}
+
+// Diff compares the existing content of an output file with what vars render
+// to. It reports whether the content differs at all and the per-key changes,
+// sorted by key. Content can differ without any key changing, e.g. when only
+// comments or the order of lines differ. With opts.Merge, the content is
+// compared with the merge result and other keys are never reported as removed.
+func Diff(existing []byte, vars []spec.Var, opts Options) ([]Change, bool, error) {
+	render := Render
+	if opts.Merge {
+		render = func(vars []spec.Var, opts Options) ([]byte, error) { return Merge(existing, vars, opts) }
+	}
+	rendered, err := render(vars, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	if bytes.Equal(existing, rendered) {
+		return nil, false, nil
+	}
+
+	fm, err := formatterFor(opts.Format)
+	if err != nil {
+		return nil, false, err
+	}
+	want, err := entries(fm, prepare(vars, opts), opts)
+	if err != nil {
+		return nil, false, err
+	}
+	have, err := fm.Entries(existing, opts)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var changes []Change
+	for k, n := range want {
+		o, ok := have[k]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: Added, Key: k, New: n})
+		case o != n:
+			changes = append(changes, Change{Kind: Changed, Key: k, Old: o, New: n})
+		}
+	}
+	for k, o := range have {
+		if _, ok := want[k]; !ok && !opts.Merge {
+			changes = append(changes, Change{Kind: Removed, Key: k, Old: o})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes, true, nil
+}
+
+// entries renders every variable on its own.
+func entries(fm Formatter, vars []spec.Var, opts Options) (map[string]string, error) {
+	out := make(map[string]string, len(vars))
+	for _, v := range vars {
+		entry, err := fm.Entry(v, opts)
+		if err != nil {
+			return nil, err
+		}
+		out[v.Name] = entry
+	}
+	return out, nil
+}
+
+// segment is an assignment, or a line that is not one (key is empty).
+type segment struct {
+	key  string
+	text string // without the final newline
+}
+
+// split splits a line-based output file into segments. A line starting like
+// an assignment begins a new entry, which continues while f.incomplete reports
+// true.
+func (f lineFormat) split(data []byte, opts Options) ([]segment, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	start, err := f.start(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var segs []segment
+	open := false // last segment is an unfinished assignment
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if open {
+			last := &segs[len(segs)-1]
+			last.text += "\n" + line
+			open = f.incomplete(last.text, opts)
+			continue
+		}
+		if m := start.FindStringSubmatch(line); m != nil {
+			segs = append(segs, segment{key: m[1], text: line})
+			open = f.incomplete(line, opts)
+			continue
+		}
+		segs = append(segs, segment{text: line})
+	}
+	return segs, nil
+}
+
+// shellIncomplete reports whether an assignment of opts.Shell, as far as
+// read, ends inside a quoted string or with a line continuation.
+func shellIncomplete(text string, opts Options) bool {
+	switch opts.Shell {
+	case quote.ShellCmd:
+		return false
+	case quote.ShellFish:
+		return unterminated(text, true, true)
+	default:
+		return unterminated(text, true, false)
+	}
+}
+
+// systemdIncomplete is shellIncomplete for systemd, where '#' only starts a
+// comment at the beginning of a line.
+func systemdIncomplete(text string, _ Options) bool {
+	return unterminated(text, false, false)
+}
+
+// makeIncomplete reports whether text ends with an unescaped backslash.
+func makeIncomplete(text string, _ Options) bool {
+	n := len(text) - len(strings.TrimRight(text, `\`))
+	return n%2 == 1
+}
+
+// unterminated reports whether text ends inside a quoted string or with a
+// line continuation. With comments, " #" outside quotes starts a comment;
+// with fishEscapes, backslashes also escape inside single quotes.
+func unterminated(text string, comments, fishEscapes bool) bool {
+	var q byte // open quote character
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && (q == 0 || q == '"' || (q == '\'' && fishEscapes)):
+			if i+1 == len(text) {
+				return true // line continuation
+			}
+			i++
+		case q == 0 && (c == '\'' || c == '"'):
+			q = c
+		case q != 0 && c == q:
+			q = 0
+		case q == 0 && comments && c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			if nl := strings.IndexByte(text[i:], '\n'); nl >= 0 {
+				i += nl
+			} else {
+				i = len(text)
+			}
+		}
+	}
+	return q != 0
+}
@@ -0,0 +1,115 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/gi8lino/unveil/internal/quote"
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	kv := []spec.Var{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}, {Name: "C", Value: "3"}}
+
+	t.Run("Up to date", func(t *testing.T) {
+		t.Parallel()
+		changes, differs, err := Diff([]byte("A=1\nB=2\nC=3\n"), kv, Options{})
+		require.NoError(t, err)
+		assert.False(t, differs)
+		assert.Empty(t, changes)
+	})
+
+	t.Run("Added, removed and changed", func(t *testing.T) {
+		t.Parallel()
+		changes, differs, err := Diff([]byte("A=1\nB=old\nD=4\n"), kv, Options{})
+		require.NoError(t, err)
+		assert.True(t, differs)
+		assert.Equal(t, []Change{
+			{Kind: Changed, Key: "B", Old: "B=old", New: "B=2"},
+			{Kind: Added, Key: "C", New: "C=3"},
+			{Kind: Removed, Key: "D", Old: "D=4"},
+		}, changes)
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		t.Parallel()
+		changes, differs, err := Diff(nil, []spec.Var{{Name: "A", Value: "1"}}, Options{})
+		require.NoError(t, err)
+		assert.True(t, differs)
+		assert.Equal(t, []Change{{Kind: Added, Key: "A", New: "A=1"}}, changes)
+	})
+
+	t.Run("Formatting only", func(t *testing.T) {
+		t.Parallel()
+		changes, differs, err := Diff([]byte("# generated\nC=3\nA=1\nB=2\n"), kv, Options{})
+		require.NoError(t, err)
+		assert.True(t, differs)
+		assert.Empty(t, changes)
+	})
+
+	t.Run("Export prefix counts as a change", func(t *testing.T) {
+		t.Parallel()
+		changes, _, err := Diff([]byte("A=1\n"), []spec.Var{{Name: "A", Value: "1"}}, Options{Export: true})
+		require.NoError(t, err)
+		assert.Equal(t, []Change{{Kind: Changed, Key: "A", Old: "A=1", New: "export A=1"}}, changes)
+	})
+
+	t.Run("Multi-line values", func(t *testing.T) {
+		t.Parallel()
+		existing := []byte("A=\"x\ny=z\"\nB=\"2\"\n")
+		changes, differs, err := Diff(existing, []spec.Var{{Name: "A", Value: "x\ny=w"}, {Name: "B", Value: "2"}}, Options{Format: FormatSystemd})
+		require.NoError(t, err)
+		assert.True(t, differs)
+		assert.Equal(t, []Change{{Kind: Changed, Key: "A", Old: "A=\"x\ny=z\"", New: "A=\"x\ny=w\""}}, changes)
+	})
+
+	t.Run("Shell dialect", func(t *testing.T) {
+		t.Parallel()
+		changes, _, err := Diff([]byte("set -gx A '0'\n"), []spec.Var{{Name: "A", Value: "'1'"}}, Options{Shell: quote.ShellFish})
+		require.NoError(t, err)
+		assert.Equal(t, []Change{{Kind: Changed, Key: "A", Old: "set -gx A '0'", New: "set -gx A '1'"}}, changes)
+	})
+
+	t.Run("Make and tfvars", func(t *testing.T) {
+		t.Parallel()
+		changes, _, err := Diff([]byte("export A := 0\n"), []spec.Var{{Name: "A", Value: "1"}}, Options{Format: FormatMake, Export: true})
+		require.NoError(t, err)
+		assert.Equal(t, []Change{{Kind: Changed, Key: "A", Old: "export A := 0", New: "export A := 1"}}, changes)
+
+		changes, _, err = Diff([]byte("A = \"0\"\n"), []spec.Var{{Name: "A", Value: "1"}}, Options{Format: FormatTFVars})
+		require.NoError(t, err)
+		assert.Equal(t, []Change{{Kind: Changed, Key: "A", Old: `A = "0"`, New: `A = "1"`}}, changes)
+	})
+
+	t.Run("tfvars-json", func(t *testing.T) {
+		t.Parallel()
+		existing := []byte(`{"A": "1", "B": "old", "D": 4}`)
+		changes, differs, err := Diff(existing, []spec.Var{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}}, Options{Format: FormatTFVarsJSON})
+		require.NoError(t, err)
+		assert.True(t, differs)
+		assert.Equal(t, []Change{
+			{Kind: Changed, Key: "B", Old: `"old"`, New: `"2"`},
+			{Kind: Removed, Key: "D", Old: "4"},
+		}, changes)
+	})
+
+	t.Run("Merge ignores other keys", func(t *testing.T) {
+		t.Parallel()
+		existing := []byte("# keep\nX=1\nA=1\n")
+		changes, differs, err := Diff(existing, []spec.Var{{Name: "A", Value: "1"}}, Options{Merge: true})
+		require.NoError(t, err)
+		assert.False(t, differs)
+		assert.Empty(t, changes)
+
+		changes, differs, err = Diff(existing, []spec.Var{{Name: "A", Value: "2"}, {Name: "B", Value: "3"}}, Options{Merge: true})
+		require.NoError(t, err)
+		assert.True(t, differs)
+		assert.Equal(t, []Change{
+			{Kind: Changed, Key: "A", Old: "A=1", New: "A=2"},
+			{Kind: Added, Key: "B", New: "B=3"},
+		}, changes)
+	})
+}
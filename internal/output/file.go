@@ -0,0 +1,139 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultMode is the permission of written files unless FileOptions.Mode is set.
+const DefaultMode os.FileMode = 0o600
+
+// ErrInsecureDir is returned when FileOptions.Private refuses a directory.
+var ErrInsecureDir = errors.New("refusing to write secrets into world-readable directory")
+
+// FileOptions controls the file written by WriteFileAtomic.
+type FileOptions struct {
+	Mode    os.FileMode // permissions; zero means DefaultMode
+	Owner   *Owner      // owner to set; nil keeps the current user
+	Private bool        // refuse world-readable directories
+}
+
+// Owner is a file owner; -1 leaves the user or group unchanged.
+type Owner struct {
+	UID int
+	GID int
+}
+
+// ParseMode parses an octal permission like "0640".
+func ParseMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, fmt.Errorf("invalid file mode %q: expected octal permissions like 0640", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// ParseOwner parses "user:group", "user" or ":group", where each part is a
+// numeric id or a name.
+func ParseOwner(s string) (Owner, error) {
+	usr, grp, _ := strings.Cut(s, ":")
+	if usr == "" && grp == "" {
+		return Owner{}, fmt.Errorf("invalid owner %q: expected uid:gid", s)
+	}
+
+	owner := Owner{UID: -1, GID: -1}
+	if usr != "" {
+		uid, err := lookupID(usr, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return Owner{}, fmt.Errorf("invalid owner %q: %w", s, err)
+		}
+		owner.UID = uid
+	}
+	if grp != "" {
+		gid, err := lookupID(grp, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return Owner{}, fmt.Errorf("invalid owner %q: %w", s, err)
+		}
+		owner.GID = gid
+	}
+	return owner, nil
+}
+
+// lookupID returns s as a numeric id, resolving names with lookup.
+func lookupID(s string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(s); err == nil {
+		if id < 0 {
+			return 0, fmt.Errorf("id %d must not be negative", id)
+		}
+		return id, nil
+	}
+	id, err := lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// checkDir returns ErrInsecureDir if opts.Private is set and dir is
+// world-readable.
+func checkDir(dir string, opts FileOptions) error {
+	if !opts.Private {
+		return nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("checking output directory %q: %w", dir, err)
+	}
+	if info.Mode().Perm()&0o004 != 0 {
+		return fmt.Errorf("%w %q", ErrInsecureDir, dir)
+	}
+	return nil
+}
+
+// refresh checks the directory of the existing file at path and gives the
+// file the mode and owner of opts without rewriting it.
+func (opts FileOptions) refresh(path string) error {
+	if err := checkDir(filepath.Dir(path), opts); err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening %q: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+	return opts.apply(f)
+}
+
+// apply sets the permissions and owner of f.
+func (opts FileOptions) apply(f *os.File) error {
+	if opts.Owner != nil {
+		if err := f.Chown(opts.Owner.UID, opts.Owner.GID); err != nil {
+			return fmt.Errorf("changing owner of %q: %w", f.Name(), err)
+		}
+	}
+	mode := opts.Mode
+	if mode == 0 {
+		mode = DefaultMode
+	}
+	if err := f.Chmod(mode); err != nil {
+		return fmt.Errorf("changing mode of %q: %w", f.Name(), err)
+	}
+	return nil
+}
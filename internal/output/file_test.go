@@ -0,0 +1,153 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMode(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Octal", func(t *testing.T) {
+		t.Parallel()
+		mode, err := ParseMode("0640")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o640), mode)
+	})
+
+	t.Run("Without leading zero", func(t *testing.T) {
+		t.Parallel()
+		mode, err := ParseMode("600")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), mode)
+	})
+
+	for _, in := range []string{"", "rw-r-----", "0980", "01777"} {
+		t.Run("Invalid "+in, func(t *testing.T) {
+			t.Parallel()
+			_, err := ParseMode(in)
+			require.Error(t, err)
+			assert.EqualError(t, err, `invalid file mode "`+in+`": expected octal permissions like 0640`)
+		})
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		want    Owner
+		wantErr string
+	}{
+		{in: "1000:2000", want: Owner{UID: 1000, GID: 2000}},
+		{in: "1000", want: Owner{UID: 1000, GID: -1}},
+		{in: ":2000", want: Owner{UID: -1, GID: 2000}},
+		{in: "root:root", want: Owner{UID: 0, GID: 0}},
+		{in: ":", wantErr: `invalid owner ":": expected uid:gid`},
+		{in: "-1:0", wantErr: `invalid owner "-1:0": id -1 must not be negative`},
+		{in: "no-such-user-unveil", wantErr: `invalid owner "no-such-user-unveil": user: unknown user no-such-user-unveil`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseOwner(tt.in)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWriteFileAtomic_FileOptions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Default mode", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "out.env")
+		require.NoError(t, os.WriteFile(path, []byte("OLD=1\n"), 0o666))
+
+		require.NoError(t, WriteFileAtomic(path, []byte("A=1\n"), FileOptions{}))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, DefaultMode, info.Mode().Perm())
+	})
+
+	t.Run("Mode is not masked", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "out.env")
+
+		require.NoError(t, WriteFileAtomic(path, []byte("A=1\n"), FileOptions{Mode: 0o664}))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o664), info.Mode().Perm())
+	})
+
+	t.Run("Owner", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "out.env")
+		owner := &Owner{UID: os.Getuid(), GID: os.Getgid()}
+
+		require.NoError(t, WriteFileAtomic(path, []byte("A=1\n"), FileOptions{Owner: owner}))
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "A=1\n", string(got))
+	})
+
+	t.Run("Private refuses world-readable directory", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		require.NoError(t, os.Chmod(dir, 0o755))
+		path := filepath.Join(dir, "out.env")
+
+		err := WriteFileAtomic(path, []byte("A=1\n"), FileOptions{Private: true})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrInsecureDir)
+		assert.EqualError(t, err, `refusing to write secrets into world-readable directory "`+dir+`"`)
+		_, statErr := os.Stat(path)
+		assert.ErrorIs(t, statErr, os.ErrNotExist)
+	})
+
+	t.Run("Private allows restricted directory", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		require.NoError(t, os.Chmod(dir, 0o750))
+
+		require.NoError(t, WriteFileAtomic(filepath.Join(dir, "out.env"), []byte("A=1\n"), FileOptions{Private: true}))
+	})
+
+	t.Run("Private creates restricted directories", func(t *testing.T) {
+		t.Parallel()
+		parent := t.TempDir()
+		require.NoError(t, os.Chmod(parent, 0o750))
+		dir := filepath.Join(parent, "a", "b")
+
+		require.NoError(t, WriteFileAtomic(filepath.Join(dir, "out.env"), []byte("A=1\n"), FileOptions{Private: true}))
+		for _, d := range []string{filepath.Join(parent, "a"), dir} {
+			info, err := os.Stat(d)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+		}
+	})
+
+	t.Run("Creates missing directories", func(t *testing.T) {
+		t.Parallel()
+		dir := filepath.Join(t.TempDir(), "a")
+
+		path := filepath.Join(dir, "out.env")
+
+		require.NoError(t, WriteFileAtomic(path, []byte("A=1\n"), FileOptions{}))
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "A=1\n", string(got))
+	})
+}
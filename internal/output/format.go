@@ -0,0 +1,256 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gi8lino/unveil/internal/quote"
+	"github.com/gi8lino/unveil/internal/spec"
+)
+
+// Format is the syntax of the written file.
+type Format string
+
+const (
+	FormatEnv        Format = "env"         // shell assignments, see quote.Shell
+	FormatDockerEnv  Format = "docker-env"  // docker run --env-file
+	FormatSystemd    Format = "systemd"     // systemd EnvironmentFile=
+	FormatMake       Format = "make"        // Makefile include
+	FormatTFVars     Format = "tfvars"      // Terraform .tfvars
+	FormatTFVarsJSON Format = "tfvars-json" // Terraform .tfvars.json
+	FormatJSON       Format = "json"        // JSON object of strings
+	FormatYAML       Format = "yaml"        // YAML mapping of strings
+)
+
+// RawValues reports whether f escapes values itself and therefore expects
+// them unquoted.
+func (f Format) RawValues() bool {
+	return f != "" && f != FormatEnv
+}
+
+// Exportable reports whether f can mark variables for export.
+func (f Format) Exportable() bool {
+	return f == "" || f == FormatEnv || f == FormatMake
+}
+
+// dockerLine renders v for docker run --env-file. Docker takes everything
+// after the first '=' verbatim up to the end of the line; there is no quoting
+// or escaping.
+func dockerLine(v spec.Var, _ Options) (string, error) {
+	if strings.ContainsAny(v.Value, "\r\n") {
+		return "", fmt.Errorf("%s: docker-env cannot represent values containing newlines", v.Name)
+	}
+	if !utf8.ValidString(v.Value) {
+		return "", fmt.Errorf("%s: docker-env requires valid UTF-8", v.Name)
+	}
+	return v.Name + "=" + v.Value, nil
+}
+
+// systemdLine renders v for an EnvironmentFile=. systemd unescapes \" \\ \`
+// \$ inside double quotes and keeps newlines, which matches POSIX double
+// quoting.
+func systemdLine(v spec.Var, _ Options) (string, error) {
+	if !utf8.ValidString(v.Value) {
+		return "", fmt.Errorf("%s: systemd requires valid UTF-8", v.Name)
+	}
+	return v.Name + "=" + quote.QuoteValue(v.Value, quote.QuoteDouble), nil
+}
+
+// makeLine renders v as a make ":=" assignment.
+func makeLine(v spec.Var, opts Options) (string, error) {
+	if strings.ContainsAny(v.Value, "\r\n") {
+		return "", fmt.Errorf("%s: make cannot represent values containing newlines", v.Name)
+	}
+	line := v.Name + " := " + makeValue(v.Value)
+	if opts.Export {
+		line = "export " + line
+	}
+	return line, nil
+}
+
+// tfvarsLine renders v as a Terraform variable assignment.
+func tfvarsLine(v spec.Var, _ Options) (string, error) {
+	if !utf8.ValidString(v.Value) {
+		return "", fmt.Errorf("%s: tfvars requires valid UTF-8", v.Name)
+	}
+	return v.Name + " = " + hclString(v.Value), nil
+}
+
+// yamlLine renders v as a YAML mapping entry with a double-quoted value.
+// Names YAML 1.1 reads as booleans or null are quoted too.
+func yamlLine(v spec.Var, _ Options) (string, error) {
+	if !utf8.ValidString(v.Value) {
+		return "", fmt.Errorf("%s: yaml requires valid UTF-8", v.Name)
+	}
+	key := v.Name
+	if yamlReserved[strings.ToLower(key)] {
+		key = yamlString(key)
+	}
+	return key + ": " + yamlString(v.Value), nil
+}
+
+// yamlString renders v as a YAML double-quoted scalar. Characters YAML does
+// not allow or would fold as line breaks are escaped.
+func yamlString(v string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range v {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			switch {
+			case unicode.IsPrint(r):
+				b.WriteRune(r)
+			case r < 0x10000:
+				fmt.Fprintf(&b, `\u%04x`, r)
+			default:
+				fmt.Fprintf(&b, `\U%08x`, r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// yamlReserved are the plain scalars YAML parsers do not read as strings.
+var yamlReserved = map[string]bool{
+	"y": true, "n": true, "yes": true, "no": true, "on": true, "off": true,
+	"true": true, "false": true, "null": true,
+}
+
+// shellLine renders a single assignment in the syntax of opts.Shell.
+func shellLine(v spec.Var, opts Options) (string, error) {
+	key, value := v.Name, v.Value
+	switch opts.Shell {
+	case "", quote.ShellPOSIX:
+		if opts.Export {
+			return "export " + key + "=" + value, nil
+		}
+		return key + "=" + value, nil
+	case quote.ShellFish:
+		return "set -gx " + key + " " + value, nil
+	case quote.ShellPwsh:
+		return "$env:" + key + " = " + value, nil
+	case quote.ShellCsh:
+		return "setenv " + key + " " + value, nil
+	case quote.ShellCmd:
+		if strings.ContainsAny(value, "\r\n") {
+			return "", fmt.Errorf("%s: cmd cannot represent values containing newlines", key)
+		}
+		return "set " + key + "=" + value, nil
+	default:
+		return "", fmt.Errorf("unsupported shell %q", opts.Shell)
+	}
+}
+
+// shellPattern matches the start of an assignment of opts.Shell.
+func shellPattern(opts Options) (*regexp.Regexp, error) {
+	switch opts.Shell {
+	case "", quote.ShellPOSIX:
+		return regexp.MustCompile(`^(?:export )?([^=\s]+)=`), nil
+	case quote.ShellFish:
+		return regexp.MustCompile(`^set -gx (\S+) `), nil
+	case quote.ShellPwsh:
+		return regexp.MustCompile(`^\$env:(\S+) = `), nil
+	case quote.ShellCsh:
+		return regexp.MustCompile(`^setenv (\S+) `), nil
+	case quote.ShellCmd:
+		return regexp.MustCompile(`^set ([^=]+)=`), nil
+	default:
+		return nil, fmt.Errorf("unsupported shell %q", opts.Shell)
+	}
+}
+
+// makeValue escapes v for the right-hand side of a make ":=" assignment.
+// '$' is doubled and '#' is backslash-escaped, doubling any backslashes in
+// front of it. "$()" expands to nothing and protects leading whitespace, which
+// make strips, and trailing backslashes, which would continue the line.
+func makeValue(v string) string {
+	var b strings.Builder
+	if strings.HasPrefix(v, " ") || strings.HasPrefix(v, "\t") {
+		b.WriteString("$()")
+	}
+	backslashes := 0
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch c {
+		case '\\':
+			backslashes++
+			continue
+		case '#':
+			b.WriteString(strings.Repeat(`\`, 2*backslashes+1))
+		case '$':
+			b.WriteString(strings.Repeat(`\`, backslashes))
+			b.WriteByte('$')
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		b.WriteByte(c)
+	}
+	b.WriteString(strings.Repeat(`\`, backslashes))
+	if backslashes > 0 || strings.HasSuffix(v, " ") || strings.HasSuffix(v, "\t") {
+		b.WriteString("$()")
+	}
+	return b.String()
+}
+
+// hclString renders v as an HCL quoted string literal. Template introducers
+// are escaped as "$${" and "%%{" so Terraform does not interpolate them.
+func hclString(v string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i, r := range v {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '$', '%':
+			b.WriteRune(r)
+			if strings.HasPrefix(v[i+1:], "{") {
+				b.WriteRune(r)
+			}
+		default:
+			switch {
+			case unicode.IsPrint(r):
+				b.WriteRune(r)
+			case r < 0x10000:
+				fmt.Fprintf(&b, `\u%04x`, r)
+			default:
+				fmt.Fprintf(&b, `\U%08x`, r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// jsonString encodes s as a JSON string without HTML escaping.
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s) // strings always encode
+	return strings.TrimSuffix(buf.String(), "\n")
+}
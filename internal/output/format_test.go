@@ -0,0 +1,429 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// parseDockerEnvFile reads an env file the way "docker run --env-file" does:
+// leading whitespace is trimmed, # starts a comment line, and everything after
+// the first '=' is taken verbatim.
+func parseDockerEnvFile(t *testing.T, data string) map[string]string {
+	out := map[string]string{}
+	sc := bufio.NewScanner(strings.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimLeft(sc.Text(), " \t\n\v\f\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		require.True(t, ok, "line without value: %q", line)
+		require.False(t, strings.ContainsAny(key, " \t\n\v\f\r"), "key contains whitespace: %q", key)
+		out[key] = value
+	}
+	require.NoError(t, sc.Err())
+	return out
+}
+
+// parseSystemdEnvFile reads an env file with the state machine systemd uses
+// for EnvironmentFile=: unquoted values are trimmed and backslash escapes the
+// next character, '…' is literal, and inside "…" a backslash only escapes
+// " \ ` $ and newlines.
+func parseSystemdEnvFile(t *testing.T, data string) map[string]string {
+	const (
+		preKey = iota
+		key
+		preValue
+		value
+		valueEscape
+		singleQuote
+		doubleQuote
+		doubleQuoteEscape
+		comment
+	)
+
+	out := map[string]string{}
+	var k, v strings.Builder
+	trailing := 0 // unquoted whitespace at the end of v
+	push := func() {
+		val := v.String()
+		out[strings.TrimRight(k.String(), " \t")] = val[:len(val)-trailing]
+		k.Reset()
+		v.Reset()
+		trailing = 0
+	}
+
+	state := preKey
+	for _, c := range data {
+		switch state {
+		case preKey:
+			switch {
+			case c == '#' || c == ';':
+				state = comment
+			case !strings.ContainsRune(" \t\n\r", c):
+				k.WriteRune(c)
+				state = key
+			}
+		case key:
+			switch c {
+			case '\n':
+				k.Reset()
+				state = preKey
+			case '=':
+				state = preValue
+			default:
+				k.WriteRune(c)
+			}
+		case preValue, value:
+			switch {
+			case c == '\n':
+				push()
+				state = preKey
+			case c == '\'':
+				trailing = 0
+				state = singleQuote
+			case c == '"':
+				trailing = 0
+				state = doubleQuote
+			case c == '\\':
+				state = valueEscape
+			case (c == ' ' || c == '\t') && state == preValue:
+			case c == ' ' || c == '\t':
+				v.WriteRune(c)
+				trailing++
+			default:
+				v.WriteRune(c)
+				trailing = 0
+				state = value
+			}
+		case valueEscape:
+			state = value
+			trailing = 0
+			if c != '\n' {
+				v.WriteRune(c)
+			}
+		case singleQuote:
+			if c == '\'' {
+				state = value
+			} else {
+				v.WriteRune(c)
+			}
+		case doubleQuote:
+			switch c {
+			case '"':
+				state = value
+			case '\\':
+				state = doubleQuoteEscape
+			default:
+				v.WriteRune(c)
+			}
+		case doubleQuoteEscape:
+			state = doubleQuote
+			switch {
+			case strings.ContainsRune("\"\\`$", c):
+				v.WriteRune(c)
+			case c == '\n':
+			default:
+				v.WriteRune('\\')
+				v.WriteRune(c)
+			}
+		case comment:
+			if c == '\n' {
+				state = preKey
+			}
+		}
+	}
+	require.NotContains(t, []int{singleQuote, doubleQuote, doubleQuoteEscape}, state, "unterminated quote")
+	if state == preValue || state == value || state == valueEscape {
+		push()
+	}
+	return out
+}
+
+// unquoteMake parses the right-hand side of a make ":=" assignment: leading
+// blanks are stripped, an odd run of backslashes escapes a following '#' (and
+// is halved), "$$" is a dollar sign and "$()" expands to nothing.
+func unquoteMake(t *testing.T, s string) string {
+	s = strings.TrimLeft(s, " \t")
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			n := 1
+			for i+n < len(s) && s[i+n] == '\\' {
+				n++
+			}
+			if i+n < len(s) && s[i+n] == '#' {
+				require.Equal(t, 1, n%2, "unescaped '#' in make value %q", s)
+				b.WriteString(strings.Repeat(`\`, n/2))
+				b.WriteByte('#')
+				i += n
+				continue
+			}
+			require.Less(t, i+n, len(s), "line continuation in make value %q", s)
+			b.WriteString(strings.Repeat(`\`, n))
+			i += n - 1
+		case c == '#':
+			t.Fatalf("unescaped '#' in make value %q", s)
+		case strings.HasPrefix(s[i:], "$$"):
+			b.WriteByte('$')
+			i++
+		case strings.HasPrefix(s[i:], "$()"):
+			i += 2
+		case c == '$':
+			t.Fatalf("unescaped '$' in make value %q", s)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// unquoteHCL parses an HCL quoted string literal: backslash escapes, and
+// "$${" / "%%{" for literal template introducers.
+func unquoteHCL(t *testing.T, s string) string {
+	require.True(t, len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"')
+	inner := s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case c == '\\':
+			require.Less(t, i+1, len(inner), "trailing backslash in %q", s)
+			i++
+			switch inner[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(inner[i])
+			case 'u', 'U':
+				n := 4
+				if inner[i] == 'U' {
+					n = 8
+				}
+				require.LessOrEqual(t, i+1+n, len(inner), "short unicode escape in %q", s)
+				r, err := strconv.ParseUint(inner[i+1:i+1+n], 16, 32)
+				require.NoError(t, err)
+				b.WriteRune(rune(r))
+				i += n
+			default:
+				t.Fatalf("invalid escape \\%c in %q", inner[i], s)
+			}
+		case strings.HasPrefix(inner[i:], "$${"), strings.HasPrefix(inner[i:], "%%{"):
+			b.WriteString(inner[i+1 : i+3])
+			i += 2
+		case strings.HasPrefix(inner[i:], "${"), strings.HasPrefix(inner[i:], "%{"):
+			t.Fatalf("template sequence in %q", s)
+		case c == '"':
+			t.Fatalf("unescaped quote in %q", s)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// formatSeeds are values that exercise the special characters of every format.
+var formatSeeds = []string{
+	"", "plain", " padded ", `it's`, `"double"`, `back\slash`, `trailing\`,
+	"$HOME", "${HOME}", "`cmd`", "#hash", ";semi", "a=b", "line1\nline2", "tab\tchar", "ünïcödé",
+	`a\#b`, `a\\#b`, "%{if x}", "$${x}", "\x00\u200b", "  ", "$()",
+}
+
+func FuzzFormatLine_RoundTrip(f *testing.F) {
+	for _, s := range formatSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, v string) {
+		kv := map[string]string{"KEY": v}
+		vars := []spec.Var{{Name: "KEY", Value: v}}
+
+		var buf bytes.Buffer
+		err := WriteEnvLines(&buf, vars, Options{Format: FormatSystemd})
+		if !utf8.ValidString(v) {
+			require.Error(t, err)
+			return
+		}
+		require.NoError(t, err)
+		assert.Equal(t, kv, parseSystemdEnvFile(t, buf.String()), "systemd")
+
+		buf.Reset()
+		require.NoError(t, WriteEnvLines(&buf, vars, Options{Format: FormatTFVars}))
+		rhs, ok := strings.CutPrefix(strings.TrimSuffix(buf.String(), "\n"), "KEY = ")
+		require.True(t, ok)
+		assert.Equal(t, v, unquoteHCL(t, rhs), "tfvars")
+
+		buf.Reset()
+		require.NoError(t, WriteEnvLines(&buf, vars, Options{Format: FormatTFVarsJSON}))
+		var got map[string]string
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+		assert.Equal(t, kv, got, "tfvars-json")
+
+		buf.Reset()
+		require.NoError(t, WriteEnvLines(&buf, vars, Options{Format: FormatYAML}))
+		got = nil
+		require.NoError(t, yaml.Unmarshal(buf.Bytes(), &got))
+		assert.Equal(t, kv, got, "yaml")
+
+		buf.Reset()
+		err = WriteEnvLines(&buf, vars, Options{Format: FormatDockerEnv})
+		if strings.ContainsAny(v, "\r\n") {
+			require.Error(t, err)
+			return
+		}
+		require.NoError(t, err)
+		assert.Equal(t, kv, parseDockerEnvFile(t, buf.String()), "docker-env")
+
+		buf.Reset()
+		require.NoError(t, WriteEnvLines(&buf, vars, Options{Format: FormatMake}))
+		rhs, ok = strings.CutPrefix(strings.TrimSuffix(buf.String(), "\n"), "KEY :=")
+		require.True(t, ok)
+		assert.Equal(t, v, unquoteMake(t, rhs), "make")
+	})
+}
+
+func TestWriteEnvLines_Formats(t *testing.T) {
+	t.Parallel()
+
+	in := []spec.Var{{Name: "A", Value: `it's "x"`}, {Name: "B", Value: "$HOME"}, {Name: "C", Value: ""}}
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{format: FormatEnv, want: "A=it's \"x\"\nB=$HOME\nC=\n"},
+		{format: FormatDockerEnv, want: "A=it's \"x\"\nB=$HOME\nC=\n"},
+		{format: FormatSystemd, want: "A=\"it's \\\"x\\\"\"\nB=\"\\$HOME\"\nC=\"\"\n"},
+		{format: FormatMake, want: "A := it's \"x\"\nB := $$HOME\nC := \n"},
+		{format: FormatTFVars, want: "A = \"it's \\\"x\\\"\"\nB = \"$HOME\"\nC = \"\"\n"},
+		{format: FormatTFVarsJSON, want: "{\n  \"A\": \"it's \\\"x\\\"\",\n  \"B\": \"$HOME\",\n  \"C\": \"\"\n}\n"},
+		{format: FormatJSON, want: "{\n  \"A\": \"it's \\\"x\\\"\",\n  \"B\": \"$HOME\",\n  \"C\": \"\"\n}\n"},
+		{format: FormatYAML, want: "A: \"it's \\\"x\\\"\"\nB: \"$HOME\"\nC: \"\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			err := WriteEnvLines(&buf, in, Options{Format: tt.format})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+
+	t.Run("systemd keeps newlines", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "a\nb"}}, Options{Format: FormatSystemd})
+		require.NoError(t, err)
+		assert.Equal(t, "A=\"a\nb\"\n", buf.String())
+	})
+
+	t.Run("docker-env rejects newlines", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "a\nb"}}, Options{Format: FormatDockerEnv})
+		require.Error(t, err)
+		assert.EqualError(t, err, "A: docker-env cannot represent values containing newlines")
+	})
+
+	t.Run("docker-env rejects invalid UTF-8", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "\xff"}}, Options{Format: FormatDockerEnv})
+		require.Error(t, err)
+		assert.EqualError(t, err, "A: docker-env requires valid UTF-8")
+	})
+
+	t.Run("make export directive", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "1"}}, Options{Format: FormatMake, Export: true})
+		require.NoError(t, err)
+		assert.Equal(t, "export A := 1\n", buf.String())
+	})
+
+	t.Run("make rejects newlines", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "a\nb"}}, Options{Format: FormatMake})
+		require.Error(t, err)
+		assert.EqualError(t, err, "A: make cannot represent values containing newlines")
+	})
+
+	t.Run("tfvars escapes templates", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "${x} %{y} $z\n"}}, Options{Format: FormatTFVars})
+		require.NoError(t, err)
+		assert.Equal(t, `A = "$${x} %%{y} $z\n"`+"\n", buf.String())
+	})
+
+	t.Run("tfvars-json rejects invalid UTF-8", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "\xff"}}, Options{Format: FormatTFVarsJSON})
+		require.Error(t, err)
+		assert.EqualError(t, err, "A: tfvars-json requires valid UTF-8")
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "1"}}, Options{Format: Format("xml")})
+		require.Error(t, err)
+		assert.EqualError(t, err, `unsupported format "xml"`)
+	})
+}
+
+// TestWriteEnvLines_RealMake includes the make output in a Makefile and checks
+// that make sees the original values.
+func TestWriteEnvLines_RealMake(t *testing.T) {
+	t.Parallel()
+
+	bin, err := exec.LookPath("make")
+	if err != nil {
+		t.Skip("make not found")
+	}
+
+	values := []string{"plain", " padded ", `it's "x"`, "$HOME", "$(shell id)", "#hash", `a\#b`, `a\\#b`, `trailing\`, "100%"}
+	vars := make([]spec.Var, 0, len(values))
+	for i, v := range values {
+		vars = append(vars, spec.Var{Name: "V" + strconv.Itoa(i), Value: v})
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, WriteEnvLinesAtomic(filepath.Join(dir, "vars.mk"), vars, Options{Format: FormatMake}))
+	makefile := "include vars.mk\n"
+	for i := range values {
+		makefile += "$(info [$(V" + strconv.Itoa(i) + ")])\n"
+	}
+	makefile += "all: ;@:\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Makefile"), []byte(makefile), 0o666))
+
+	out, err := exec.Command(bin, "-s", "-C", dir).Output()
+	require.NoError(t, err)
+	want := ""
+	for _, v := range values {
+		want += "[" + v + "]\n"
+	}
+	assert.Equal(t, want, string(out))
+}
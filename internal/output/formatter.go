@@ -0,0 +1,194 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gi8lino/unveil/internal/spec"
+)
+
+// Formatter renders variables in one output syntax. Ordering, diffing and
+// atomic writing are done before and after it, the same for every format.
+type Formatter interface {
+	// Render writes vars to w in the given order. Values are raw, except for
+	// FormatEnv, where they are already quoted for opts.Shell.
+	Render(w io.Writer, vars []spec.Var, opts Options) error
+	// Entry renders a single variable as Diff reports it.
+	Entry(v spec.Var, opts Options) (string, error)
+	// Entries returns the entries of existing output by name, rendered like
+	// Entry. Output it cannot read has no entries.
+	Entries(data []byte, opts Options) (map[string]string, error)
+}
+
+// Merger is implemented by formatters that can update existing output in
+// place, see Merge.
+type Merger interface {
+	// Merge replaces the entries of vars in existing and appends the missing
+	// ones in the given order.
+	Merge(existing []byte, vars []spec.Var, opts Options) ([]byte, error)
+}
+
+// formats holds the formatters by format, in registration order.
+var formats = struct {
+	sync.RWMutex
+	names      []Format
+	formatters map[Format]Formatter
+}{formatters: make(map[Format]Formatter)}
+
+// RegisterFormat makes fm available as format f. Each format becomes a
+// choice of --format. It panics if f is registered twice.
+func RegisterFormat(f Format, fm Formatter) {
+	formats.Lock()
+	defer formats.Unlock()
+	if _, ok := formats.formatters[f]; ok {
+		panic(fmt.Sprintf("output: format %q registered twice", f))
+	}
+	formats.names = append(formats.names, f)
+	formats.formatters[f] = fm
+}
+
+// Formats returns the registered formats in registration order.
+func Formats() []Format {
+	formats.RLock()
+	defer formats.RUnlock()
+	return append([]Format(nil), formats.names...)
+}
+
+// formatterFor returns the formatter registered for f; empty means FormatEnv.
+func formatterFor(f Format) (Formatter, error) {
+	if f == "" {
+		f = FormatEnv
+	}
+	formats.RLock()
+	defer formats.RUnlock()
+	fm, ok := formats.formatters[f]
+	if !ok {
+		return nil, fmt.Errorf("unsupported format %q", f)
+	}
+	return fm, nil
+}
+
+// Mergeable reports whether the formatter of f implements Merger.
+func Mergeable(f Format) bool {
+	fm, err := formatterFor(f)
+	if err != nil {
+		return false
+	}
+	_, ok := fm.(Merger)
+	return ok
+}
+
+func init() {
+	RegisterFormat(FormatEnv, lineFormat{line: shellLine, start: shellPattern, incomplete: shellIncomplete})
+	RegisterFormat(FormatDockerEnv, lineFormat{line: dockerLine, start: fixedPattern(`^([^=\s]+)=`), incomplete: never})
+	RegisterFormat(FormatSystemd, lineFormat{line: systemdLine, start: fixedPattern(`^([^=\s]+)=`), incomplete: systemdIncomplete})
+	RegisterFormat(FormatMake, lineFormat{line: makeLine, start: fixedPattern(`^(?:export )?(\S+) := `), incomplete: makeIncomplete})
+	RegisterFormat(FormatTFVars, lineFormat{line: tfvarsLine, start: fixedPattern(`^(\S+) = `), incomplete: never})
+	RegisterFormat(FormatTFVarsJSON, jsonFormat{name: FormatTFVarsJSON})
+	RegisterFormat(FormatJSON, jsonFormat{name: FormatJSON})
+	RegisterFormat(FormatYAML, lineFormat{line: yamlLine, start: fixedPattern(`^"?([A-Za-z_][A-Za-z0-9_]*)"?: `), incomplete: never})
+}
+
+// lineFormat writes one assignment per variable. An assignment starts with a
+// line matching start and continues while incomplete reports true.
+type lineFormat struct {
+	line       func(v spec.Var, opts Options) (string, error)
+	start      func(opts Options) (*regexp.Regexp, error)
+	incomplete func(text string, opts Options) bool
+}
+
+func (f lineFormat) Render(w io.Writer, vars []spec.Var, opts Options) error {
+	for _, v := range vars {
+		line, err := f.line(v, opts)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f lineFormat) Entry(v spec.Var, opts Options) (string, error) {
+	return f.line(v, opts)
+}
+
+func (f lineFormat) Entries(data []byte, opts Options) (map[string]string, error) {
+	segs, err := f.split(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	for _, seg := range segs {
+		if seg.key != "" {
+			out[seg.key] = seg.text
+		}
+	}
+	return out, nil
+}
+
+// fixedPattern returns a start function ignoring the options.
+func fixedPattern(expr string) func(Options) (*regexp.Regexp, error) {
+	re := regexp.MustCompile(expr)
+	return func(Options) (*regexp.Regexp, error) { return re, nil }
+}
+
+// never is the incomplete function of formats without multi-line assignments.
+func never(string, Options) bool { return false }
+
+// jsonFormat writes a single JSON object of strings.
+type jsonFormat struct {
+	name Format
+}
+
+func (f jsonFormat) Render(w io.Writer, vars []spec.Var, _ Options) error {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, v := range vars {
+		if !utf8.ValidString(v.Value) {
+			return fmt.Errorf("%s: %s requires valid UTF-8", v.Name, f.name)
+		}
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  " + jsonString(v.Name) + ": " + jsonString(v.Value))
+	}
+	if len(vars) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (f jsonFormat) Entry(v spec.Var, _ Options) (string, error) {
+	b, err := json.Marshal(v.Value)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (f jsonFormat) Entries(data []byte, _ Options) (map[string]string, error) {
+	out := map[string]string{}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return out, nil // unreadable: every key differs
+	}
+	for k, raw := range obj {
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			out[k] = string(raw)
+			continue
+		}
+		b, _ := json.Marshal(v)
+		out[k] = string(b)
+	}
+	return out, nil
+}
@@ -0,0 +1,135 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tsvFormat writes NAME<TAB>VALUE lines and marks secret values.
+type tsvFormat struct{}
+
+func (tsvFormat) Render(w io.Writer, vars []spec.Var, opts Options) error {
+	for _, v := range vars {
+		line, _ := tsvFormat{}.Entry(v, opts)
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tsvFormat) Entry(v spec.Var, _ Options) (string, error) {
+	if v.Secret {
+		return v.Name + "\t" + v.Value + "\tsecret", nil
+	}
+	return v.Name + "\t" + v.Value, nil
+}
+
+func (tsvFormat) Entries(data []byte, _ Options) (map[string]string, error) {
+	out := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if name, _, ok := strings.Cut(line, "\t"); ok {
+			out[name] = line
+		}
+	}
+	return out, nil
+}
+
+func TestRegisterFormat(t *testing.T) {
+	t.Parallel()
+
+	const format Format = "test-tsv"
+	RegisterFormat(format, tsvFormat{})
+	vars := []spec.Var{{Name: "B", Value: "2", Secret: true}, {Name: "A", Value: "1"}}
+
+	t.Run("Builtin formats first", func(t *testing.T) {
+		t.Parallel()
+		formats := Formats()
+		require.GreaterOrEqual(t, len(formats), 8)
+		assert.Equal(t, []Format{FormatEnv, FormatDockerEnv, FormatSystemd, FormatMake, FormatTFVars, FormatTFVarsJSON, FormatJSON, FormatYAML}, formats[:8])
+		assert.Contains(t, formats, format)
+	})
+
+	t.Run("Renders ordered variables with metadata", func(t *testing.T) {
+		t.Parallel()
+		data, err := Render(vars, Options{Format: format})
+		require.NoError(t, err)
+		assert.Equal(t, "A\t1\nB\t2\tsecret\n", string(data))
+	})
+
+	t.Run("Diff uses its entries", func(t *testing.T) {
+		t.Parallel()
+		changes, differs, err := Diff([]byte("A\t0\n"), vars, Options{Format: format})
+		require.NoError(t, err)
+		assert.True(t, differs)
+		assert.Equal(t, []Change{
+			{Kind: Changed, Key: "A", Old: "A\t0", New: "A\t1"},
+			{Kind: Added, Key: "B", New: "B\t2\tsecret"},
+		}, changes)
+	})
+
+	t.Run("Merge requires Merger", func(t *testing.T) {
+		t.Parallel()
+		assert.False(t, Mergeable(format))
+		_, err := Merge(nil, vars, Options{Format: format})
+		require.Error(t, err)
+		assert.EqualError(t, err, "merging is not supported for format test-tsv")
+	})
+
+	t.Run("Registering twice panics", func(t *testing.T) {
+		t.Parallel()
+		assert.PanicsWithValue(t, `output: format "env" registered twice`, func() {
+			RegisterFormat(FormatEnv, tsvFormat{})
+		})
+	})
+}
+
+func TestMergeable(t *testing.T) {
+	t.Parallel()
+
+	for _, f := range []Format{"", FormatEnv, FormatDockerEnv, FormatSystemd, FormatMake, FormatTFVars, FormatYAML} {
+		assert.True(t, Mergeable(f), f)
+	}
+	for _, f := range []Format{FormatTFVarsJSON, FormatJSON, Format("xml")} {
+		assert.False(t, Mergeable(f), f)
+	}
+}
+
+func TestWriteEnvLines_YAML(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Quotes reserved names", func(t *testing.T) {
+		t.Parallel()
+		data, err := Render([]spec.Var{{Name: "yes", Value: "1"}, {Name: "Null", Value: "2"}}, Options{Format: FormatYAML})
+		require.NoError(t, err)
+		assert.Equal(t, "\"Null\": \"2\"\n\"yes\": \"1\"\n", string(data))
+	})
+
+	t.Run("Escapes control characters", func(t *testing.T) {
+		t.Parallel()
+		data, err := Render([]spec.Var{{Name: "A", Value: "a\u0085b\x7f"}}, Options{Format: FormatYAML})
+		require.NoError(t, err)
+		assert.Equal(t, "A: \"a\\u0085b\\u007f\"\n", string(data))
+	})
+
+	t.Run("Merge keeps other keys", func(t *testing.T) {
+		t.Parallel()
+		existing := "# values\nOTHER: \"x\"\n\"no\": \"old\"\n"
+		data, err := Merge([]byte(existing), []spec.Var{{Name: "no", Value: "new"}, {Name: "A", Value: "1"}}, Options{Format: FormatYAML})
+		require.NoError(t, err)
+		assert.Equal(t, "# values\nOTHER: \"x\"\n\"no\": \"new\"\nA: \"1\"\n", string(data))
+	})
+
+	t.Run("Rejects invalid UTF-8", func(t *testing.T) {
+		t.Parallel()
+		_, err := Render([]spec.Var{{Name: "A", Value: "\xff"}}, Options{Format: FormatYAML})
+		require.Error(t, err)
+		assert.EqualError(t, err, "A: yaml requires valid UTF-8")
+	})
+}
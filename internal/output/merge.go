@@ -0,0 +1,57 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gi8lino/unveil/internal/spec"
+)
+
+// Merge updates the assignments of vars in existing and appends the missing
+// ones in opts.Order. All other lines, comments and the order of existing
+// assignments are kept as they are. The formatter of opts.Format must
+// implement Merger.
+func Merge(existing []byte, vars []spec.Var, opts Options) ([]byte, error) {
+	fm, err := formatterFor(opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := fm.(Merger)
+	if !ok {
+		return nil, fmt.Errorf("merging is not supported for format %s", opts.Format)
+	}
+	return m.Merge(existing, prepare(vars, opts), opts)
+}
+
+func (f lineFormat) Merge(existing []byte, vars []spec.Var, opts Options) ([]byte, error) {
+	want, err := entries(f, vars, opts)
+	if err != nil {
+		return nil, err
+	}
+	segs, err := f.split(existing, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	seen := make(map[string]bool, len(vars))
+	for _, seg := range segs {
+		text := seg.text
+		if n, ok := want[seg.key]; ok && seg.key != "" {
+			text = n // every definition, so duplicates cannot shadow the update
+			seen[seg.key] = true
+		}
+		buf.WriteString(text)
+		buf.WriteByte('\n')
+	}
+
+	for _, v := range vars {
+		if seen[v.Name] {
+			continue
+		}
+		seen[v.Name] = true
+		buf.WriteString(want[v.Name])
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
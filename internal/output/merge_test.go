@@ -0,0 +1,140 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gi8lino/unveil/internal/quote"
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Updates in place and appends", func(t *testing.T) {
+		t.Parallel()
+		existing := "# database\nDB_HOST=localhost\nDB_USER=alice # owner\n\nexport OTHER=1\n"
+		got, err := Merge([]byte(existing), []spec.Var{{Name: "DB_USER", Value: "bob"}, {Name: "Z", Value: "z"}, {Name: "A", Value: "a"}}, Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "# database\nDB_HOST=localhost\nDB_USER=bob\n\nexport OTHER=1\nA=a\nZ=z\n", string(got))
+	})
+
+	t.Run("Empty file", func(t *testing.T) {
+		t.Parallel()
+		got, err := Merge(nil, []spec.Var{{Name: "B", Value: "2"}, {Name: "A", Value: "1"}}, Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "A=1\nB=2\n", string(got))
+	})
+
+	t.Run("Missing final newline", func(t *testing.T) {
+		t.Parallel()
+		got, err := Merge([]byte("X=1"), []spec.Var{{Name: "A", Value: "1"}}, Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "X=1\nA=1\n", string(got))
+	})
+
+	t.Run("Duplicates are all updated", func(t *testing.T) {
+		t.Parallel()
+		got, err := Merge([]byte("A=1\nB=2\nA=3\n"), []spec.Var{{Name: "A", Value: "new"}}, Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "A=new\nB=2\nA=new\n", string(got))
+	})
+
+	t.Run("Multi-line values are replaced whole", func(t *testing.T) {
+		t.Parallel()
+		existing := "A='line1\nB=not a key\nline3'\nC=\"x\\\ny\"\nD=keep\n"
+		got, err := Merge([]byte(existing), []spec.Var{{Name: "A", Value: "'one'"}, {Name: "C", Value: "'two'"}}, Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "A='one'\nC='two'\nD=keep\n", string(got))
+	})
+
+	t.Run("Quote in comment does not continue", func(t *testing.T) {
+		t.Parallel()
+		got, err := Merge([]byte("A=1 # it's\nB=2\n"), []spec.Var{{Name: "A", Value: "x"}}, Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "A=x\nB=2\n", string(got))
+	})
+
+	t.Run("Formats", func(t *testing.T) {
+		t.Parallel()
+		tests := []struct {
+			name     string
+			opts     Options
+			existing string
+			kv       []spec.Var
+			want     string
+		}{
+			{
+				name:     "fish",
+				opts:     Options{Shell: quote.ShellFish},
+				existing: "set -gx A 'it\\'s\nmore'\nset -gx B 'b'\n",
+				kv:       []spec.Var{{Name: "A", Value: "'a'"}},
+				want:     "set -gx A 'a'\nset -gx B 'b'\n",
+			},
+			{
+				name:     "systemd",
+				opts:     Options{Format: FormatSystemd},
+				existing: "; comment\nA=\"x\ny\"\nB=b\n",
+				kv:       []spec.Var{{Name: "A", Value: "a"}},
+				want:     "; comment\nA=\"a\"\nB=b\n",
+			},
+			{
+				name:     "make",
+				opts:     Options{Format: FormatMake},
+				existing: "A := one \\\n  two\nB := b\n",
+				kv:       []spec.Var{{Name: "A", Value: "a"}},
+				want:     "A := a\nB := b\n",
+			},
+			{
+				name:     "tfvars",
+				opts:     Options{Format: FormatTFVars},
+				existing: "region = \"eu\"\nA = \"old\"\n",
+				kv:       []spec.Var{{Name: "A", Value: "new"}},
+				want:     "region = \"eu\"\nA = \"new\"\n",
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				t.Parallel()
+				got, err := Merge([]byte(tt.existing), tt.kv, tt.opts)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, string(got))
+			})
+		}
+	})
+
+	t.Run("tfvars-json unsupported", func(t *testing.T) {
+		t.Parallel()
+		_, err := Merge(nil, []spec.Var{{Name: "A", Value: "1"}}, Options{Format: FormatTFVarsJSON})
+		require.Error(t, err)
+		assert.EqualError(t, err, "merging is not supported for format tfvars-json")
+	})
+}
+
+func TestWriteEnvLinesAtomic_Merge(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Merges into existing file", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), ".env")
+		require.NoError(t, os.WriteFile(path, []byte("# keep\nA=old\nB=2\n"), 0o666))
+
+		require.NoError(t, WriteEnvLinesAtomic(path, []spec.Var{{Name: "A", Value: "new"}}, Options{Merge: true}))
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "# keep\nA=new\nB=2\n", string(got))
+	})
+
+	t.Run("Creates missing file", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "sub", ".env")
+
+		require.NoError(t, WriteEnvLinesAtomic(path, []spec.Var{{Name: "A", Value: "1"}}, Options{Merge: true}))
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "A=1\n", string(got))
+	})
+}
@@ -0,0 +1,99 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// nameRule describes the variable names a format can write.
+type nameRule struct {
+	desc  string          // human-readable summary for errors
+	first func(rune) bool // allowed first character
+	rest  func(rune) bool // allowed following characters
+}
+
+// nameRuleFor returns the naming rule of f. Shells, systemd and make only
+// pass POSIX identifiers on to the environment; Terraform additionally allows
+// dashes, docker only forbids '=' and whitespace.
+func nameRuleFor(f Format) nameRule {
+	switch f {
+	case FormatDockerEnv:
+		return nameRule{
+			desc:  "must not contain '=' or whitespace or start with '#'",
+			first: func(r rune) bool { return r != '#' && dockerNameRune(r) },
+			rest:  dockerNameRune,
+		}
+	case FormatTFVars:
+		return nameRule{
+			desc:  "must be an HCL identifier ([A-Za-z_][A-Za-z0-9_-]*)",
+			first: identStart,
+			rest:  func(r rune) bool { return identRune(r) || r == '-' },
+		}
+	case FormatTFVarsJSON, FormatJSON:
+		return nameRule{
+			desc:  "must be valid UTF-8",
+			first: func(r rune) bool { return r != utf8.RuneError },
+			rest:  func(r rune) bool { return r != utf8.RuneError },
+		}
+	default:
+		return nameRule{
+			desc:  "must be a POSIX identifier ([A-Za-z_][A-Za-z0-9_]*)",
+			first: identStart,
+			rest:  identRune,
+		}
+	}
+}
+
+// CheckName returns an error if name cannot be written in format f.
+func CheckName(name string, f Format) error {
+	rule := nameRuleFor(f)
+	if name == "" {
+		return fmt.Errorf("variable name must not be empty")
+	}
+	for i, r := range name {
+		if (i == 0 && !rule.first(r)) || (i > 0 && !rule.rest(r)) {
+			return fmt.Errorf("invalid variable name %q: %s", name, rule.desc)
+		}
+	}
+	return nil
+}
+
+// SanitizeName rewrites name so CheckName accepts it for f: invalid
+// characters become '_', and '_' is prepended if the first character may
+// only appear later in a name.
+func SanitizeName(name string, f Format) string {
+	rule := nameRuleFor(f)
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case i == 0 && !rule.first(r) && rule.rest(r):
+			b.WriteByte('_')
+			b.WriteRune(r)
+		case (i == 0 && !rule.first(r)) || !rule.rest(r):
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// identStart reports whether r may start a POSIX identifier.
+func identStart(r rune) bool {
+	return r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+// identRune reports whether r may appear in a POSIX identifier.
+func identRune(r rune) bool {
+	return identStart(r) || ('0' <= r && r <= '9')
+}
+
+// dockerNameRune reports whether r may appear in a docker env-file name.
+func dockerNameRune(r rune) bool {
+	return r != '=' && r != utf8.RuneError && !unicode.IsSpace(r)
+}
@@ -0,0 +1,75 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		format  Format
+		wantErr string
+	}{
+		{name: "DB_HOST", format: FormatEnv},
+		{name: "_x1", format: FormatSystemd},
+		{name: "my-var", format: FormatEnv, wantErr: `invalid variable name "my-var": must be a POSIX identifier ([A-Za-z_][A-Za-z0-9_]*)`},
+		{name: "1ABC", format: FormatMake, wantErr: `invalid variable name "1ABC": must be a POSIX identifier ([A-Za-z_][A-Za-z0-9_]*)`},
+		{name: "ÄPFEL", format: FormatEnv, wantErr: `invalid variable name "ÄPFEL": must be a POSIX identifier ([A-Za-z_][A-Za-z0-9_]*)`},
+		{name: "", format: FormatEnv, wantErr: "variable name must not be empty"},
+		{name: "my-var", format: FormatTFVars},
+		{name: "-var", format: FormatTFVars, wantErr: `invalid variable name "-var": must be an HCL identifier ([A-Za-z_][A-Za-z0-9_-]*)`},
+		{name: "my.var-1", format: FormatDockerEnv},
+		{name: "#x", format: FormatDockerEnv, wantErr: `invalid variable name "#x": must not contain '=' or whitespace or start with '#'`},
+		{name: "a b", format: FormatDockerEnv, wantErr: `invalid variable name "a b": must not contain '=' or whitespace or start with '#'`},
+		{name: "any name", format: FormatTFVarsJSON},
+		{name: "\xff", format: FormatTFVarsJSON, wantErr: `invalid variable name "\xff": must be valid UTF-8`},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format)+" "+tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := CheckName(tt.name, tt.format)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		format Format
+		want   string
+	}{
+		{name: "DB_HOST", format: FormatEnv, want: "DB_HOST"},
+		{name: "my-var", format: FormatEnv, want: "my_var"},
+		{name: "1ABC", format: FormatEnv, want: "_1ABC"},
+		{name: "a.b c", format: FormatSystemd, want: "a_b_c"},
+		{name: "ÄPFEL", format: FormatEnv, want: "_PFEL"},
+		{name: "-", format: FormatEnv, want: "_"},
+		{name: "", format: FormatEnv, want: "_"},
+		{name: "my-var", format: FormatTFVars, want: "my-var"},
+		{name: "-var", format: FormatTFVars, want: "_-var"},
+		{name: "#a=b", format: FormatDockerEnv, want: "_#a_b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format)+" "+tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := SanitizeName(tt.name, tt.format)
+			assert.Equal(t, tt.want, got)
+			assert.NoError(t, CheckName(got, tt.format))
+		})
+	}
+}
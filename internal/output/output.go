@@ -1,41 +1,130 @@
 package output
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+
+	"github.com/gi8lino/unveil/internal/quote"
+	"github.com/gi8lino/unveil/internal/spec"
+)
+
+// Options controls how KEY=VALUE lines are rendered.
+type Options struct {
+	Format Format      // output format; empty means FormatEnv
+	Shell  quote.Shell // target shell dialect for FormatEnv; empty means POSIX
+	Export bool        // mark variables for export (POSIX and make only)
+	Merge  bool        // update an existing file instead of replacing it
+	Order  Order       // order of the variables; empty means OrderSorted
+	File   FileOptions // permissions of the file written by WriteEnvLinesAtomic
+
+	// OnWrite is called after WriteEnvLinesAtomic changed the file.
+	OnWrite func() error
+}
+
+// Order is the order in which variables are written.
+type Order string
+
+const (
+	OrderSorted   Order = "sorted"   // by name
+	OrderDeclared Order = "declared" // as declared on the command line
 )
 
-// WriteEnvLines prints KEY=VALUE lines, sorted by KEY.
-// If export is true, each line is prefixed with "export ".
-func WriteEnvLines(w io.Writer, kv map[string]string, export bool) error {
-	prefix := ""
-	if export {
-		prefix = "export "
+// Orders lists all supported orders.
+var Orders = []Order{OrderSorted, OrderDeclared}
+
+// ErrHookFailed is wrapped by WriteEnvLinesAtomic when the file was written
+// but OnWrite failed.
+var ErrHookFailed = errors.New("on-change hook failed")
+
+// WriteEnvLines prints one assignment per variable, sorted by name unless
+// opts.Order is OrderDeclared.
+// For FormatEnv, values must already be quoted for opts.Shell; all other
+// formats expect raw values and escape them themselves.
+func WriteEnvLines(w io.Writer, vars []spec.Var, opts Options) error {
+	fm, err := formatterFor(opts.Format)
+	if err != nil {
+		return err
+	}
+	return fm.Render(w, prepare(vars, opts), opts)
+}
+
+// prepare returns vars in opts.Order without modifying vars.
+func prepare(vars []spec.Var, opts Options) []spec.Var {
+	out := slices.Clone(vars)
+	if opts.Order != OrderDeclared {
+		sort.SliceStable(out, func(i, j int) bool { return out[i].Name < out[j].Name })
 	}
+	return out
+}
 
-	keys := make([]string, 0, len(kv))
-	for k := range kv {
-		keys = append(keys, k)
+// Render returns the output WriteEnvLines would write.
+func Render(vars []spec.Var, opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteEnvLines(&buf, vars, opts); err != nil {
+		return nil, err
 	}
-	sort.Strings(keys)
+	return buf.Bytes(), nil
+}
 
-	for _, k := range keys {
-		if _, err := fmt.Fprintf(w, "%s%s=%s\n", prefix, k, kv[k]); err != nil {
-			return err
+// WriteEnvLinesAtomic writes KEY=VALUE lines atomically to path.
+// With opts.Merge, the lines are merged into the existing file (see Merge).
+// It creates parent directories, writes to a temp file, fsyncs, and renames,
+// then calls opts.OnWrite. A file that already has the content is not
+// replaced and fires no hook, but still gets the permissions of opts.File.
+func WriteEnvLinesAtomic(path string, vars []spec.Var, opts Options) error {
+	data, err := renderFile(path, vars, opts)
+	if err != nil {
+		return err
+	}
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return opts.File.refresh(path)
+	}
+	if err := WriteFileAtomic(path, data, opts.File); err != nil {
+		return err
+	}
+	if opts.OnWrite != nil {
+		if err := opts.OnWrite(); err != nil {
+			return fmt.Errorf("%w: %w", ErrHookFailed, err)
 		}
 	}
 	return nil
 }
 
-// WriteEnvLinesAtomic writes KEY=VALUE lines atomically to path.
-// It creates parent directories, writes to a temp file, fsyncs, and renames.
-func WriteEnvLinesAtomic(path string, kv map[string]string, export bool) error {
+// renderFile returns the content WriteEnvLinesAtomic writes to path.
+func renderFile(path string, vars []spec.Var, opts Options) ([]byte, error) {
+	if !opts.Merge {
+		return Render(vars, opts)
+	}
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading %q: %w", path, err)
+	}
+	return Merge(existing, vars, opts)
+}
+
+// WriteFileAtomic writes data atomically to path.
+// It creates missing parent directories, only accessible by their owner if
+// opts.Private is set, writes to a temp file with the mode and owner from
+// opts, fsyncs, and renames.
+func WriteFileAtomic(path string, data []byte, opts FileOptions) error {
 	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return fmt.Errorf("creating output directory %q: %w", dir, err)
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		perm := os.FileMode(0o755)
+		if opts.Private {
+			perm = 0o700
+		}
+		if err := os.MkdirAll(dir, perm); err != nil {
+			return fmt.Errorf("creating output directory %q: %w", dir, err)
+		}
+	} else if err := checkDir(dir, opts); err != nil {
+		return err
 	}
 
 	tmp, err := os.CreateTemp(dir, ".unveil-*")
@@ -49,9 +138,12 @@ func WriteEnvLinesAtomic(path string, kv map[string]string, export bool) error {
 		_ = os.Remove(tmpPath)
 	}()
 
-	if err := WriteEnvLines(tmp, kv, export); err != nil {
+	if err := opts.apply(tmp); err != nil {
 		return err
 	}
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("writing temp file %q: %w", tmpPath, err)
+	}
 	if err := tmp.Sync(); err != nil {
 		return fmt.Errorf("syncing temp file %q: %w", tmpPath, err)
 	}
@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/gi8lino/unveil/internal/quote"
+	"github.com/gi8lino/unveil/internal/spec"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -23,7 +25,7 @@ func TestWriteEnvLines(t *testing.T) {
 	t.Run("Empty map writes nothing (no export)", func(t *testing.T) {
 		t.Parallel()
 		var buf bytes.Buffer
-		err := WriteEnvLines(&buf, map[string]string{}, false)
+		err := WriteEnvLines(&buf, []spec.Var{}, Options{})
 		require.NoError(t, err)
 		assert.Equal(t, "", buf.String())
 	})
@@ -31,7 +33,7 @@ func TestWriteEnvLines(t *testing.T) {
 	t.Run("Single key=value (no export)", func(t *testing.T) {
 		t.Parallel()
 		var buf bytes.Buffer
-		err := WriteEnvLines(&buf, map[string]string{"A": "1"}, false)
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "1"}}, Options{})
 		require.NoError(t, err)
 		assert.Equal(t, "A=1\n", buf.String())
 	})
@@ -39,12 +41,12 @@ func TestWriteEnvLines(t *testing.T) {
 	t.Run("Multiple keys sorted by key (no export)", func(t *testing.T) {
 		t.Parallel()
 		var buf bytes.Buffer
-		in := map[string]string{
-			"Z": "last",
-			"A": "first",
-			"M": "middle",
+		in := []spec.Var{
+			{Name: "Z", Value: "last"},
+			{Name: "A", Value: "first"},
+			{Name: "M", Value: "middle"},
 		}
-		err := WriteEnvLines(&buf, in, false)
+		err := WriteEnvLines(&buf, in, Options{})
 		require.NoError(t, err)
 		want := "A=first\nM=middle\nZ=last\n"
 		assert.Equal(t, want, buf.String())
@@ -53,10 +55,10 @@ func TestWriteEnvLines(t *testing.T) {
 	t.Run("Values are written verbatim (no quoting/escaping) (no export)", func(t *testing.T) {
 		t.Parallel()
 		var buf bytes.Buffer
-		in := map[string]string{
-			"SPECIAL": `a b "c" $d \ e`,
+		in := []spec.Var{
+			{Name: "SPECIAL", Value: `a b "c" $d \ e`},
 		}
-		err := WriteEnvLines(&buf, in, false)
+		err := WriteEnvLines(&buf, in, Options{})
 		require.NoError(t, err)
 		assert.Equal(t, `SPECIAL=a b "c" $d \ e`+"\n", buf.String())
 	})
@@ -64,7 +66,7 @@ func TestWriteEnvLines(t *testing.T) {
 	t.Run("Writer error is propagated (no export)", func(t *testing.T) {
 		t.Parallel()
 		w := &errWriter{err: errors.New("sink is broken")}
-		err := WriteEnvLines(w, map[string]string{"A": "1"}, false)
+		err := WriteEnvLines(w, []spec.Var{{Name: "A", Value: "1"}}, Options{})
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "sink is broken")
 	})
@@ -72,13 +74,56 @@ func TestWriteEnvLines(t *testing.T) {
 	t.Run("Export prefix applied", func(t *testing.T) {
 		t.Parallel()
 		var buf bytes.Buffer
-		err := WriteEnvLines(&buf, map[string]string{"A": "1", "B": "2"}, true)
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}}, Options{Export: true})
 		require.NoError(t, err)
 		// Sorted keys, each with "export " prefix
 		assert.Equal(t, "export A=1\nexport B=2\n", buf.String())
 	})
 }
 
+func TestWriteEnvLines_Shells(t *testing.T) {
+	t.Parallel()
+
+	in := []spec.Var{{Name: "A", Value: "'1'"}, {Name: "B", Value: "'2'"}}
+
+	tests := []struct {
+		shell quote.Shell
+		want  string
+	}{
+		{shell: quote.ShellPOSIX, want: "A='1'\nB='2'\n"},
+		{shell: quote.ShellFish, want: "set -gx A '1'\nset -gx B '2'\n"},
+		{shell: quote.ShellPwsh, want: "$env:A = '1'\n$env:B = '2'\n"},
+		{shell: quote.ShellCsh, want: "setenv A '1'\nsetenv B '2'\n"},
+		{shell: quote.ShellCmd, want: "set A='1'\nset B='2'\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.shell), func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			err := WriteEnvLines(&buf, in, Options{Shell: tt.shell})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+
+	t.Run("cmd rejects newlines", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "a\nb"}}, Options{Shell: quote.ShellCmd})
+		require.Error(t, err)
+		assert.EqualError(t, err, "A: cmd cannot represent values containing newlines")
+	})
+
+	t.Run("Unknown shell", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		err := WriteEnvLines(&buf, []spec.Var{{Name: "A", Value: "1"}}, Options{Shell: quote.Shell("ksh")})
+		require.Error(t, err)
+		assert.EqualError(t, err, `unsupported shell "ksh"`)
+	})
+}
+
 func TestWriteEnvLinesAtomic(t *testing.T) {
 	t.Parallel()
 
@@ -87,7 +132,7 @@ func TestWriteEnvLinesAtomic(t *testing.T) {
 		dir := t.TempDir()
 		path := filepath.Join(dir, "out.env")
 
-		err := WriteEnvLinesAtomic(path, map[string]string{"B": "2", "A": "1"}, false)
+		err := WriteEnvLinesAtomic(path, []spec.Var{{Name: "B", Value: "2"}, {Name: "A", Value: "1"}}, Options{})
 		require.NoError(t, err)
 
 		got, err := os.ReadFile(path)
@@ -100,7 +145,7 @@ func TestWriteEnvLinesAtomic(t *testing.T) {
 		dir := t.TempDir()
 		path := filepath.Join(dir, "nested", "deeper", "out.env")
 
-		err := WriteEnvLinesAtomic(path, map[string]string{"K": "V"}, false)
+		err := WriteEnvLinesAtomic(path, []spec.Var{{Name: "K", Value: "V"}}, Options{})
 		require.NoError(t, err)
 
 		got, err := os.ReadFile(path)
@@ -113,7 +158,7 @@ func TestWriteEnvLinesAtomic(t *testing.T) {
 		dir := t.TempDir()
 		path := filepath.Join(dir, "export.env")
 
-		err := WriteEnvLinesAtomic(path, map[string]string{"A": "1", "B": "2"}, true)
+		err := WriteEnvLinesAtomic(path, []spec.Var{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}}, Options{Export: true})
 		require.NoError(t, err)
 
 		got, err := os.ReadFile(path)
@@ -130,7 +175,7 @@ func TestWriteEnvLinesAtomic(t *testing.T) {
 		require.NoError(t, os.WriteFile(path, []byte("OLD=1\n"), 0o666))
 
 		// Write new content
-		err := WriteEnvLinesAtomic(path, map[string]string{"NEW": "2"}, false)
+		err := WriteEnvLinesAtomic(path, []spec.Var{{Name: "NEW", Value: "2"}}, Options{})
 		require.NoError(t, err)
 
 		got, err := os.ReadFile(path)
@@ -138,3 +183,125 @@ func TestWriteEnvLinesAtomic(t *testing.T) {
 		assert.Equal(t, "NEW=2\n", string(got))
 	})
 }
+
+func TestWriteEnvLinesAtomic_OnWrite(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Called after the file was replaced", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "out.env")
+		called := false
+		opts := Options{OnWrite: func() error {
+			called = true
+			got, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, "A=1\n", string(got))
+			return nil
+		}}
+
+		require.NoError(t, WriteEnvLinesAtomic(path, []spec.Var{{Name: "A", Value: "1"}}, opts))
+		assert.True(t, called)
+	})
+
+	t.Run("Hook error is wrapped", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "out.env")
+		opts := Options{OnWrite: func() error { return errors.New("boom") }}
+
+		err := WriteEnvLinesAtomic(path, []spec.Var{{Name: "A", Value: "1"}}, opts)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrHookFailed)
+		assert.EqualError(t, err, "on-change hook failed: boom")
+		_, statErr := os.Stat(path)
+		assert.NoError(t, statErr, "file is written before the hook runs")
+	})
+
+	t.Run("Not called when rendering fails", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "out.env")
+		opts := Options{Format: FormatDockerEnv, OnWrite: func() error {
+			t.Fatal("hook must not run")
+			return nil
+		}}
+
+		err := WriteEnvLinesAtomic(path, []spec.Var{{Name: "A", Value: "a\nb"}}, opts)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrHookFailed)
+	})
+	t.Run("Not called when the file is unchanged", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "out.env")
+		require.NoError(t, os.WriteFile(path, []byte("A=1\n"), 0o644))
+		before, err := os.Stat(path)
+		require.NoError(t, err)
+		opts := Options{OnWrite: func() error {
+			t.Fatal("hook must not run")
+			return nil
+		}}
+
+		require.NoError(t, WriteEnvLinesAtomic(path, []spec.Var{{Name: "A", Value: "1"}}, opts))
+		after, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(before, after), "file must not be replaced")
+	})
+	t.Run("Unchanged file gets the mode", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "out.env")
+		require.NoError(t, os.WriteFile(path, []byte("A=1\n"), 0o600))
+		require.NoError(t, os.Chmod(path, 0o644))
+
+		opts := Options{File: FileOptions{Mode: 0o640}}
+		require.NoError(t, WriteEnvLinesAtomic(path, []spec.Var{{Name: "A", Value: "1"}}, opts))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+	})
+
+	t.Run("Unchanged file in world-readable directory", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		require.NoError(t, os.Chmod(dir, 0o755))
+		path := filepath.Join(dir, "out.env")
+		require.NoError(t, os.WriteFile(path, []byte("A=1\n"), 0o600))
+
+		opts := Options{File: FileOptions{Private: true}}
+		err := WriteEnvLinesAtomic(path, []spec.Var{{Name: "A", Value: "1", Secret: true}}, opts)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrInsecureDir)
+	})
+}
+
+func TestWriteEnvLines_Order(t *testing.T) {
+	t.Parallel()
+
+	in := []spec.Var{{Name: "Z", Value: "1"}, {Name: "A", Value: "$Z"}, {Name: "M", Value: "2"}}
+
+	t.Run("Sorted", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		require.NoError(t, WriteEnvLines(&buf, in, Options{Order: OrderSorted}))
+		assert.Equal(t, "A=$Z\nM=2\nZ=1\n", buf.String())
+		assert.Equal(t, "Z", in[0].Name, "input must not be reordered")
+	})
+
+	t.Run("Declared", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		require.NoError(t, WriteEnvLines(&buf, in, Options{Order: OrderDeclared}))
+		assert.Equal(t, "Z=1\nA=$Z\nM=2\n", buf.String())
+	})
+
+	t.Run("Declared tfvars-json", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		require.NoError(t, WriteEnvLines(&buf, in, Options{Format: FormatTFVarsJSON, Order: OrderDeclared}))
+		assert.Equal(t, "{\n  \"Z\": \"1\",\n  \"A\": \"$Z\",\n  \"M\": \"2\"\n}\n", buf.String())
+	})
+
+	t.Run("Declared merge appends in order", func(t *testing.T) {
+		t.Parallel()
+		got, err := Merge([]byte("A=old\n"), in, Options{Order: OrderDeclared})
+		require.NoError(t, err)
+		assert.Equal(t, "A=$Z\nZ=1\nM=2\n", string(got))
+	})
+}
@@ -13,8 +13,43 @@ const (
 	QuoteSingle QuoteKind = "single" // '…' POSIX style
 	QuoteDouble QuoteKind = "double" // "…" with escaping
 	QuoteJSON   QuoteKind = "json"   // JSON-encode string
+	QuoteFish   QuoteKind = "fish"   // '…' fish style
+	QuotePwsh   QuoteKind = "pwsh"   // '…' PowerShell style
+	QuoteCsh    QuoteKind = "csh"    // '…' csh/tcsh style
+	QuoteCmd    QuoteKind = "cmd"    // caret-escaped cmd.exe batch style
 )
 
+// Shell is the dialect of the emitted assignment lines.
+type Shell string
+
+const (
+	ShellPOSIX Shell = "posix" // KEY=VALUE / export KEY=VALUE
+	ShellFish  Shell = "fish"  // set -gx KEY VALUE
+	ShellPwsh  Shell = "pwsh"  // $env:KEY = VALUE
+	ShellCsh   Shell = "csh"   // setenv KEY VALUE
+	ShellCmd   Shell = "cmd"   // set KEY=VALUE
+)
+
+// Shells lists all supported shell dialects.
+var Shells = []Shell{ShellPOSIX, ShellFish, ShellPwsh, ShellCsh, ShellCmd}
+
+// QuoteKind returns the quoting every value must use for the shell.
+// POSIX shells have no fixed quoting and return "".
+func (s Shell) QuoteKind() QuoteKind {
+	switch s {
+	case ShellFish:
+		return QuoteFish
+	case ShellPwsh:
+		return QuotePwsh
+	case ShellCsh:
+		return QuoteCsh
+	case ShellCmd:
+		return QuoteCmd
+	default:
+		return ""
+	}
+}
+
 // QuoteValue applies the quoting/encoding to v.
 func QuoteValue(v string, kind QuoteKind) string {
 	switch kind {
@@ -39,7 +74,48 @@ func QuoteValue(v string, kind QuoteKind) string {
 	case QuoteJSON:
 		b, _ := json.Marshal(v)
 		return string(b)
+	case QuoteFish:
+		// Inside fish single quotes only \\ and \' are escapes.
+		return "'" + fishReplacer.Replace(v) + "'"
+	case QuotePwsh:
+		// PowerShell doubles single quotes, including the typographic variants
+		// it treats as single quotes.
+		return "'" + pwshReplacer.Replace(v) + "'"
+	case QuoteCsh:
+		// csh single quotes are literal except for history (!) and newlines.
+		return "'" + cshReplacer.Replace(v) + "'"
+	case QuoteCmd:
+		// set KEY=VALUE takes the rest of the line; escape metacharacters with
+		// ^ and double % for batch files. Delayed expansion (!) is not handled.
+		return cmdReplacer.Replace(v)
 	default:
 		return v
 	}
 }
+
+var (
+	fishReplacer = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	pwshReplacer = strings.NewReplacer(
+		"'", "''",
+		"\u2018", "\u2018\u2018",
+		"\u2019", "\u2019\u2019",
+		"\u201A", "\u201A\u201A",
+		"\u201B", "\u201B\u201B",
+	)
+	cshReplacer = strings.NewReplacer(
+		`'`, `'\''`,
+		`!`, `'\!'`,
+		"\n", "\\\n",
+	)
+	cmdReplacer = strings.NewReplacer(
+		`^`, `^^`,
+		`&`, `^&`,
+		`|`, `^|`,
+		`<`, `^<`,
+		`>`, `^>`,
+		`(`, `^(`,
+		`)`, `^)`,
+		`"`, `^"`,
+		`%`, `%%`,
+	)
+)
@@ -0,0 +1,247 @@
+package quote
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The unquote* helpers below implement each dialect's parsing rules for the
+// quoted forms QuoteValue produces. The fuzz tests check that every value
+// survives a round trip through them.
+
+// unquotePOSIXSingle parses a sequence of '…' runs joined by \' as sh does.
+func unquotePOSIXSingle(t *testing.T, s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		switch {
+		case s[0] == '\'':
+			end := strings.IndexByte(s[1:], '\'')
+			require.GreaterOrEqual(t, end, 0, "unterminated single quote")
+			b.WriteString(s[1 : 1+end])
+			s = s[end+2:]
+		case strings.HasPrefix(s, `\`) && len(s) > 1:
+			b.WriteByte(s[1])
+			s = s[2:]
+		default:
+			t.Fatalf("unexpected unquoted text %q", s)
+		}
+	}
+	return b.String()
+}
+
+// unquotePOSIXDouble parses "…" where \ escapes \ " $ ` (and is literal otherwise).
+func unquotePOSIXDouble(t *testing.T, s string) string {
+	require.True(t, len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"')
+	inner := s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case c == '\\' && i+1 < len(inner) && strings.IndexByte("\\\"$`", inner[i+1]) >= 0:
+			b.WriteByte(inner[i+1])
+			i++
+		case c == '"' || c == '$' || c == '`':
+			t.Fatalf("unescaped %q in double-quoted value %q", c, s)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// unquoteFish parses fish '…' where only \\ and \' are escapes.
+func unquoteFish(t *testing.T, s string) string {
+	require.True(t, len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'')
+	inner := s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case c == '\\' && i+1 < len(inner) && (inner[i+1] == '\\' || inner[i+1] == '\''):
+			b.WriteByte(inner[i+1])
+			i++
+		case c == '\'':
+			t.Fatalf("unescaped quote in fish value %q", s)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// isPwshQuote reports whether r terminates a PowerShell single-quoted string.
+func isPwshQuote(r rune) bool {
+	return r == '\'' || r == '‘' || r == '’' || r == '‚' || r == '‛'
+}
+
+// unquotePwsh parses PowerShell '…' where a doubled quote character is an escape.
+func unquotePwsh(t *testing.T, s string) string {
+	require.True(t, len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'')
+	inner := s[1 : len(s)-1]
+	var b strings.Builder
+	for len(inner) > 0 {
+		r, n := utf8.DecodeRuneInString(inner)
+		if isPwshQuote(r) {
+			r2, n2 := utf8.DecodeRuneInString(inner[n:])
+			require.True(t, n2 > 0 && isPwshQuote(r2), "unescaped quote in pwsh value %q", s)
+			b.WriteRune(r2)
+			inner = inner[n+n2:]
+			continue
+		}
+		b.WriteString(inner[:n])
+		inner = inner[n:]
+	}
+	return b.String()
+}
+
+// unquoteCsh parses csh words made of '…' runs and backslash escapes outside
+// quotes. Inside quotes, a backslash only escapes a newline.
+func unquoteCsh(t *testing.T, s string) string {
+	var b strings.Builder
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			inQuote = !inQuote
+		case c == '\\' && i+1 < len(s) && (!inQuote || s[i+1] == '\n'):
+			b.WriteByte(s[i+1])
+			i++
+		case c == '!' || (c == '\n' && !inQuote):
+			t.Fatalf("unescaped %q in csh value %q", c, s)
+		case c == '\n' && inQuote:
+			t.Fatalf("unescaped newline in csh value %q", s)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	require.False(t, inQuote, "unterminated quote in csh value %q", s)
+	return b.String()
+}
+
+// unquoteCmd parses the right-hand side of a batch "set KEY=VALUE" line:
+// ^ escapes the next character and %% is a literal percent sign.
+func unquoteCmd(t *testing.T, s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '^':
+			require.Less(t, i+1, len(s), "trailing caret in cmd value %q", s)
+			b.WriteByte(s[i+1])
+			i++
+		case c == '%':
+			require.True(t, i+1 < len(s) && s[i+1] == '%', "single percent in cmd value %q", s)
+			b.WriteByte('%')
+			i++
+		case strings.IndexByte(`&|<>()"`, c) >= 0:
+			t.Fatalf("unescaped %q in cmd value %q", c, s)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// fuzzSeeds are values that exercise every dialect's special characters.
+var fuzzSeeds = []string{
+	"", "plain", "with space", `it's`, `"double"`, `back\slash`, `trailing\`,
+	"$HOME", "`cmd`", "!bang", "line1\nline2", "tab\tchar", "100%", "a^b&c|d<e>f(g)",
+	"‘typographic’ ‚quotes‛", `\'`, "'''", `%%`, "ünïcödé",
+}
+
+func FuzzQuoteValue_RoundTrip(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, v string) {
+		if !utf8.ValidString(v) {
+			t.Skip()
+		}
+		assert.Equal(t, v, unquotePOSIXSingle(t, QuoteValue(v, QuoteSingle)), "single")
+		assert.Equal(t, v, unquotePOSIXDouble(t, QuoteValue(v, QuoteDouble)), "double")
+		assert.Equal(t, v, unquoteFish(t, QuoteValue(v, QuoteFish)), "fish")
+		assert.Equal(t, v, unquotePwsh(t, QuoteValue(v, QuotePwsh)), "pwsh")
+		assert.Equal(t, v, unquoteCsh(t, QuoteValue(v, QuoteCsh)), "csh")
+		if !strings.ContainsAny(v, "\r\n") { // cmd cannot represent newlines
+			assert.Equal(t, v, unquoteCmd(t, QuoteValue(v, QuoteCmd)), "cmd")
+		}
+	})
+}
+
+func TestQuoteValue_Dialects(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		kind QuoteKind
+		want string
+	}{
+		{name: "Fish empty", in: "", kind: QuoteFish, want: `''`},
+		{name: "Fish escapes quote and backslash", in: `it's a\b`, kind: QuoteFish, want: `'it\'s a\\b'`},
+		{name: "Fish keeps dollar", in: "$HOME", kind: QuoteFish, want: `'$HOME'`},
+		{name: "Pwsh doubles quote", in: "it's", kind: QuotePwsh, want: `'it''s'`},
+		{name: "Pwsh doubles typographic quote", in: "it’s", kind: QuotePwsh, want: "'it’’s'"},
+		{name: "Pwsh keeps dollar and backtick", in: "$x`n", kind: QuotePwsh, want: "'$x`n'"},
+		{name: "Csh escapes quote", in: "it's", kind: QuoteCsh, want: `'it'\''s'`},
+		{name: "Csh escapes history", in: "a!b", kind: QuoteCsh, want: `'a'\!'b'`},
+		{name: "Csh escapes newline", in: "a\nb", kind: QuoteCsh, want: "'a\\\nb'"},
+		{name: "Cmd escapes metacharacters", in: `a&b|c "d" ^`, kind: QuoteCmd, want: `a^&b^|c ^"d^" ^^`},
+		{name: "Cmd doubles percent", in: "100%", kind: QuoteCmd, want: "100%%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, QuoteValue(tt.in, tt.kind))
+		})
+	}
+}
+
+func TestShell_QuoteKind(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, QuoteKind(""), ShellPOSIX.QuoteKind())
+	assert.Equal(t, QuoteFish, ShellFish.QuoteKind())
+	assert.Equal(t, QuotePwsh, ShellPwsh.QuoteKind())
+	assert.Equal(t, QuoteCsh, ShellCsh.QuoteKind())
+	assert.Equal(t, QuoteCmd, ShellCmd.QuoteKind())
+}
+
+// TestQuoteValue_RealShells evaluates quoted values with the shells found on
+// PATH and checks that the shell sees the original value.
+func TestQuoteValue_RealShells(t *testing.T) {
+	t.Parallel()
+
+	shells := []struct {
+		bin    string
+		kind   QuoteKind
+		script func(q string) string
+	}{
+		{bin: "sh", kind: QuoteSingle, script: func(q string) string { return "V=" + q + "; printf '%s' \"$V\"" }},
+		{bin: "sh", kind: QuoteDouble, script: func(q string) string { return "V=" + q + "; printf '%s' \"$V\"" }},
+		{bin: "fish", kind: QuoteFish, script: func(q string) string { return "set -gx V " + q + "; printf '%s' \"$V\"" }},
+		{bin: "csh", kind: QuoteCsh, script: func(q string) string { return "setenv V " + q + "\nprintenv V | tr -d '\\n'" }},
+		{bin: "pwsh", kind: QuotePwsh, script: func(q string) string { return "$env:V = " + q + "; [Console]::Write($env:V)" }},
+	}
+
+	values := []string{"plain", "with space", `it's`, `"double"`, `back\slash`, "$HOME", "`x`", "100%", "‘x’"}
+
+	for _, sh := range shells {
+		bin, err := exec.LookPath(sh.bin)
+		if err != nil {
+			continue
+		}
+		for _, v := range values {
+			out, err := exec.Command(bin, "-c", sh.script(QuoteValue(v, sh.kind))).Output()
+			require.NoError(t, err, "%s %s %q", sh.bin, sh.kind, v)
+			assert.Equal(t, v, string(out), "%s %s", sh.bin, sh.kind)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package redact keeps secret values out of diagnostics.
+package redact
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Placeholder is printed instead of a secret value.
+const Placeholder = "***"
+
+// Value returns v, or Placeholder if secret is set.
+func Value(v string, secret bool) string {
+	if secret {
+		return Placeholder
+	}
+	return v
+}
+
+// Redactor replaces known secret values in messages. The zero value is
+// ready to use.
+type Redactor struct {
+	secrets []string // longest first, so overlapping secrets are fully hidden
+}
+
+// Add registers a secret value. Its Go-quoted form is registered as well,
+// because messages usually print values with %q.
+func (r *Redactor) Add(v string) {
+	if v == "" {
+		return
+	}
+	r.secrets = append(r.secrets, v)
+	if q := strconv.Quote(v); q[1:len(q)-1] != v {
+		r.secrets = append(r.secrets, q[1:len(q)-1])
+	}
+	sort.SliceStable(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+}
+
+// String returns s with all registered secrets replaced by Placeholder.
+func (r *Redactor) String(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Placeholder)
+	}
+	return s
+}
+
+// Error returns err with all registered secrets replaced in its message.
+// The result still unwraps to err.
+func (r *Redactor) Error(err error) error {
+	if err == nil || len(r.secrets) == 0 {
+		return err
+	}
+	msg := r.String(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
+
+// redactedError is an error whose message was redacted.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
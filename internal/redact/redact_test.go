@@ -0,0 +1,71 @@
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValue(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "v", Value("v", false))
+	assert.Equal(t, Placeholder, Value("v", true))
+}
+
+func TestRedactor(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Zero value keeps errors", func(t *testing.T) {
+		t.Parallel()
+		var r Redactor
+		err := errors.New("hunter2")
+		assert.Same(t, err, r.Error(err))
+		assert.NoError(t, r.Error(nil))
+	})
+
+	t.Run("Replaces secrets", func(t *testing.T) {
+		t.Parallel()
+		var r Redactor
+		r.Add("hunter2")
+		r.Add("")
+		assert.Equal(t, `got "***" and *** twice`, r.String(`got "hunter2" and hunter2 twice`))
+	})
+
+	t.Run("Longest secret first", func(t *testing.T) {
+		t.Parallel()
+		var r Redactor
+		r.Add("pass")
+		r.Add("password")
+		assert.Equal(t, "***", r.String("password"))
+	})
+
+	t.Run("Quoted form", func(t *testing.T) {
+		t.Parallel()
+		var r Redactor
+		r.Add("line1\nline2")
+		assert.Equal(t, `value "***"`, r.String(fmt.Sprintf("value %q", "line1\nline2")))
+	})
+
+	t.Run("Error keeps chain", func(t *testing.T) {
+		t.Parallel()
+		var r Redactor
+		r.Add("s3cret")
+		err := r.Error(fmt.Errorf("reading s3cret: %w", fs.ErrNotExist))
+		require.Error(t, err)
+		assert.EqualError(t, err, "reading ***: file does not exist")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("Unchanged error is returned as is", func(t *testing.T) {
+		t.Parallel()
+		var r Redactor
+		r.Add("s3cret")
+		err := errors.New("nothing to hide")
+		assert.Same(t, err, r.Error(err))
+	})
+}
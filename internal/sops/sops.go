@@ -0,0 +1,348 @@
+// Package sops decrypts documents encrypted with SOPS using local age
+// identities. Decryption happens in memory and the plaintext is returned in
+// the syntax of the document, as "sops --decrypt" would write it.
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gi8lino/unveil/internal/decrypt"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// Format is the syntax of a SOPS document.
+type Format string
+
+const (
+	FormatYAML   Format = "yaml"
+	FormatJSON   Format = "json"
+	FormatDotenv Format = "dotenv"
+	FormatINI    Format = "ini"
+)
+
+// KeyFileEnv names the environment variable holding the age identity file.
+const KeyFileEnv = "SOPS_AGE_KEY_FILE"
+
+// encPrefix starts every value encrypted by SOPS.
+const encPrefix = "ENC[AES256_GCM,"
+
+// encPattern splits an encrypted value into its parts.
+var encPattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// macOnlyEncryptedInit starts the MAC of documents with mac_only_encrypted set.
+var macOnlyEncryptedInit = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0xb, 0xb, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+
+// KeyFile returns the age identity file SOPS uses: $SOPS_AGE_KEY_FILE, or
+// sops/age/keys.txt in the user configuration directory.
+func KeyFile() string {
+	if path := os.Getenv(KeyFileEnv); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sops", "age", "keys.txt")
+}
+
+// Encrypted reports whether data is a SOPS document of format f.
+func Encrypted(f Format, data []byte) bool {
+	_, meta, err := load(f, data)
+	return err == nil && meta != nil
+}
+
+// Decrypt returns data decrypted with the age identities in keyFile if it is
+// a SOPS document of format f, and data unchanged otherwise. The MAC of the
+// document is verified. Encrypted comments are removed.
+func Decrypt(f Format, data []byte, keyFile string) ([]byte, error) {
+	doc, meta, err := load(f, data)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return data, nil
+	}
+
+	key, err := dataKey(meta, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	d := &decrypter{key: key, hash: sha512.New(), macOnlyEncrypted: meta.MACOnlyEncrypted}
+	if d.macOnlyEncrypted {
+		d.hash.Write(macOnlyEncryptedInit)
+	}
+	plain, err := doc.decrypt(d)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.verify(meta); err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// document is a SOPS document of one format.
+type document interface {
+	// metadata returns the sops metadata, or nil if the document has none.
+	metadata() (*metadata, error)
+	// decrypt passes every value in document order to d and returns the
+	// document without metadata.
+	decrypt(d *decrypter) ([]byte, error)
+}
+
+// metadata is the part of the sops metadata needed for decryption.
+type metadata struct {
+	Age              []ageRecipient `json:"age" yaml:"age"`
+	LastModified     string         `json:"lastmodified" yaml:"lastmodified"`
+	MAC              string         `json:"mac" yaml:"mac"`
+	MACOnlyEncrypted bool           `json:"mac_only_encrypted" yaml:"mac_only_encrypted"`
+}
+
+// ageRecipient holds the data key encrypted for one age recipient.
+type ageRecipient struct {
+	Recipient string `json:"recipient" yaml:"recipient"`
+	Enc       string `json:"enc" yaml:"enc"`
+}
+
+// flatRecipient matches the flattened age keys of dotenv and INI metadata.
+var flatRecipient = regexp.MustCompile(`^age__list_(\d+)__map_(recipient|enc)$`)
+
+// load parses data as a document of format f and returns its metadata, or
+// nil metadata if data is not a SOPS document.
+func load(f Format, data []byte) (document, *metadata, error) {
+	if !bytes.Contains(data, []byte(encPrefix)) {
+		return nil, nil, nil
+	}
+	var doc document
+	switch f {
+	case FormatYAML:
+		doc = loadYAML(data)
+	case FormatJSON:
+		doc = loadJSON(data)
+	case FormatDotenv:
+		doc = loadDotenv(data)
+	case FormatINI:
+		doc = loadINI(data)
+	default:
+		return nil, nil, fmt.Errorf("unsupported format %q", f)
+	}
+	meta, err := doc.metadata()
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, meta, nil
+}
+
+// flatMetadata reads metadata flattened into key=value pairs, like dotenv and
+// INI documents store it. It returns nil without a MAC.
+func flatMetadata(values map[string]string) (*metadata, error) {
+	if values["mac"] == "" {
+		return nil, nil
+	}
+	meta := &metadata{LastModified: values["lastmodified"], MAC: values["mac"]}
+	if v := values["mac_only_encrypted"]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mac_only_encrypted %q", v)
+		}
+		meta.MACOnlyEncrypted = b
+	}
+	for k, v := range values {
+		m := flatRecipient.FindStringSubmatch(k)
+		if m == nil {
+			continue
+		}
+		i, err := strconv.Atoi(m[1])
+		if err != nil || i > len(values) {
+			return nil, fmt.Errorf("invalid metadata key %q", k)
+		}
+		for len(meta.Age) <= i {
+			meta.Age = append(meta.Age, ageRecipient{})
+		}
+		if m[2] == "enc" {
+			meta.Age[i].Enc = v
+		} else {
+			meta.Age[i].Recipient = v
+		}
+	}
+	return meta, nil
+}
+
+// dataKey decrypts the data key of the document with the identities in keyFile.
+func dataKey(meta *metadata, keyFile string) ([]byte, error) {
+	if len(meta.Age) == 0 {
+		return nil, errors.New("no age recipients: only age keys are supported")
+	}
+	if keyFile == "" {
+		return nil, fmt.Errorf("no age identity file: set %s", KeyFileEnv)
+	}
+	ids, err := decrypt.Identities(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range meta.Age {
+		rd, err := age.Decrypt(armor.NewReader(strings.NewReader(r.Enc)), ids...)
+		if err != nil {
+			var noMatch *age.NoIdentityMatchError
+			if errors.As(err, &noMatch) {
+				continue
+			}
+			return nil, fmt.Errorf("decrypting data key for %s: %w", r.Recipient, err)
+		}
+		key, err := io.ReadAll(rd)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting data key for %s: %w", r.Recipient, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("data key for %s has %d bytes, want 32", r.Recipient, len(key))
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("no identity in %s matches the age recipients of the document", keyFile)
+}
+
+// decrypter decrypts the values of a document and computes its MAC.
+type decrypter struct {
+	key              []byte
+	hash             hash.Hash
+	macOnlyEncrypted bool
+}
+
+// encrypted reports whether s is a value encrypted by SOPS.
+func encrypted(s string) bool {
+	return strings.HasPrefix(s, encPrefix)
+}
+
+// decrypt decrypts the value s found at path and adds it to the MAC. It
+// returns the plaintext and its sops type: str, int, float, bool, bytes, time
+// or comment. Booleans are returned as true or false.
+func (d *decrypter) decrypt(s string, path []string) (string, string, error) {
+	text, typ, err := open(s, d.key, strings.Join(path, ":")+":")
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+	}
+	var v any
+	switch typ {
+	case "str", "bytes":
+		v = text
+	case "int":
+		v, err = strconv.Atoi(text)
+	case "float":
+		var f float64
+		f, err = strconv.ParseFloat(text, 64)
+		v = f
+	case "bool":
+		var b bool
+		b, err = strconv.ParseBool(text)
+		v, text = b, strconv.FormatBool(b)
+	case "time":
+		var t time.Time
+		err = t.UnmarshalText([]byte(text))
+		v = t
+	case "comment":
+		return text, typ, nil
+	default:
+		err = fmt.Errorf("unknown type %q", typ)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+	}
+	return text, typ, d.write(v)
+}
+
+// add adds a value that is not encrypted to the MAC; nil values are skipped.
+func (d *decrypter) add(v any) error {
+	if d.macOnlyEncrypted || v == nil {
+		return nil
+	}
+	return d.write(v)
+}
+
+// write adds v to the MAC in the form SOPS hashes it.
+func (d *decrypter) write(v any) error {
+	var b []byte
+	switch v := v.(type) {
+	case string:
+		b = []byte(v)
+	case int:
+		b = []byte(strconv.Itoa(v))
+	case float64:
+		b = []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		b = []byte("False")
+		if v {
+			b = []byte("True")
+		}
+	case time.Time:
+		var err error
+		if b, err = v.MarshalText(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported value type %T", v)
+	}
+	d.hash.Write(b)
+	return nil
+}
+
+// verify compares the MAC of the decrypted values with the one in meta.
+func (d *decrypter) verify(meta *metadata) error {
+	modified, err := time.Parse(time.RFC3339, meta.LastModified)
+	if err != nil {
+		return fmt.Errorf("invalid lastmodified %q", meta.LastModified)
+	}
+	mac, _, err := open(meta.MAC, d.key, modified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("decrypting MAC: %w", err)
+	}
+	if fmt.Sprintf("%X", d.hash.Sum(nil)) != mac {
+		return errors.New("MAC mismatch: the document was modified after encryption")
+	}
+	return nil
+}
+
+// open decrypts the encrypted value s with AES-GCM, authenticating aad.
+func open(s string, key []byte, aad string) (string, string, error) {
+	m := encPattern.FindStringSubmatch(s)
+	if m == nil {
+		return "", "", errors.New("malformed encrypted value")
+	}
+	var parts [3][]byte
+	for i, part := range m[1:4] {
+		b, err := base64.StdEncoding.DecodeString(part)
+		if err != nil {
+			return "", "", errors.New("malformed encrypted value")
+		}
+		parts[i] = b
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(aad))
+	if err != nil {
+		return "", "", errors.New("authentication failed: wrong key or modified value")
+	}
+	return string(plain), m[4], nil
+}
@@ -0,0 +1,163 @@
+package sops
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keyFile holds the age identity the fixtures in testdata were encrypted for.
+// It was generated for these tests only.
+const keyFile = "testdata/key.txt"
+
+// fixture returns the content of a file in testdata.
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return data
+}
+
+func TestDecrypt(t *testing.T) {
+	t.Parallel()
+
+	t.Run("YAML", func(t *testing.T) {
+		t.Parallel()
+		out, err := Decrypt(FormatYAML, fixture(t, "app.enc.yaml"), keyFile)
+		require.NoError(t, err)
+		assert.Equal(t, `db:
+    host: db.internal
+    port: 5432
+    password: hunter2
+    ratio: 0.5
+    enabled: true
+certs:
+    - name: api
+      pem: |
+        line one
+        line two
+empty: ""
+nothing: null
+region_unencrypted: eu-west-1
+`, string(out))
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		t.Parallel()
+		out, err := Decrypt(FormatJSON, fixture(t, "app.enc.json"), keyFile)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"db": {"host": "db.internal", "port": 5432, "password": "hunter2", "ratio": 0.5, "enabled": true},
+			"servers": [{"name": "a", "token": "tok-a"}, "plain"],
+			"nothing": null
+		}`, string(out))
+	})
+
+	t.Run("Dotenv", func(t *testing.T) {
+		t.Parallel()
+		out, err := Decrypt(FormatDotenv, fixture(t, "app.enc.env"), keyFile)
+		require.NoError(t, err)
+		assert.Equal(t, "\nDB_PASSWORD=hunter2\nGREETING=hello world\nMULTI=line1\\nline2\n", string(out))
+	})
+
+	t.Run("INI", func(t *testing.T) {
+		t.Parallel()
+		out, err := Decrypt(FormatINI, fixture(t, "app.enc.ini"), keyFile)
+		require.NoError(t, err)
+		assert.Equal(t, "name = app\n\n[database]\npassword = hunter2\nport     = 5432\n", string(out))
+	})
+
+	t.Run("Plain documents unchanged", func(t *testing.T) {
+		t.Parallel()
+		for f, data := range map[Format]string{
+			FormatYAML:   "a: ENC[AES256_GCM,data:x]\n",
+			FormatJSON:   `{"a": "ENC[AES256_GCM,data:x]"}`,
+			FormatDotenv: "A=ENC[AES256_GCM,data:x]\n",
+			FormatINI:    "a = ENC[AES256_GCM,data:x]\n",
+		} {
+			out, err := Decrypt(f, []byte(data), "")
+			require.NoError(t, err, f)
+			assert.Equal(t, data, string(out), f)
+		}
+	})
+
+	t.Run("MAC of encrypted values only", func(t *testing.T) {
+		t.Parallel()
+		data := bytes.Replace(fixture(t, "mac-only.enc.yaml"), []byte("db.internal"), []byte("db.example"), 1)
+		out, err := Decrypt(FormatYAML, data, keyFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(out), "host: db.example\n")
+		assert.Contains(t, string(out), "password: hunter2\n")
+	})
+
+	t.Run("Modified value", func(t *testing.T) {
+		t.Parallel()
+		data := bytes.Replace(fixture(t, "app.enc.yaml"), []byte("eu-west-1"), []byte("us-east-1"), 1)
+		_, err := Decrypt(FormatYAML, data, keyFile)
+		require.Error(t, err)
+		assert.EqualError(t, err, "MAC mismatch: the document was modified after encryption")
+	})
+
+	t.Run("Moved value", func(t *testing.T) {
+		t.Parallel()
+		lines := strings.Split(string(fixture(t, "app.enc.env")), "\n")
+		_, greeting, _ := strings.Cut(lines[2], "=")
+		lines[1] = "DB_PASSWORD=" + greeting
+		_, err := Decrypt(FormatDotenv, []byte(strings.Join(lines, "\n")), keyFile)
+		require.Error(t, err)
+		assert.EqualError(t, err, "DB_PASSWORD: authentication failed: wrong key or modified value")
+	})
+
+	t.Run("Other identity", func(t *testing.T) {
+		t.Parallel()
+		id, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		other := filepath.Join(t.TempDir(), "keys.txt")
+		require.NoError(t, os.WriteFile(other, []byte(id.String()+"\n"), 0o600))
+
+		_, err = Decrypt(FormatYAML, fixture(t, "app.enc.yaml"), other)
+		require.Error(t, err)
+		assert.EqualError(t, err, "no identity in "+other+" matches the age recipients of the document")
+	})
+
+	t.Run("Missing identity file", func(t *testing.T) {
+		t.Parallel()
+		_, err := Decrypt(FormatJSON, fixture(t, "app.enc.json"), filepath.Join(t.TempDir(), "missing.txt"))
+		require.Error(t, err)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("No identity file", func(t *testing.T) {
+		t.Parallel()
+		_, err := Decrypt(FormatINI, fixture(t, "app.enc.ini"), "")
+		require.Error(t, err)
+		assert.EqualError(t, err, "no age identity file: set SOPS_AGE_KEY_FILE")
+	})
+
+	t.Run("Unsupported format", func(t *testing.T) {
+		t.Parallel()
+		_, err := Decrypt("toml", fixture(t, "app.enc.yaml"), keyFile)
+		require.Error(t, err)
+		assert.EqualError(t, err, `unsupported format "toml"`)
+	})
+}
+
+func TestKeyFile(t *testing.T) {
+	t.Run("From environment", func(t *testing.T) {
+		t.Setenv(KeyFileEnv, "/run/secrets/age.txt")
+		assert.Equal(t, "/run/secrets/age.txt", KeyFile())
+	})
+
+	t.Run("Default location", func(t *testing.T) {
+		t.Setenv(KeyFileEnv, "")
+		t.Setenv("XDG_CONFIG_HOME", "/home/app/.config")
+		assert.Equal(t, "/home/app/.config/sops/age/keys.txt", KeyFile())
+	})
+}
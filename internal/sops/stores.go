@@ -0,0 +1,389 @@
+package sops
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+)
+
+// metadataKey is the key of the sops metadata in YAML and JSON documents, the
+// section in INI documents and the key prefix (plus "_") in dotenv documents.
+const metadataKey = "sops"
+
+// yamlDocument is a stream of YAML documents; the first one holds the metadata.
+type yamlDocument struct {
+	docs []*yaml.Node
+}
+
+// loadYAML decodes data. Invalid YAML has no documents and so no metadata.
+func loadYAML(data []byte) document {
+	var doc yamlDocument
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var n yaml.Node
+		if err := dec.Decode(&n); err != nil {
+			if !errors.Is(err, io.EOF) {
+				doc.docs = nil
+			}
+			return doc
+		}
+		doc.docs = append(doc.docs, &n)
+	}
+}
+
+func (y yamlDocument) metadata() (*metadata, error) {
+	if len(y.docs) == 0 {
+		return nil, nil
+	}
+	_, n := yamlMetadata(y.docs[0])
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	var meta metadata
+	if err := n.Decode(&meta); err != nil {
+		return nil, fmt.Errorf("invalid sops metadata: %w", err)
+	}
+	if meta.MAC == "" {
+		return nil, nil
+	}
+	return &meta, nil
+}
+
+// yamlMetadata returns the index of the metadata key in the root mapping of
+// doc and its value, or -1 and nil.
+func yamlMetadata(doc *yaml.Node) (int, *yaml.Node) {
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return -1, nil
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == metadataKey {
+			return i, root.Content[i+1]
+		}
+	}
+	return -1, nil
+}
+
+func (y yamlDocument) decrypt(d *decrypter) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	for _, doc := range y.docs {
+		if i, _ := yamlMetadata(doc); i >= 0 {
+			root := doc.Content[0]
+			root.Content = append(root.Content[:i], root.Content[i+2:]...)
+		}
+		if err := yamlDecrypt(d, doc, nil); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlTags are the tags of decrypted values by sops type.
+var yamlTags = map[string]string{
+	"str":   "!!str",
+	"bytes": "!!str",
+	"int":   "!!int",
+	"float": "!!float",
+	"bool":  "!!bool",
+	"time":  "!!timestamp",
+}
+
+// yamlDecrypt decrypts the scalars of n in place. List items share the path
+// of their list.
+func yamlDecrypt(d *decrypter, n *yaml.Node, path []string) error {
+	n.HeadComment = plainComments(n.HeadComment)
+	n.LineComment = plainComments(n.LineComment)
+	n.FootComment = plainComments(n.FootComment)
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			if err := yamlDecrypt(d, c, path); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			key.HeadComment = plainComments(key.HeadComment)
+			key.LineComment = plainComments(key.LineComment)
+			key.FootComment = plainComments(key.FootComment)
+			if err := yamlDecrypt(d, n.Content[i+1], append(path[:len(path):len(path)], key.Value)); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if n.ShortTag() == "!!str" && encrypted(n.Value) {
+			text, typ, err := d.decrypt(n.Value, path)
+			if err != nil {
+				return err
+			}
+			n.Value, n.Tag, n.Style = text, yamlTags[typ], 0
+			return nil
+		}
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return err
+		}
+		return d.add(v)
+	case yaml.AliasNode:
+		return fmt.Errorf("%s: aliases are not supported", strings.Join(path, "."))
+	}
+	return nil
+}
+
+// plainComments removes encrypted lines from a YAML comment.
+func plainComments(comment string) string {
+	if !strings.Contains(comment, encPrefix) {
+		return comment
+	}
+	var lines []string
+	for _, line := range strings.Split(comment, "\n") {
+		if !strings.Contains(line, encPrefix) {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// jsonDocument is a JSON object.
+type jsonDocument struct {
+	data []byte
+}
+
+func loadJSON(data []byte) document {
+	return jsonDocument{data: data}
+}
+
+func (j jsonDocument) metadata() (*metadata, error) {
+	var doc struct {
+		Sops *metadata `json:"sops"`
+	}
+	if err := json.Unmarshal(j.data, &doc); err != nil || doc.Sops == nil || doc.Sops.MAC == "" {
+		return nil, nil
+	}
+	return doc.Sops, nil
+}
+
+func (j jsonDocument) decrypt(d *decrypter) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(j.data))
+	dec.UseNumber()
+	var buf bytes.Buffer
+	if err := jsonDecrypt(d, dec, &buf, nil); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "\t"); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// jsonDecrypt copies the next value of dec to buf, decrypting its strings.
+// The metadata key of the root object is dropped.
+func jsonDecrypt(d *decrypter, dec *json.Decoder, buf *bytes.Buffer, path []string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		buf.WriteString(tok.String())
+		for n := 0; dec.More(); n++ {
+			if tok == '[' {
+				if n > 0 {
+					buf.WriteByte(',')
+				}
+				if err := jsonDecrypt(d, dec, buf, path); err != nil {
+					return err
+				}
+				continue
+			}
+			k, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := k.(string)
+			if path == nil && key == metadataKey {
+				var skip json.RawMessage
+				if err := dec.Decode(&skip); err != nil {
+					return err
+				}
+				n--
+				continue
+			}
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			b, _ := json.Marshal(key)
+			buf.Write(b)
+			buf.WriteByte(':')
+			if err := jsonDecrypt(d, dec, buf, append(path[:len(path):len(path)], key)); err != nil {
+				return err
+			}
+		}
+		end, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		buf.WriteString(end.(json.Delim).String())
+	case string:
+		if !encrypted(tok) {
+			b, _ := json.Marshal(tok)
+			buf.Write(b)
+			return d.add(tok)
+		}
+		text, typ, err := d.decrypt(tok, path)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case "int", "float", "bool":
+			buf.WriteString(text)
+		default:
+			b, _ := json.Marshal(text)
+			buf.Write(b)
+		}
+	case json.Number:
+		buf.WriteString(tok.String())
+		if i, err := tok.Int64(); err == nil {
+			return d.add(int(i))
+		}
+		f, err := tok.Float64()
+		if err != nil {
+			return err
+		}
+		return d.add(f)
+	case bool:
+		buf.WriteString(fmt.Sprint(tok))
+		return d.add(tok)
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+// dotenvDocument is a list of KEY=VALUE lines. Values escape newlines as \n.
+type dotenvDocument struct {
+	lines []string
+}
+
+func loadDotenv(data []byte) document {
+	return dotenvDocument{lines: strings.Split(string(data), "\n")}
+}
+
+func (e dotenvDocument) metadata() (*metadata, error) {
+	values := map[string]string{}
+	for _, line := range e.lines {
+		key, value, ok := strings.Cut(line, "=")
+		if name, meta := strings.CutPrefix(key, metadataKey+"_"); meta && ok && !strings.HasPrefix(line, "#") {
+			values[name] = strings.ReplaceAll(value, `\n`, "\n")
+		}
+	}
+	return flatMetadata(values)
+}
+
+func (e dotenvDocument) decrypt(d *decrypter) ([]byte, error) {
+	out := make([]string, 0, len(e.lines))
+	for i, line := range e.lines {
+		if line == "" || line[0] == '#' {
+			if strings.Contains(line, encPrefix) {
+				line = "" // keep the line numbers of the values
+			}
+			out = append(out, line)
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing '='", i+1)
+		}
+		if strings.HasPrefix(key, metadataKey+"_") {
+			continue
+		}
+		value = strings.ReplaceAll(value, `\n`, "\n")
+		if encrypted(value) {
+			text, _, err := d.decrypt(value, []string{key})
+			if err != nil {
+				return nil, err
+			}
+			value = text
+		} else if err := d.add(value); err != nil {
+			return nil, err
+		}
+		out = append(out, key+"="+strings.ReplaceAll(value, "\n", `\n`))
+	}
+	return []byte(strings.Join(out, "\n")), nil
+}
+
+// iniDocument is an INI file; the metadata is flattened into a section.
+type iniDocument struct {
+	file *ini.File
+}
+
+// loadINI loads data like SOPS does. Invalid INI has no metadata.
+func loadINI(data []byte) document {
+	file, err := ini.LoadSources(ini.LoadOptions{AllowNonUniqueSections: true}, data)
+	if err != nil {
+		return iniDocument{}
+	}
+	return iniDocument{file: file}
+}
+
+func (f iniDocument) metadata() (*metadata, error) {
+	if f.file == nil {
+		return nil, nil
+	}
+	section, err := f.file.GetSection(metadataKey)
+	if err != nil {
+		return nil, nil
+	}
+	values := map[string]string{}
+	for _, key := range section.Keys() {
+		values[key.Name()] = strings.ReplaceAll(key.Value(), `\n`, "\n")
+	}
+	return flatMetadata(values)
+}
+
+func (f iniDocument) decrypt(d *decrypter) ([]byte, error) {
+	f.file.DeleteSection(metadataKey)
+	for _, section := range f.file.Sections() {
+		if strings.Contains(section.Comment, encPrefix) {
+			section.Comment = ""
+		}
+		for _, key := range section.Keys() {
+			if strings.Contains(key.Comment, encPrefix) {
+				key.Comment = ""
+			}
+			value := key.Value()
+			if !encrypted(value) {
+				if err := d.add(value); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			text, _, err := d.decrypt(value, []string{section.Name(), key.Name()})
+			if err != nil {
+				return nil, err
+			}
+			key.SetValue(text)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := f.file.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
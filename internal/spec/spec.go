@@ -1,23 +1,68 @@
 package spec
 
-import "github.com/gi8lino/unveil/internal/quote"
+import (
+	"os"
+
+	"github.com/gi8lino/unveil/internal/fetch"
+	"github.com/gi8lino/unveil/internal/quote"
+	"github.com/gi8lino/unveil/internal/validate"
+)
 
 // Kind is the file/source type.
 type Kind string
 
 const (
-	KindJSON Kind = "json"
-	KindYAML Kind = "yaml"
-	KindTOML Kind = "toml"
-	KindINI  Kind = "ini"
-	KindFILE Kind = "file"
+	KindJSON  Kind = "json"
+	KindYAML  Kind = "yaml"
+	KindTOML  Kind = "toml"
+	KindINI   Kind = "ini"
+	KindFILE  Kind = "file"
+	KindVault Kind = "vault" // secret of a Vault KV engine; Path is its API path
+)
+
+// Decryption is how a source file is decrypted before it is parsed.
+type Decryption string
+
+const (
+	DecryptNone Decryption = ""    // read the file as is (SOPS files are still decrypted)
+	DecryptAge  Decryption = "age" // the whole file is encrypted with age
 )
 
 // ExtractSpec describes one extraction instruction.
 type ExtractSpec struct {
-	Kind  Kind            // source type
-	Path  string          // file path
-	Key   string          // selector/key/path inside file
-	Var   string          // destination env var name
-	Quote quote.QuoteKind // quoting mode for value
+	Kind     Kind            // source type
+	Path     string          // file path or http(s) URL
+	Key      string          // selector/key/path inside file
+	Var      string          // destination env var name
+	RawVar   string          // destination as given, if it was sanitized into Var
+	Quote    quote.QuoteKind // quoting mode for value
+	Literal  bool            // render scalars as written in the source
+	Rules    validate.Rules  // constraints checked after extraction
+	Secret   bool            // redact the value in diagnostics
+	Public   bool            // never secret, even if the file turns out to be SOPS-encrypted
+	Decrypt  Decryption      // decryption of the whole file
+	Identity string          // age identity file for DecryptAge and SOPS files
+	HTTP     fetch.Options   // request options if Path is a URL
+}
+
+// FilePath returns Path with environment variables expanded.
+func (s ExtractSpec) FilePath() string {
+	return os.ExpandEnv(s.Path)
+}
+
+// Remote reports whether Path is an http(s) URL.
+func (s ExtractSpec) Remote() bool {
+	return fetch.IsURL(s.FilePath())
+}
+
+// IdentityFile returns Identity with environment variables expanded.
+func (s ExtractSpec) IdentityFile() string {
+	return os.ExpandEnv(s.Identity)
+}
+
+// Var is an extracted variable ready to be written.
+type Var struct {
+	Name   string // destination env var name
+	Value  string // rendered and quoted value
+	Secret bool   // value must not be shown in diagnostics
 }
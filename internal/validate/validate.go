@@ -0,0 +1,165 @@
+package validate
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Type is the expected type of an extracted value.
+type Type string
+
+const (
+	TypeAny      Type = ""         // no type check
+	TypeString   Type = "string"   // any string
+	TypeInt      Type = "int"      // base-10 integer
+	TypeBool     Type = "bool"     // true/false/1/0 (strconv.ParseBool)
+	TypeDuration Type = "duration" // Go duration, e.g. 1m30s
+	TypeURL      Type = "url"      // absolute URL with scheme and host
+	TypeEmail    Type = "email"    // single RFC 5322 address
+	TypePort     Type = "port"     // TCP/UDP port (1-65535)
+)
+
+// Types lists all selectable types (used for flag choices).
+var Types = []Type{TypeString, TypeInt, TypeBool, TypeDuration, TypeURL, TypeEmail, TypePort}
+
+// Range is an inclusive numeric range for int and port values.
+type Range struct {
+	Min int64
+	Max int64
+}
+
+// Rules describes the constraints a value must satisfy.
+// The zero value accepts everything.
+type Rules struct {
+	Type     Type           // expected type
+	Range    *Range         // numeric bounds (int and port only)
+	Match    *regexp.Regexp // value must match
+	Enum     []string       // value must be one of
+	NonEmpty bool           // value must not be empty
+	MinLen   int            // minimum length in runes (0 disables)
+	MaxLen   int            // maximum length in runes (0 disables)
+}
+
+// Check validates v against all configured constraints.
+// The returned error never contains v, so callers decide whether to show it.
+func (r Rules) Check(v string) error {
+	if r.NonEmpty && v == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	if err := checkType(r.Type, r.Range, v); err != nil {
+		return err
+	}
+	n := utf8.RuneCountInString(v)
+	if r.MinLen > 0 && n < r.MinLen {
+		return fmt.Errorf("length %d is shorter than %d", n, r.MinLen)
+	}
+	if r.MaxLen > 0 && n > r.MaxLen {
+		return fmt.Errorf("length %d is longer than %d", n, r.MaxLen)
+	}
+	if r.Match != nil && !r.Match.MatchString(v) {
+		return fmt.Errorf("does not match %q", r.Match.String())
+	}
+	if len(r.Enum) > 0 && !slices.Contains(r.Enum, v) {
+		return fmt.Errorf("must be one of [%s]", strings.Join(r.Enum, ", "))
+	}
+	return nil
+}
+
+// ParseRange parses "MIN..MAX" into a Range. Either bound may be omitted.
+func ParseRange(s string) (*Range, error) {
+	lo, hi, ok := strings.Cut(s, "..")
+	if !ok {
+		return nil, fmt.Errorf("invalid range %q: expected MIN..MAX", s)
+	}
+	r := &Range{Min: math.MinInt64, Max: math.MaxInt64}
+	if lo != "" {
+		n, err := strconv.ParseInt(lo, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q: bad minimum %q", s, lo)
+		}
+		r.Min = n
+	}
+	if hi != "" {
+		n, err := strconv.ParseInt(hi, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q: bad maximum %q", s, hi)
+		}
+		r.Max = n
+	}
+	if r.Min > r.Max {
+		return nil, fmt.Errorf("invalid range %q: minimum is greater than maximum", s)
+	}
+	return r, nil
+}
+
+// checkType validates v against t and, for numeric types, against rng.
+func checkType(t Type, rng *Range, v string) error {
+	switch t {
+	case TypeAny, TypeString:
+		return nil
+	case TypeInt:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("not a valid int")
+		}
+		return checkRange(rng, n)
+	case TypeBool:
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("not a valid bool")
+		}
+	case TypeDuration:
+		if _, err := time.ParseDuration(v); err != nil {
+			return fmt.Errorf("not a valid duration")
+		}
+	case TypeURL:
+		u, err := url.Parse(v)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("not a valid url")
+		}
+	case TypeEmail:
+		a, err := mail.ParseAddress(v)
+		if err != nil || a.Address != v {
+			return fmt.Errorf("not a valid email")
+		}
+	case TypePort:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("not a valid port")
+		}
+		return checkRange(rng, n)
+	default:
+		return fmt.Errorf("unknown type %q", t)
+	}
+	return nil
+}
+
+// checkRange validates n against rng, if set.
+func checkRange(rng *Range, n int64) error {
+	if rng == nil {
+		return nil
+	}
+	if n < rng.Min || n > rng.Max {
+		return fmt.Errorf("out of range %s", rng)
+	}
+	return nil
+}
+
+// String renders the range as "MIN..MAX", omitting open bounds.
+func (r *Range) String() string {
+	var lo, hi string
+	if r.Min != math.MinInt64 {
+		lo = strconv.FormatInt(r.Min, 10)
+	}
+	if r.Max != math.MaxInt64 {
+		hi = strconv.FormatInt(r.Max, 10)
+	}
+	return lo + ".." + hi
+}
@@ -0,0 +1,104 @@
+package validate
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRules_Check(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		rules   Rules
+		value   string
+		wantErr string
+	}{
+		{name: "Zero rules accept anything", rules: Rules{}, value: ""},
+		{name: "String accepts anything", rules: Rules{Type: TypeString}, value: "x y"},
+		{name: "Int valid", rules: Rules{Type: TypeInt}, value: "-42"},
+		{name: "Int invalid", rules: Rules{Type: TypeInt}, value: "eighty", wantErr: "not a valid int"},
+		{name: "Int in range", rules: Rules{Type: TypeInt, Range: &Range{Min: 1, Max: 10}}, value: "10"},
+		{name: "Int out of range", rules: Rules{Type: TypeInt, Range: &Range{Min: 1, Max: 10}}, value: "11", wantErr: "out of range 1..10"},
+		{name: "Bool valid", rules: Rules{Type: TypeBool}, value: "true"},
+		{name: "Bool invalid", rules: Rules{Type: TypeBool}, value: "yes", wantErr: "not a valid bool"},
+		{name: "Duration valid", rules: Rules{Type: TypeDuration}, value: "1m30s"},
+		{name: "Duration invalid", rules: Rules{Type: TypeDuration}, value: "90", wantErr: "not a valid duration"},
+		{name: "URL valid", rules: Rules{Type: TypeURL}, value: "https://example.com/x"},
+		{name: "URL without host", rules: Rules{Type: TypeURL}, value: "example.com", wantErr: "not a valid url"},
+		{name: "Email valid", rules: Rules{Type: TypeEmail}, value: "alice@example.com"},
+		{name: "Email with display name", rules: Rules{Type: TypeEmail}, value: "Alice <alice@example.com>", wantErr: "not a valid email"},
+		{name: "Port valid", rules: Rules{Type: TypePort}, value: "8080"},
+		{name: "Port zero", rules: Rules{Type: TypePort}, value: "0", wantErr: "not a valid port"},
+		{name: "Port too large", rules: Rules{Type: TypePort}, value: "65536", wantErr: "not a valid port"},
+		{name: "Port word", rules: Rules{Type: TypePort}, value: "eighty", wantErr: "not a valid port"},
+		{name: "Port below range", rules: Rules{Type: TypePort, Range: &Range{Min: 1024, Max: 65535}}, value: "80", wantErr: "out of range 1024..65535"},
+		{name: "Match", rules: Rules{Match: regexp.MustCompile(`^v\d+$`)}, value: "v12"},
+		{name: "Match fails", rules: Rules{Match: regexp.MustCompile(`^v\d+$`)}, value: "12", wantErr: `does not match "^v\\d+$"`},
+		{name: "Enum", rules: Rules{Enum: []string{"dev", "prod"}}, value: "prod"},
+		{name: "Enum fails", rules: Rules{Enum: []string{"dev", "prod"}}, value: "test", wantErr: "must be one of [dev, prod]"},
+		{name: "Non-empty", rules: Rules{NonEmpty: true}, value: "", wantErr: "must not be empty"},
+		{name: "Min length", rules: Rules{MinLen: 3}, value: "ab", wantErr: "length 2 is shorter than 3"},
+		{name: "Max length", rules: Rules{MaxLen: 3}, value: "abcd", wantErr: "length 4 is longer than 3"},
+		{name: "Length counts runes", rules: Rules{MaxLen: 2}, value: "äö"},
+		{name: "Unknown type", rules: Rules{Type: Type("uuid")}, value: "x", wantErr: `unknown type "uuid"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.rules.Check(tt.value)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Closed range", func(t *testing.T) {
+		t.Parallel()
+		r, err := ParseRange("1024..65535")
+		require.NoError(t, err)
+		assert.Equal(t, &Range{Min: 1024, Max: 65535}, r)
+		assert.Equal(t, "1024..65535", r.String())
+	})
+
+	t.Run("Open bounds", func(t *testing.T) {
+		t.Parallel()
+		r, err := ParseRange("-5..")
+		require.NoError(t, err)
+		assert.Equal(t, int64(-5), r.Min)
+		assert.Equal(t, "-5..", r.String())
+	})
+
+	t.Run("Missing separator", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseRange("1-10")
+		require.Error(t, err)
+		assert.EqualError(t, err, `invalid range "1-10": expected MIN..MAX`)
+	})
+
+	t.Run("Bad bound", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseRange("a..10")
+		require.Error(t, err)
+		assert.EqualError(t, err, `invalid range "a..10": bad minimum "a"`)
+	})
+
+	t.Run("Inverted", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseRange("10..1")
+		require.Error(t, err)
+		assert.EqualError(t, err, `invalid range "10..1": minimum is greater than maximum`)
+	})
+}
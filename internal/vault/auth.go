@@ -0,0 +1,117 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// authToken returns the token for requests and whether it was obtained by
+// logging in.
+func (c *Client) authToken(ctx context.Context) (string, bool, error) {
+	cfg := c.cfg
+	switch {
+	case cfg.Token != "":
+		return cfg.Token, false, nil
+	case cfg.TokenFile != "":
+		token, err := readSecret(cfg.TokenFile, "token")
+		return token, false, err
+	case cfg.RoleID != "" || cfg.RoleIDFile != "":
+		token, err := c.login(ctx, cfg.AppRoleMount, func() (map[string]string, error) {
+			roleID, err := value(cfg.RoleID, cfg.RoleIDFile, "role ID")
+			if err != nil {
+				return nil, err
+			}
+			secretID, err := value(cfg.SecretID, cfg.SecretIDFile, "secret ID")
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"role_id": roleID, "secret_id": secretID}, nil
+		})
+		return token, true, err
+	case cfg.K8sRole != "":
+		token, err := c.login(ctx, cfg.K8sMount, func() (map[string]string, error) {
+			jwt, err := readSecret(cfg.K8sTokenFile, "service account token")
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"role": cfg.K8sRole, "jwt": jwt}, nil
+		})
+		return token, true, err
+	}
+	home, err := os.UserHomeDir()
+	if err == nil {
+		token, err := readSecret(filepath.Join(home, ".vault-token"), "token")
+		if err == nil {
+			return token, false, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", false, err
+		}
+	}
+	return "", false, errors.New("no Vault credentials: set VAULT_TOKEN, VAULT_ROLE_ID or VAULT_K8S_ROLE")
+}
+
+// login logs in with the auth method mounted at mount unless a token of an
+// earlier login is still valid. The credentials are only read when needed.
+func (c *Client) login(ctx context.Context, mount string, credentials func() (map[string]string, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && (c.expires.IsZero() || time.Now().Before(c.expires)) {
+		return c.token, nil
+	}
+	payload, err := credentials()
+	if err != nil {
+		return "", err
+	}
+	start := time.Now()
+	body, err := c.do(ctx, http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", "", payload)
+	if err != nil {
+		return "", fmt.Errorf("logging in with %s: %w", mount, err)
+	}
+	var resp struct {
+		Auth *struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("logging in with %s: no token in response", mount)
+	}
+	c.token, c.expires = resp.Auth.ClientToken, time.Time{}
+	if lease := time.Duration(resp.Auth.LeaseDuration) * time.Second; lease > 0 {
+		// Renew a little early so requests in flight keep a valid token.
+		c.expires = start.Add(lease * 9 / 10)
+	}
+	return c.token, nil
+}
+
+// value returns v, or the content of file if v is empty.
+func value(v, file, name string) (string, error) {
+	if v != "" {
+		return v, nil
+	}
+	if file == "" {
+		return "", fmt.Errorf("no %s", name)
+	}
+	return readSecret(file, name)
+}
+
+// readSecret reads a credential from file, without surrounding whitespace.
+func readSecret(file, name string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", name, err)
+	}
+	v := strings.TrimSpace(string(data))
+	if v == "" {
+		return "", fmt.Errorf("empty %s in %s", name, file)
+	}
+	return v, nil
+}
@@ -0,0 +1,206 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loginVault is a stand-in for the Vault API that issues a new token on each
+// AppRole or Kubernetes login and serves kv/app to the latest one.
+type loginVault struct {
+	lease  int // lease duration of issued tokens in seconds
+	logins atomic.Int32
+	valid  atomic.Value // the token accepted for reads
+}
+
+func (l *loginVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/auth/approle/login", "/v1/auth/k8s/login":
+		var creds map[string]string
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&creds) != nil {
+			reply(w, http.StatusBadRequest, `{"errors":["invalid request"]}`)
+			return
+		}
+		if (creds["role_id"] != "role" || creds["secret_id"] != "secret") && (creds["role"] != "app" || creds["jwt"] != "eyJhbGciOi.jwt") {
+			reply(w, http.StatusBadRequest, `{"errors":["invalid credentials"]}`)
+			return
+		}
+		token := fmt.Sprintf("s.login%d", l.logins.Add(1))
+		l.valid.Store(token)
+		reply(w, http.StatusOK, fmt.Sprintf(`{"auth":{"client_token":%q,"lease_duration":%d}}`, token, l.lease))
+	case "/v1/kv/app":
+		if valid, _ := l.valid.Load().(string); valid == "" || r.Header.Get("X-Vault-Token") != valid {
+			reply(w, http.StatusForbidden, `{"errors":["permission denied"]}`)
+			return
+		}
+		reply(w, http.StatusOK, `{"data":{"password":"s3cret"}}`)
+	default:
+		reply(w, http.StatusNotFound, `{"errors":[]}`)
+	}
+}
+
+// writeFile writes content to name in a new temporary directory.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestClient_Auth(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Token file", func(t *testing.T) {
+		t.Parallel()
+		srv := httptest.NewServer(&fakeVault{token: "s.root"})
+		t.Cleanup(srv.Close)
+		c := client(t, srv.URL, Config{TokenFile: writeFile(t, "token", "s.root\n")})
+		_, err := c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+	})
+
+	t.Run("Token takes precedence", func(t *testing.T) {
+		t.Parallel()
+		srv := httptest.NewServer(&fakeVault{token: "s.root"})
+		t.Cleanup(srv.Close)
+		c := client(t, srv.URL, Config{Token: "s.root", TokenFile: "/missing", RoleID: "role"})
+		_, err := c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+	})
+
+	t.Run("Empty token file", func(t *testing.T) {
+		t.Parallel()
+		path := writeFile(t, "token", "\n")
+		_, err := client(t, "http://127.0.0.1:0", Config{TokenFile: path}).Read(context.Background(), "kv/app")
+		require.Error(t, err)
+		assert.EqualError(t, err, "empty token in "+path)
+	})
+
+	t.Run("AppRole logs in once", func(t *testing.T) {
+		t.Parallel()
+		fake := &loginVault{}
+		srv := httptest.NewServer(fake)
+		t.Cleanup(srv.Close)
+		c := client(t, srv.URL, Config{AppRoleMount: "approle", RoleID: "role", SecretIDFile: writeFile(t, "secret-id", "secret\n")})
+
+		for range 3 {
+			data, err := c.Read(context.Background(), "kv/app")
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"password":"s3cret"}`, string(data))
+		}
+		assert.EqualValues(t, 1, fake.logins.Load())
+	})
+
+	t.Run("AppRole credentials from files", func(t *testing.T) {
+		t.Parallel()
+		srv := httptest.NewServer(&loginVault{})
+		t.Cleanup(srv.Close)
+		c := client(t, srv.URL, Config{
+			AppRoleMount: "approle",
+			RoleIDFile:   writeFile(t, "role-id", "role"),
+			SecretIDFile: writeFile(t, "secret-id", "secret"),
+		})
+		_, err := c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+	})
+
+	t.Run("AppRole without secret ID", func(t *testing.T) {
+		t.Parallel()
+		_, err := client(t, "http://127.0.0.1:0", Config{AppRoleMount: "approle", RoleID: "role"}).
+			Read(context.Background(), "kv/app")
+		require.Error(t, err)
+		assert.EqualError(t, err, "no secret ID")
+	})
+
+	t.Run("AppRole invalid credentials", func(t *testing.T) {
+		t.Parallel()
+		srv := httptest.NewServer(&loginVault{})
+		t.Cleanup(srv.Close)
+		c := client(t, srv.URL, Config{AppRoleMount: "approle", RoleID: "role", SecretID: "wrong"})
+		_, err := c.Read(context.Background(), "kv/app")
+		require.Error(t, err)
+		assert.EqualError(t, err, "logging in with approle: 400 Bad Request: invalid credentials")
+	})
+
+	t.Run("Kubernetes", func(t *testing.T) {
+		t.Parallel()
+		srv := httptest.NewServer(&loginVault{})
+		t.Cleanup(srv.Close)
+		c := client(t, srv.URL, Config{K8sRole: "app", K8sMount: "/k8s/", K8sTokenFile: writeFile(t, "token", "eyJhbGciOi.jwt\n")})
+		_, err := c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+	})
+
+	t.Run("Kubernetes without service account token", func(t *testing.T) {
+		t.Parallel()
+		c := client(t, "http://127.0.0.1:0", Config{K8sRole: "app", K8sMount: "k8s", K8sTokenFile: filepath.Join(t.TempDir(), "token")})
+		_, err := c.Read(context.Background(), "kv/app")
+		require.Error(t, err)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		assert.ErrorContains(t, err, "reading service account token: ")
+	})
+
+	t.Run("Logs in again when the lease expired", func(t *testing.T) {
+		t.Parallel()
+		fake := &loginVault{lease: 1}
+		srv := httptest.NewServer(fake)
+		t.Cleanup(srv.Close)
+		c := client(t, srv.URL, Config{AppRoleMount: "approle", RoleID: "role", SecretID: "secret"})
+
+		_, err := c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+		c.mu.Lock()
+		c.expires = c.expires.Add(-time.Second)
+		c.mu.Unlock()
+		_, err = c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, fake.logins.Load())
+	})
+
+	t.Run("Logs in again when the token was revoked", func(t *testing.T) {
+		t.Parallel()
+		fake := &loginVault{}
+		srv := httptest.NewServer(fake)
+		t.Cleanup(srv.Close)
+		c := client(t, srv.URL, Config{AppRoleMount: "approle", RoleID: "role", SecretID: "secret"})
+
+		_, err := c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+		fake.valid.Store("")
+		_, err = c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, fake.logins.Load())
+	})
+}
+
+func TestClient_DefaultToken(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	c := client(t, "http://127.0.0.1:0", Config{})
+
+	t.Run("No credentials", func(t *testing.T) {
+		_, err := c.Read(context.Background(), "kv/app")
+		require.Error(t, err)
+		assert.EqualError(t, err, "no Vault credentials: set VAULT_TOKEN, VAULT_ROLE_ID or VAULT_K8S_ROLE")
+	})
+
+	t.Run("Token of the vault CLI", func(t *testing.T) {
+		srv := httptest.NewServer(&fakeVault{token: "s.cli"})
+		t.Cleanup(srv.Close)
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".vault-token"), []byte("s.cli"), 0o600))
+		_, err := client(t, srv.URL, Config{}).Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,303 @@
+// Package vault reads secrets from the key/value engines of HashiCorp Vault
+// over its HTTP API. Connection and credentials come from the VAULT_*
+// environment variables the vault CLI uses.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxResponse bounds the size of a response body.
+const maxResponse = 32 << 20
+
+// ErrNotFound is returned for secrets that do not exist. It matches
+// fs.ErrNotExist.
+var ErrNotFound error = notFound{}
+
+type notFound struct{}
+
+func (notFound) Error() string        { return "secret not found" }
+func (notFound) Is(target error) bool { return target == fs.ErrNotExist }
+
+// Config is how to reach and authenticate to Vault. Credentials are tried in
+// order: a token, AppRole, Kubernetes, then the token helper file of the
+// vault CLI.
+type Config struct {
+	Address       string        // VAULT_ADDR
+	Namespace     string        // VAULT_NAMESPACE
+	Token         string        // VAULT_TOKEN
+	TokenFile     string        // VAULT_TOKEN_FILE
+	RoleID        string        // VAULT_ROLE_ID
+	RoleIDFile    string        // VAULT_ROLE_ID_FILE
+	SecretID      string        // VAULT_SECRET_ID
+	SecretIDFile  string        // VAULT_SECRET_ID_FILE
+	AppRoleMount  string        // VAULT_APPROLE_MOUNT, default "approle"
+	K8sRole       string        // VAULT_K8S_ROLE
+	K8sTokenFile  string        // VAULT_K8S_TOKEN_FILE, default the service account token
+	K8sMount      string        // VAULT_K8S_MOUNT, default "kubernetes"
+	CACert        string        // VAULT_CACERT: PEM file of trusted CAs
+	CAPath        string        // VAULT_CAPATH: directory of PEM files of trusted CAs
+	ClientCert    string        // VAULT_CLIENT_CERT
+	ClientKey     string        // VAULT_CLIENT_KEY
+	TLSServerName string        // VAULT_TLS_SERVER_NAME
+	SkipVerify    bool          // VAULT_SKIP_VERIFY
+	MaxRetries    int           // VAULT_MAX_RETRIES, default 2
+	RetryWait     time.Duration // delay before the first retry, doubled for each further one
+}
+
+// Defaults of Config.
+const (
+	DefaultAddress      = "https://127.0.0.1:8200"
+	DefaultAppRoleMount = "approle"
+	DefaultK8sMount     = "kubernetes"
+	DefaultK8sTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	DefaultMaxRetries   = 2
+	DefaultRetryWait    = 500 * time.Millisecond
+)
+
+// ConfigFromEnv reads Config from the environment.
+func ConfigFromEnv() (Config, error) {
+	env := func(name, def string) string {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+		return def
+	}
+	c := Config{
+		Address:       env("VAULT_ADDR", DefaultAddress),
+		Namespace:     os.Getenv("VAULT_NAMESPACE"),
+		Token:         os.Getenv("VAULT_TOKEN"),
+		TokenFile:     os.Getenv("VAULT_TOKEN_FILE"),
+		RoleID:        os.Getenv("VAULT_ROLE_ID"),
+		RoleIDFile:    os.Getenv("VAULT_ROLE_ID_FILE"),
+		SecretID:      os.Getenv("VAULT_SECRET_ID"),
+		SecretIDFile:  os.Getenv("VAULT_SECRET_ID_FILE"),
+		AppRoleMount:  env("VAULT_APPROLE_MOUNT", DefaultAppRoleMount),
+		K8sRole:       os.Getenv("VAULT_K8S_ROLE"),
+		K8sTokenFile:  env("VAULT_K8S_TOKEN_FILE", DefaultK8sTokenFile),
+		K8sMount:      env("VAULT_K8S_MOUNT", DefaultK8sMount),
+		CACert:        os.Getenv("VAULT_CACERT"),
+		CAPath:        os.Getenv("VAULT_CAPATH"),
+		ClientCert:    os.Getenv("VAULT_CLIENT_CERT"),
+		ClientKey:     os.Getenv("VAULT_CLIENT_KEY"),
+		TLSServerName: os.Getenv("VAULT_TLS_SERVER_NAME"),
+		MaxRetries:    DefaultMaxRetries,
+		RetryWait:     DefaultRetryWait,
+	}
+	if v := os.Getenv("VAULT_SKIP_VERIFY"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid VAULT_SKIP_VERIFY %q", v)
+		}
+		c.SkipVerify = b
+	}
+	if v := os.Getenv("VAULT_MAX_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return Config{}, fmt.Errorf("invalid VAULT_MAX_RETRIES %q", v)
+		}
+		c.MaxRetries = n
+	}
+	return c, nil
+}
+
+// Client reads secrets from one Vault server. It logs in once and reuses the
+// token until its lease expires. It is safe for concurrent use.
+type Client struct {
+	cfg  Config
+	http *http.Client
+
+	mu      sync.Mutex
+	token   string    // token obtained by login
+	expires time.Time // end of the token lease; zero if it does not expire
+}
+
+// New returns a client for cfg.
+func New(cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("empty Vault address")
+	}
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &Client{cfg: cfg, http: &http.Client{Transport: transport}}, nil
+}
+
+// tlsConfig builds the TLS settings of cfg.
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.TLSServerName,
+		InsecureSkipVerify: cfg.SkipVerify, // only if requested with VAULT_SKIP_VERIFY
+	}
+	if cfg.CACert != "" || cfg.CAPath != "" {
+		pool := x509.NewCertPool()
+		files := []string{}
+		if cfg.CACert != "" {
+			files = append(files, cfg.CACert)
+		}
+		if cfg.CAPath != "" {
+			entries, err := os.ReadDir(cfg.CAPath)
+			if err != nil {
+				return nil, fmt.Errorf("reading CA directory: %w", err)
+			}
+			for _, e := range entries {
+				if !e.IsDir() {
+					files = append(files, filepath.Join(cfg.CAPath, e.Name()))
+				}
+			}
+		}
+		for _, file := range files {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("reading CA certificate: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in %s", file)
+			}
+		}
+		conf.RootCAs = pool
+	}
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// Read returns the data of the secret at path, e.g. "secret/data/app" for
+// the KV v2 engine mounted at "secret" or "kv/app" for KV v1. A query such
+// as "?version=2" is passed on. KV v2 data holds "data" and "metadata".
+func (c *Client) Read(ctx context.Context, path string) (json.RawMessage, error) {
+	token, login, err := c.authToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.do(ctx, http.MethodGet, path, token, nil)
+	if login && errors.Is(err, errPermissionDenied) {
+		// The login token may have been revoked; log in again once.
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+		if token, _, err = c.authToken(ctx); err != nil {
+			return nil, err
+		}
+		body, err = c.do(ctx, http.MethodGet, path, token, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	if len(resp.Data) == 0 || string(resp.Data) == "null" {
+		return nil, ErrNotFound
+	}
+	return resp.Data, nil
+}
+
+// errPermissionDenied is returned for responses with status 403.
+var errPermissionDenied = errors.New("permission denied")
+
+// do sends a request to the API path and returns the response body. Network
+// errors, 429 and 5xx responses are retried.
+func (c *Client) do(ctx context.Context, method, path, token string, payload any) ([]byte, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	url := strings.TrimRight(c.cfg.Address, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	wait := c.cfg.RetryWait
+	for attempt := 0; ; attempt++ {
+		data, retry, err := c.send(ctx, method, url, token, body)
+		if err == nil || !retry || attempt >= c.cfg.MaxRetries {
+			return data, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// send sends one request and reports whether a failure may be retried.
+func (c *Client) send(ctx context.Context, method, url, token string, body []byte) ([]byte, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("X-Vault-Request", "true")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.cfg.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse+1))
+	if err != nil {
+		return nil, true, fmt.Errorf("reading response: %w", err)
+	}
+	if len(data) > maxResponse {
+		return nil, false, fmt.Errorf("response exceeds %d bytes", maxResponse)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, false, ErrNotFound
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, false, fmt.Errorf("%w%s", errPermissionDenied, apiErrors(data))
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, true, fmt.Errorf("%s%s", resp.Status, apiErrors(data))
+	case resp.StatusCode >= 300:
+		return nil, false, fmt.Errorf("%s%s", resp.Status, apiErrors(data))
+	}
+	return data, false, nil
+}
+
+// apiErrors returns the error messages of a Vault error response, prefixed
+// with ": ", or nothing.
+func apiErrors(data []byte) string {
+	var resp struct {
+		Errors []string `json:"errors"`
+	}
+	if json.Unmarshal(data, &resp) != nil || len(resp.Errors) == 0 {
+		return ""
+	}
+	return ": " + strings.Join(resp.Errors, "; ")
+}
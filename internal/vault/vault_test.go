@@ -0,0 +1,311 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault is a stand-in for the Vault API serving one KV v1 and one KV v2
+// secret to requests with token.
+type fakeVault struct {
+	token     string
+	namespace string
+	requests  atomic.Int32
+	fail      atomic.Int32 // number of requests still answered with 503
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+	if f.fail.Load() > 0 {
+		f.fail.Add(-1)
+		reply(w, http.StatusServiceUnavailable, `{"errors":["Vault is sealed"]}`)
+		return
+	}
+	if r.Header.Get("X-Vault-Request") != "true" || r.Header.Get("X-Vault-Namespace") != f.namespace {
+		reply(w, http.StatusBadRequest, `{"errors":["unexpected headers"]}`)
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != f.token {
+		reply(w, http.StatusForbidden, `{"errors":["permission denied"]}`)
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/secret/data/app":
+		if v := r.URL.Query().Get("version"); v != "" && v != "1" {
+			reply(w, http.StatusNotFound, `{"errors":[]}`)
+			return
+		}
+		reply(w, http.StatusOK, `{"request_id":"1","lease_duration":0,"data":{
+			"data":{"password":"hunter2","port":5432},
+			"metadata":{"version":1,"deletion_time":"","destroyed":false}}}`)
+	case "/v1/kv/app":
+		reply(w, http.StatusOK, `{"request_id":"2","lease_duration":2764800,"data":{"password":"s3cret","user":"app"}}`)
+	case "/v1/kv/empty":
+		reply(w, http.StatusOK, `{"request_id":"3","data":null}`)
+	default:
+		reply(w, http.StatusNotFound, `{"errors":[]}`)
+	}
+}
+
+// reply writes a JSON response.
+func reply(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body))
+}
+
+// client returns a client for url with cfg, retrying without delay.
+func client(t *testing.T, url string, cfg Config) *Client {
+	t.Helper()
+	cfg.Address = url
+	if cfg.RetryWait == 0 {
+		cfg.RetryWait = time.Millisecond
+	}
+	c, err := New(cfg)
+	require.NoError(t, err)
+	return c
+}
+
+// caFile writes the certificate of srv to a PEM file in dir.
+func caFile(t *testing.T, srv *httptest.Server, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func TestClient_Read(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeVault{token: "s.root", namespace: "team"}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	c := client(t, srv.URL+"/", Config{Token: "s.root", Namespace: "team"})
+
+	t.Run("KV v2", func(t *testing.T) {
+		t.Parallel()
+		data, err := c.Read(context.Background(), "secret/data/app")
+		require.NoError(t, err)
+		var doc struct {
+			Data map[string]any `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(data, &doc))
+		assert.Equal(t, map[string]any{"password": "hunter2", "port": float64(5432)}, doc.Data)
+	})
+
+	t.Run("KV v2 version", func(t *testing.T) {
+		t.Parallel()
+		_, err := c.Read(context.Background(), "/secret/data/app?version=1")
+		require.NoError(t, err)
+		_, err = c.Read(context.Background(), "secret/data/app?version=2")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("KV v1", func(t *testing.T) {
+		t.Parallel()
+		data, err := c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"password":"s3cret","user":"app"}`, string(data))
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		t.Parallel()
+		_, err := c.Read(context.Background(), "kv/missing")
+		require.Error(t, err)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		assert.EqualError(t, err, "secret not found")
+
+		_, err = c.Read(context.Background(), "kv/empty")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Permission denied", func(t *testing.T) {
+		t.Parallel()
+		other := client(t, srv.URL, Config{Token: "s.other", Namespace: "team"})
+		_, err := other.Read(context.Background(), "kv/app")
+		require.Error(t, err)
+		assert.EqualError(t, err, "permission denied: permission denied")
+	})
+
+	t.Run("Client errors are not retried", func(t *testing.T) {
+		t.Parallel()
+		fake := &fakeVault{token: "s.root", namespace: "team"}
+		srv := httptest.NewServer(fake)
+		t.Cleanup(srv.Close)
+		_, err := client(t, srv.URL, Config{Token: "s.root", MaxRetries: 3}).Read(context.Background(), "kv/app")
+		require.Error(t, err)
+		assert.EqualError(t, err, "400 Bad Request: unexpected headers")
+		assert.EqualValues(t, 1, fake.requests.Load())
+	})
+}
+
+func TestClient_Retries(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Server errors are retried", func(t *testing.T) {
+		t.Parallel()
+		fake := &fakeVault{token: "s.root"}
+		fake.fail.Store(2)
+		srv := httptest.NewServer(fake)
+		t.Cleanup(srv.Close)
+
+		data, err := client(t, srv.URL, Config{Token: "s.root", MaxRetries: 2}).Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"password":"s3cret","user":"app"}`, string(data))
+		assert.EqualValues(t, 3, fake.requests.Load())
+	})
+
+	t.Run("Gives up after the last retry", func(t *testing.T) {
+		t.Parallel()
+		fake := &fakeVault{token: "s.root"}
+		fake.fail.Store(5)
+		srv := httptest.NewServer(fake)
+		t.Cleanup(srv.Close)
+
+		_, err := client(t, srv.URL, Config{Token: "s.root", MaxRetries: 1}).Read(context.Background(), "kv/app")
+		require.Error(t, err)
+		assert.EqualError(t, err, "503 Service Unavailable: Vault is sealed")
+		assert.EqualValues(t, 2, fake.requests.Load())
+	})
+
+	t.Run("Network errors are retried", func(t *testing.T) {
+		t.Parallel()
+		srv := httptest.NewServer(&fakeVault{token: "s.root"})
+		srv.Close()
+
+		start := time.Now()
+		_, err := client(t, srv.URL, Config{Token: "s.root", MaxRetries: 2, RetryWait: 20 * time.Millisecond}).
+			Read(context.Background(), "kv/app")
+		require.Error(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond, "waits 20ms, then 40ms")
+	})
+
+	t.Run("Canceled while waiting", func(t *testing.T) {
+		t.Parallel()
+		fake := &fakeVault{token: "s.root"}
+		fake.fail.Store(5)
+		srv := httptest.NewServer(fake)
+		t.Cleanup(srv.Close)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := client(t, srv.URL, Config{Token: "s.root", MaxRetries: 5, RetryWait: time.Hour}).Read(ctx, "kv/app")
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.EqualValues(t, 1, fake.requests.Load())
+	})
+}
+
+func TestClient_TLS(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewTLSServer(&fakeVault{token: "s.root"})
+	t.Cleanup(srv.Close)
+
+	t.Run("CA certificate", func(t *testing.T) {
+		t.Parallel()
+		c := client(t, srv.URL, Config{Token: "s.root", CACert: caFile(t, srv, t.TempDir())})
+		_, err := c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+	})
+
+	t.Run("CA directory", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		caFile(t, srv, dir)
+		c := client(t, srv.URL, Config{Token: "s.root", CAPath: dir})
+		_, err := c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+	})
+
+	t.Run("Unknown authority", func(t *testing.T) {
+		t.Parallel()
+		c := client(t, srv.URL, Config{Token: "s.root"})
+		_, err := c.Read(context.Background(), "kv/app")
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "certificate")
+	})
+
+	t.Run("Skip verify", func(t *testing.T) {
+		t.Parallel()
+		c := client(t, srv.URL, Config{Token: "s.root", SkipVerify: true})
+		_, err := c.Read(context.Background(), "kv/app")
+		require.NoError(t, err)
+	})
+
+	t.Run("Invalid CA file", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "ca.pem")
+		require.NoError(t, os.WriteFile(path, []byte("not a certificate\n"), 0o600))
+		_, err := New(Config{Address: srv.URL, CACert: path})
+		require.Error(t, err)
+		assert.EqualError(t, err, "no certificates in "+path)
+	})
+
+	t.Run("Missing CA file", func(t *testing.T) {
+		t.Parallel()
+		_, err := New(Config{Address: srv.URL, CACert: filepath.Join(t.TempDir(), "missing.pem")})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		for _, name := range []string{"VAULT_ADDR", "VAULT_TOKEN", "VAULT_APPROLE_MOUNT", "VAULT_K8S_MOUNT",
+			"VAULT_K8S_TOKEN_FILE", "VAULT_SKIP_VERIFY", "VAULT_MAX_RETRIES"} {
+			t.Setenv(name, "")
+		}
+		cfg, err := ConfigFromEnv()
+		require.NoError(t, err)
+		assert.Equal(t, DefaultAddress, cfg.Address)
+		assert.Equal(t, DefaultAppRoleMount, cfg.AppRoleMount)
+		assert.Equal(t, DefaultK8sMount, cfg.K8sMount)
+		assert.Equal(t, DefaultK8sTokenFile, cfg.K8sTokenFile)
+		assert.Equal(t, DefaultMaxRetries, cfg.MaxRetries)
+		assert.False(t, cfg.SkipVerify)
+	})
+
+	t.Run("From environment", func(t *testing.T) {
+		t.Setenv("VAULT_ADDR", "https://vault.internal:8200")
+		t.Setenv("VAULT_TOKEN", "s.root")
+		t.Setenv("VAULT_CACERT", "/etc/vault/ca.pem")
+		t.Setenv("VAULT_K8S_ROLE", "app")
+		t.Setenv("VAULT_SKIP_VERIFY", "true")
+		t.Setenv("VAULT_MAX_RETRIES", "0")
+		cfg, err := ConfigFromEnv()
+		require.NoError(t, err)
+		assert.Equal(t, "https://vault.internal:8200", cfg.Address)
+		assert.Equal(t, "s.root", cfg.Token)
+		assert.Equal(t, "/etc/vault/ca.pem", cfg.CACert)
+		assert.Equal(t, "app", cfg.K8sRole)
+		assert.True(t, cfg.SkipVerify)
+		assert.Equal(t, 0, cfg.MaxRetries)
+	})
+
+	t.Run("Invalid values", func(t *testing.T) {
+		t.Setenv("VAULT_SKIP_VERIFY", "")
+		t.Setenv("VAULT_MAX_RETRIES", "-1")
+		_, err := ConfigFromEnv()
+		require.Error(t, err)
+		assert.EqualError(t, err, `invalid VAULT_MAX_RETRIES "-1"`)
+
+		t.Setenv("VAULT_SKIP_VERIFY", "maybe")
+		_, err = ConfigFromEnv()
+		require.Error(t, err)
+		assert.EqualError(t, err, `invalid VAULT_SKIP_VERIFY "maybe"`)
+	})
+}
@@ -0,0 +1,114 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// dataDir is the symlink Kubernetes swaps atomically when a mounted
+// ConfigMap or Secret changes. Mounted files point into it.
+const dataDir = "..data"
+
+// Watcher reports changes to a set of files.
+//
+// Parent directories are watched instead of the files themselves, so files
+// replaced by rename (editors, atomic writers) and Kubernetes volume updates,
+// which swap the "..data" symlink, are picked up as well as in-place writes.
+type Watcher struct {
+	fsw   *fsnotify.Watcher
+	paths []string
+	names map[string]struct{} // absolute names whose changes are relevant
+}
+
+// New starts watching paths. Call Close when done.
+func New(paths []string) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("creating watcher: %w", err)
+	}
+	w := &Watcher{fsw: fsw, paths: paths}
+	if err := w.refresh(); err != nil {
+		_ = fsw.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Close stops watching.
+func (w *Watcher) Close() error {
+	return w.fsw.Close()
+}
+
+// Run calls onChange once a burst of changes has been quiet for debounce.
+// It returns nil when ctx is canceled.
+func (w *Watcher) Run(ctx context.Context, debounce time.Duration, onChange func()) error {
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Op == fsnotify.Chmod || !w.relevant(ev.Name) {
+				continue
+			}
+			timer.Reset(debounce)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("watching files: %w", err)
+		case <-timer.C:
+			// Symlink targets may have moved; watch the new locations.
+			if err := w.refresh(); err != nil {
+				return err
+			}
+			onChange()
+		}
+	}
+}
+
+// refresh (re)computes the relevant names and watches their directories:
+// the path itself, a sibling "..data" symlink, and the resolved target when
+// the path is a symlink.
+func (w *Watcher) refresh() error {
+	names := make(map[string]struct{})
+	for _, p := range w.paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return fmt.Errorf("resolving %q: %w", p, err)
+		}
+		dir := filepath.Dir(abs)
+		if err := w.fsw.Add(dir); err != nil {
+			return fmt.Errorf("watching %q: %w", dir, err)
+		}
+		names[abs] = struct{}{}
+		names[filepath.Join(dir, dataDir)] = struct{}{}
+
+		target, err := filepath.EvalSymlinks(abs)
+		if err != nil || target == abs {
+			continue // missing files are reported by the extraction
+		}
+		if err := w.fsw.Add(filepath.Dir(target)); err != nil {
+			return fmt.Errorf("watching %q: %w", filepath.Dir(target), err)
+		}
+		names[target] = struct{}{}
+	}
+	w.names = names
+	return nil
+}
+
+// relevant reports whether an event on name may change a watched file.
+func (w *Watcher) relevant(name string) bool {
+	_, ok := w.names[filepath.Clean(name)]
+	return ok
+}
@@ -0,0 +1,145 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const debounce = 50 * time.Millisecond
+
+// start runs a Watcher for paths and returns a channel receiving one value per
+// onChange call.
+func start(t *testing.T, paths ...string) <-chan struct{} {
+	t.Helper()
+
+	w, err := New(paths)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan struct{}, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Run(ctx, debounce, func() { changes <- struct{}{} })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	return changes
+}
+
+// expectChange fails unless exactly one change is reported.
+func expectChange(t *testing.T, changes <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported")
+	}
+	expectNoChange(t, changes)
+}
+
+// expectNoChange fails if a change is reported.
+func expectNoChange(t *testing.T, changes <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-changes:
+		t.Fatal("unexpected change reported")
+	case <-time.After(4 * debounce):
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	t.Parallel()
+
+	t.Run("In-place write", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.env")
+		require.NoError(t, os.WriteFile(path, []byte("A=1\n"), 0o666))
+
+		changes := start(t, path)
+		require.NoError(t, os.WriteFile(path, []byte("A=2\n"), 0o666))
+		expectChange(t, changes)
+	})
+
+	t.Run("Replaced by rename", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.env")
+		require.NoError(t, os.WriteFile(path, []byte("A=1\n"), 0o666))
+
+		changes := start(t, path)
+		tmp := filepath.Join(dir, ".tmp")
+		require.NoError(t, os.WriteFile(tmp, []byte("A=2\n"), 0o666))
+		require.NoError(t, os.Rename(tmp, path))
+		expectChange(t, changes)
+	})
+
+	t.Run("Burst is debounced", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.env")
+		require.NoError(t, os.WriteFile(path, []byte("A=0\n"), 0o666))
+
+		changes := start(t, path)
+		for i := range 5 {
+			require.NoError(t, os.WriteFile(path, []byte{'A', '=', byte('1' + i), '\n'}, 0o666))
+		}
+		expectChange(t, changes)
+	})
+
+	t.Run("Unrelated files are ignored", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.env")
+		require.NoError(t, os.WriteFile(path, []byte("A=1\n"), 0o666))
+
+		changes := start(t, path)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0o666))
+		require.NoError(t, os.Chmod(path, 0o600))
+		expectNoChange(t, changes)
+	})
+
+	t.Run("Kubernetes data symlink swap", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+
+		// Layout of a mounted ConfigMap: key -> ..data/key, ..data -> ..v1
+		writeVersion := func(name, content string) {
+			require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name, "key"), []byte(content), 0o666))
+		}
+		writeVersion("..v1", "one")
+		require.NoError(t, os.Symlink("..v1", filepath.Join(dir, "..data")))
+		path := filepath.Join(dir, "key")
+		require.NoError(t, os.Symlink(filepath.Join("..data", "key"), path))
+
+		changes := start(t, path)
+
+		for _, v := range []string{"..v2", "..v3"} {
+			writeVersion(v, v)
+			tmp := filepath.Join(dir, "..data_tmp")
+			require.NoError(t, os.Symlink(v, tmp))
+			require.NoError(t, os.Rename(tmp, filepath.Join(dir, "..data")))
+			expectChange(t, changes)
+
+			got, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, v, string(got))
+		}
+	})
+
+	t.Run("Missing directory", func(t *testing.T) {
+		t.Parallel()
+		_, err := New([]string{filepath.Join(t.TempDir(), "missing", "app.env")})
+		require.Error(t, err)
+	})
+}
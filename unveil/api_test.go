@@ -0,0 +1,173 @@
+package unveil_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/gi8lino/unveil/unveil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The assignments below fail to compile if a signature or field of the
+// public API changes. Extend them when adding to the API; never edit them to
+// follow an incompatible change.
+var (
+	_ func(context.Context, []unveil.Spec) (unveil.Result, error)      = unveil.Extract
+	_ func(context.Context, []unveil.Spec, int) (unveil.Result, error) = unveil.ExtractParallel
+	_ func(io.Writer, unveil.Result, unveil.Options) error             = unveil.Write
+	_ func(unveil.Result, unveil.Options) ([]byte, error)              = unveil.Render
+	_ func(string, unveil.Result, unveil.Options) error                = unveil.WriteFile
+	_ func(unveil.Kind, unveil.Source)                                 = unveil.Register
+	_ func() []unveil.Kind                                             = unveil.Kinds
+	_ func(unveil.Format, unveil.Formatter)                            = unveil.RegisterFormat
+	_ func() []unveil.Format                                           = unveil.Formats
+	_                                                                  = unveil.Scalar{Value: nil, Literal: "", Line: 0, Column: 0}
+	_                                                                  = unveil.Description{Title: "", PathHelp: "", Secret: false, Remote: false, Decrypt: false, URLs: false}
+	_ error                                                            = unveil.ErrHookFailed
+	_ error                                                            = unveil.ErrInsecureDir
+
+	_ = unveil.Spec{
+		Kind:    unveil.KindJSON,
+		Path:    "",
+		Key:     "",
+		Var:     "",
+		RawVar:  "",
+		Quote:   unveil.QuoteNone,
+		Literal: false,
+		Rules: unveil.Rules{
+			Type:     unveil.TypeInt,
+			Range:    &unveil.Range{Min: 0, Max: 0},
+			Match:    (*regexp.Regexp)(nil),
+			Enum:     []string(nil),
+			NonEmpty: false,
+			MinLen:   0,
+			MaxLen:   0,
+		},
+		Secret:   false,
+		Public:   false,
+		Decrypt:  unveil.DecryptAge,
+		Identity: "",
+		HTTP: unveil.HTTPOptions{
+			Headers:         []string(nil),
+			HeaderFiles:     []string(nil),
+			BearerTokenFile: "",
+			CAFile:          "",
+			Timeout:         0,
+			MaxSize:         0,
+			CacheDir:        "",
+		},
+	}
+	_ = unveil.Result{Vars: []unveil.Var{{Name: "", Value: "", Secret: false}}, Origins: []unveil.Origin{{
+		Spec:       unveil.Spec{},
+		Path:       "",
+		Line:       0,
+		Column:     0,
+		Transforms: []string(nil),
+		Value:      "",
+	}}}
+	_ = unveil.Options{
+		Format:  unveil.FormatEnv,
+		Shell:   unveil.ShellPOSIX,
+		Export:  false,
+		Merge:   false,
+		Order:   unveil.OrderSorted,
+		File:    unveil.FileOptions{Mode: os.FileMode(0), Owner: &unveil.Owner{UID: 0, GID: 0}, Private: false},
+		OnWrite: func() error { return nil },
+	}
+)
+
+// The Source interface must stay implementable with these methods.
+type apiSource struct{}
+
+func (apiSource) Read(context.Context, unveil.Spec) ([]byte, error) { return nil, nil }
+func (apiSource) Parse([]byte) (any, error)                         { return nil, nil }
+func (apiSource) Select(any, string) (any, error)                   { return nil, nil }
+
+var _ unveil.Source = apiSource{}
+
+// The Describer interface must stay implementable with this method.
+func (apiSource) Describe() unveil.Description { return unveil.Description{} }
+
+var _ unveil.Describer = apiSource{}
+
+// The Formatter and Merger interfaces must stay implementable with these methods.
+type apiFormatter struct{}
+
+func (apiFormatter) Render(io.Writer, []unveil.Var, unveil.Options) error       { return nil }
+func (apiFormatter) Entry(unveil.Var, unveil.Options) (string, error)           { return "", nil }
+func (apiFormatter) Entries([]byte, unveil.Options) (map[string]string, error)  { return nil, nil }
+func (apiFormatter) Merge([]byte, []unveil.Var, unveil.Options) ([]byte, error) { return nil, nil }
+
+var (
+	_ unveil.Formatter = apiFormatter{}
+	_ unveil.Merger    = apiFormatter{}
+)
+
+func TestAPI_Constants(t *testing.T) {
+	t.Parallel()
+
+	// Values of constants are part of the API: they match the CLI flags.
+	assert.Equal(t, []string{"json", "yaml", "toml", "ini", "file"}, strs(unveil.KindJSON, unveil.KindYAML, unveil.KindTOML, unveil.KindINI, unveil.KindFile))
+	assert.Equal(t, "vault", string(unveil.KindVault))
+	assert.Equal(t, []string{"", "age"}, strs(unveil.DecryptNone, unveil.DecryptAge))
+	assert.Equal(t, []string{"none", "single", "double", "json"}, strs(unveil.QuoteNone, unveil.QuoteSingle, unveil.QuoteDouble, unveil.QuoteJSON))
+	assert.Equal(t, []string{"string", "int", "bool", "duration", "url", "email", "port"},
+		strs(unveil.TypeString, unveil.TypeInt, unveil.TypeBool, unveil.TypeDuration, unveil.TypeURL, unveil.TypeEmail, unveil.TypePort))
+	assert.Equal(t, []string{"env", "docker-env", "systemd", "make", "tfvars", "tfvars-json", "json", "yaml"},
+		strs(unveil.FormatEnv, unveil.FormatDockerEnv, unveil.FormatSystemd, unveil.FormatMake, unveil.FormatTFVars, unveil.FormatTFVarsJSON, unveil.FormatJSON, unveil.FormatYAML))
+	assert.Equal(t, []string{"posix", "fish", "pwsh", "csh", "cmd"}, strs(unveil.ShellPOSIX, unveil.ShellFish, unveil.ShellPwsh, unveil.ShellCsh, unveil.ShellCmd))
+	assert.Equal(t, []string{"sorted", "declared"}, strs(unveil.OrderSorted, unveil.OrderDeclared))
+	assert.Equal(t, []string{"source-missing", "source-unreadable", "parse", "key-not-found", "invalid-value"},
+		strs(unveil.ReasonMissing, unveil.ReasonRead, unveil.ReasonParse, unveil.ReasonNotFound, unveil.ReasonInvalid))
+}
+
+func TestExtract(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.env")
+	require.NoError(t, os.WriteFile(path, []byte("TOKEN=hunter2\nPORT=http\n"), 0o600))
+
+	t.Run("Secret values never appear in errors", func(t *testing.T) {
+		t.Parallel()
+		_, err := unveil.Extract(context.Background(), []unveil.Spec{
+			{Kind: unveil.KindFile, Path: path, Key: "TOKEN", Var: "TOKEN", Secret: true, Rules: unveil.Rules{Type: unveil.TypeInt}},
+		})
+		require.Error(t, err)
+		assert.EqualError(t, err, `file "TOKEN": invalid value "***": not a valid int`)
+		var e *unveil.Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, unveil.ReasonInvalid, e.Reason)
+	})
+
+	t.Run("Canceled context", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := unveil.Extract(ctx, []unveil.Spec{{Kind: unveil.KindFile, Path: path, Key: "PORT", Var: "PORT"}})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Round trip through Render", func(t *testing.T) {
+		t.Parallel()
+		res, err := unveil.Extract(context.Background(), []unveil.Spec{{Kind: unveil.KindFile, Path: path, Key: "PORT", Var: "PORT"}})
+		require.NoError(t, err)
+		data, err := unveil.Render(res, unveil.Options{Format: unveil.FormatSystemd})
+		require.NoError(t, err)
+		assert.Equal(t, "PORT=\"http\"\n", string(data))
+	})
+}
+
+// strs converts typed string constants for comparison.
+func strs[T ~string](values ...T) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		out = append(out, string(v))
+	}
+	return out
+}
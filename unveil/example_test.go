@@ -0,0 +1,64 @@
+package unveil_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gi8lino/unveil/unveil"
+)
+
+func ExampleExtract() {
+	dir, _ := os.MkdirTemp("", "unveil-example")
+	defer os.RemoveAll(dir) // nolint:errcheck
+	path := filepath.Join(dir, "config.yaml")
+	_ = os.WriteFile(path, []byte("db:\n  host: db.local\n  port: 5432\n"), 0o600)
+
+	res, err := unveil.Extract(context.Background(), []unveil.Spec{
+		{Kind: unveil.KindYAML, Path: path, Key: "db.host", Var: "DB_HOST", Quote: unveil.QuoteDouble},
+		{Kind: unveil.KindYAML, Path: path, Key: "db.port", Var: "DB_PORT", Rules: unveil.Rules{Type: unveil.TypePort}},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i, v := range res.Vars {
+		fmt.Printf("%s=%s (line %d)\n", v.Name, v.Value, res.Origins[i].Line)
+	}
+	// Output:
+	// DB_HOST="db.local" (line 2)
+	// DB_PORT=5432 (line 3)
+}
+
+func ExampleWrite() {
+	res := unveil.Result{Vars: []unveil.Var{
+		{Name: "PORT", Value: "8080"},
+		{Name: "HOST", Value: "example.com"},
+	}}
+
+	_ = unveil.Write(os.Stdout, res, unveil.Options{Export: true})
+	_ = unveil.Write(os.Stdout, res, unveil.Options{Format: unveil.FormatTFVars, Order: unveil.OrderDeclared})
+	// Output:
+	// export HOST=example.com
+	// export PORT=8080
+	// PORT = "8080"
+	// HOST = "example.com"
+}
+
+func ExampleWriteFile() {
+	dir, _ := os.MkdirTemp("", "unveil-example")
+	defer os.RemoveAll(dir) // nolint:errcheck
+	path := filepath.Join(dir, "app.env")
+
+	res := unveil.Result{Vars: []unveil.Var{{Name: "TOKEN", Value: "s3cret"}}}
+	if err := unveil.WriteFile(path, res, unveil.Options{File: unveil.FileOptions{Mode: 0o640}}); err != nil {
+		fmt.Println(err)
+		return
+	}
+	info, _ := os.Stat(path)
+	data, _ := os.ReadFile(path)
+	fmt.Printf("%s %s", info.Mode().Perm(), data)
+	// Output:
+	// -rw-r----- TOKEN=s3cret
+}
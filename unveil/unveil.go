@@ -0,0 +1,224 @@
+// Package unveil extracts values from JSON, YAML, TOML, INI and key=value
+// files and from secrets of HashiCorp Vault, and writes them as environment
+// assignments or in another registered format. It is the library behind the
+// unveil command.
+//
+// Build a Spec per variable, resolve them with Extract and write the Result
+// with Write, WriteFile or Render. Files encrypted with age (see
+// Spec.Decrypt) or SOPS are decrypted in memory; SOPS files use the age
+// identity file in Spec.Identity or $SOPS_AGE_KEY_FILE. Vault is configured
+// with the VAULT_* environment variables. A Path that is an http(s) URL is
+// fetched with the options in Spec.HTTP.
+package unveil
+
+import (
+	"context"
+	"io"
+
+	"github.com/gi8lino/unveil/internal/extract"
+	"github.com/gi8lino/unveil/internal/fetch"
+	"github.com/gi8lino/unveil/internal/output"
+	"github.com/gi8lino/unveil/internal/quote"
+	"github.com/gi8lino/unveil/internal/spec"
+	"github.com/gi8lino/unveil/internal/validate"
+)
+
+type (
+	// Spec describes how to extract one variable.
+	Spec = spec.ExtractSpec
+	// Kind is the format of a source file.
+	Kind = spec.Kind
+	// Decryption is how a source file is decrypted before it is parsed.
+	Decryption = spec.Decryption
+	// HTTPOptions configure the request of a Spec whose Path is a URL.
+	HTTPOptions = fetch.Options
+	// Var is an extracted variable, quoted according to its Spec.
+	Var = spec.Var
+	// Origin describes where the value of a variable came from.
+	Origin = extract.Origin
+	// Source reads and evaluates the documents of one kind.
+	Source = extract.Source
+	// Describer is implemented by sources that tell the unveil command how to
+	// offer their kind.
+	Describer = extract.Describer
+	// Description tells the unveil command how to offer a source kind.
+	Description = extract.Description
+	// Scalar is a leaf value of a document returned by Source.Parse.
+	Scalar = extract.Scalar
+	// Quote is how a value is quoted before it is written.
+	Quote = quote.QuoteKind
+	// Rules are the constraints a value must satisfy.
+	Rules = validate.Rules
+	// Range is an inclusive numeric range for Rules.
+	Range = validate.Range
+	// Type is the expected type of a value for Rules.
+	Type = validate.Type
+	// Options controls how variables are written.
+	Options = output.Options
+	// FileOptions controls the permissions of files written by WriteFile.
+	FileOptions = output.FileOptions
+	// Owner is the owner of a file written by WriteFile.
+	Owner = output.Owner
+	// Format is the syntax of the output.
+	Format = output.Format
+	// Shell is the dialect of FormatEnv output.
+	Shell = quote.Shell
+	// Order is the order in which variables are written.
+	Order = output.Order
+	// Formatter renders variables in one output syntax.
+	Formatter = output.Formatter
+	// Merger is implemented by formatters that support Options.Merge.
+	Merger = output.Merger
+	// Error is returned by Extract for the spec that failed.
+	Error = extract.Error
+	// Reason classifies why a spec could not be extracted.
+	Reason = extract.Reason
+)
+
+// Source kinds.
+const (
+	KindJSON  = spec.KindJSON
+	KindYAML  = spec.KindYAML
+	KindTOML  = spec.KindTOML
+	KindINI   = spec.KindINI
+	KindFile  = spec.KindFILE
+	KindVault = spec.KindVault
+)
+
+// Decryptions of source files.
+const (
+	DecryptNone = spec.DecryptNone
+	DecryptAge  = spec.DecryptAge
+)
+
+// Quote modes. Formats other than FormatEnv escape values themselves and
+// expect QuoteNone; non-POSIX shells expect Shell.QuoteKind.
+const (
+	QuoteNone   = quote.QuoteNone
+	QuoteSingle = quote.QuoteSingle
+	QuoteDouble = quote.QuoteDouble
+	QuoteJSON   = quote.QuoteJSON
+)
+
+// Value types for Rules.
+const (
+	TypeString   = validate.TypeString
+	TypeInt      = validate.TypeInt
+	TypeBool     = validate.TypeBool
+	TypeDuration = validate.TypeDuration
+	TypeURL      = validate.TypeURL
+	TypeEmail    = validate.TypeEmail
+	TypePort     = validate.TypePort
+)
+
+// Output formats.
+const (
+	FormatEnv        = output.FormatEnv
+	FormatDockerEnv  = output.FormatDockerEnv
+	FormatSystemd    = output.FormatSystemd
+	FormatMake       = output.FormatMake
+	FormatTFVars     = output.FormatTFVars
+	FormatTFVarsJSON = output.FormatTFVarsJSON
+	FormatJSON       = output.FormatJSON
+	FormatYAML       = output.FormatYAML
+)
+
+// Shell dialects for FormatEnv.
+const (
+	ShellPOSIX = quote.ShellPOSIX
+	ShellFish  = quote.ShellFish
+	ShellPwsh  = quote.ShellPwsh
+	ShellCsh   = quote.ShellCsh
+	ShellCmd   = quote.ShellCmd
+)
+
+// Orders.
+const (
+	OrderSorted   = output.OrderSorted
+	OrderDeclared = output.OrderDeclared
+)
+
+// Reasons of Error.
+const (
+	ReasonMissing  = extract.ReasonMissing
+	ReasonRead     = extract.ReasonRead
+	ReasonParse    = extract.ReasonParse
+	ReasonNotFound = extract.ReasonNotFound
+	ReasonInvalid  = extract.ReasonInvalid
+)
+
+// Errors returned by WriteFile.
+var (
+	ErrHookFailed  = output.ErrHookFailed  // the file was written but Options.OnWrite failed
+	ErrInsecureDir = output.ErrInsecureDir // FileOptions.Private refused a world-readable directory
+)
+
+// Register makes src available for kind, in Extract and as a flag group of
+// the unveil command. It panics if kind is already registered, so call it
+// from an init function.
+func Register(kind Kind, src Source) {
+	extract.Register(kind, src)
+}
+
+// Kinds returns the registered kinds, the built-in ones first.
+func Kinds() []Kind {
+	return extract.Kinds()
+}
+
+// RegisterFormat makes fm available as format, in Write and as a choice of
+// --format of the unveil command. It panics if format is already registered.
+func RegisterFormat(format Format, fm Formatter) {
+	output.RegisterFormat(format, fm)
+}
+
+// Formats returns the registered formats, the built-in ones first.
+func Formats() []Format {
+	return output.Formats()
+}
+
+// Result holds the extracted variables.
+type Result struct {
+	Vars    []Var    // variables in spec order; a repeated variable keeps its first position and last value
+	Origins []Origin // where each variable came from, in the order of Vars
+}
+
+// Extract resolves specs. Values are rendered canonically (or as written if
+// Spec.Literal is set), checked against Spec.Rules and quoted according to
+// Spec.Quote. A failing spec is reported as an *Error, whose message never
+// contains the values of secret specs. Extract stops with ctx.Err() once ctx
+// is done.
+func Extract(ctx context.Context, specs []Spec) (Result, error) {
+	vars, origins, err := extract.Explain(ctx, specs)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Vars: vars, Origins: origins}, nil
+}
+
+// ExtractParallel is Extract reading up to parallel specs at once. The result
+// is the same as with Extract; of several failing specs, the first one in spec
+// order is reported.
+func ExtractParallel(ctx context.Context, specs []Spec, parallel int) (Result, error) {
+	vars, origins, err := extract.Resolve(ctx, specs, parallel)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Vars: vars, Origins: origins}, nil
+}
+
+// Write writes one assignment per variable of res to w.
+func Write(w io.Writer, res Result, opts Options) error {
+	return output.WriteEnvLines(w, res.Vars, opts)
+}
+
+// Render returns what Write writes.
+func Render(res Result, opts Options) ([]byte, error) {
+	return output.Render(res.Vars, opts)
+}
+
+// WriteFile atomically replaces (or with opts.Merge, updates) the file at
+// path, creating parent directories, with the permissions of opts.File.
+// opts.OnWrite is called after the file was written.
+func WriteFile(path string, res Result, opts Options) error {
+	return output.WriteEnvLinesAtomic(path, res.Vars, opts)
+}